@@ -10,52 +10,79 @@ Package main.
 package main
 
 import (
+	"bufio"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"net"
 	"os"
 	"os/signal"
+	"pkg/bans"
 	"pkg/config"
 	"pkg/config/parser"
+	"pkg/history"
 	"pkg/html"
 	"pkg/messageplexer"
+	"pkg/rawhttp"
 	"pkg/reqtraps"
-	"strconv"
+	"pkg/transcript"
 	"strings"
 	"syscall"
+	"time"
 )
 
 const cherryVersion = "1.1"
 
+// requestReadTimeout is the amount of time that a client has to send its whole request.
+const requestReadTimeout = 30 * time.Second
+
 func processNewConnection(newConn net.Conn, roomName string, rooms *config.CherryRooms) {
-	buf := make([]byte, 4096)
-	bufLen, err := newConn.Read(buf)
+	newConn.SetReadDeadline(time.Now().Add(requestReadTimeout))
+	req, err := rawhttp.ReadRequest(bufio.NewReader(newConn))
 	if err == nil {
+		newConn.SetReadDeadline(time.Time{})
 		preprocessor := html.NewHTMLPreprocessor(rooms)
-		httpPayload := string(buf[:bufLen])
 		var trap reqtraps.RequestTrap
-		trap = reqtraps.GetRequestTrap(httpPayload)
-		trap().Handle(newConn, roomName, httpPayload, rooms, preprocessor)
+		trap, roomName = reqtraps.GetRequestTrap(req, roomName, rooms)
+		trap().Handle(newConn, roomName, req, rooms, preprocessor)
 	} else {
+		if err == rawhttp.ErrRequestTooLarge {
+			newConn.Write(rawhttp.MakeReplyBuffer(html.GetBadAssErrorData(), 413, true))
+		} else if err == rawhttp.ErrBadRequest {
+			newConn.Write(rawhttp.MakeReplyBuffer(html.GetBadAssErrorData(), 400, true))
+		}
 		newConn.Close()
 	}
 }
 
-func peer(roomName string, c *config.CherryRooms) {
-	port := c.GetListenPort(roomName)
-	var portNum int64
-	portNum, _ = strconv.ParseInt(port, 10, 16)
-	var err error
-	var room *config.RoomConfig
-	room = c.GetRoomByPort(int16(portNum))
-	room.MainPeer, err = net.Listen("tcp", c.GetServerName()+":"+port)
+func listen(port, certificateFile, keyFile string, c *config.CherryRooms) (net.Listener, error) {
+	listener, err := net.Listen("tcp", c.GetServerName()+":"+port)
 	if err != nil {
-		fmt.Println("ERROR: " + err.Error())
-		os.Exit(1)
+		return nil, err
 	}
-	defer room.MainPeer.Close()
+	if len(certificateFile) > 0 && len(keyFile) > 0 {
+		//  INFO(Santiago): All connections accepted from here (including the long-lived body streams)
+		//                  will be transparently encrypted.
+		var certificate tls.Certificate
+		certificate, err = tls.LoadX509KeyPair(certificateFile, keyFile)
+		if err != nil {
+			listener.Close()
+			return nil, err
+		}
+		listener = tls.NewListener(listener, &tls.Config{Certificates: []tls.Certificate{certificate}})
+	}
+	return listener, nil
+}
+
+func accept(listener net.Listener, roomName string, c *config.CherryRooms) {
+	defer listener.Close()
 	for {
-		conn, err := room.MainPeer.Accept()
+		conn, err := listener.Accept()
 		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				//  INFO(Santiago): The room was removed.
+				return
+			}
 			fmt.Println(err.Error())
 			continue
 		}
@@ -63,6 +90,95 @@ func peer(roomName string, c *config.CherryRooms) {
 	}
 }
 
+func peer(roomName string, c *config.CherryRooms) error {
+	port := c.GetListenPort(roomName)
+	listener, err := listen(port, c.GetTLSCertificate(roomName), c.GetTLSKey(roomName), c)
+	if err != nil {
+		return err
+	}
+	c.GetRoom(roomName).MainPeer = listener
+	go accept(listener, roomName, c)
+	return nil
+}
+
+// startRooms puts the listeners and message plexers of the passed rooms to work. The shared port listener
+// is created when some room needs it and @sharedListener is nil. The (maybe new) shared listener is returned.
+func startRooms(rooms []string, sharedListener net.Listener, c *config.CherryRooms) (net.Listener, error) {
+	for _, r := range rooms {
+		if !c.IsSharingPort(r) {
+			if err := peer(r, c); err != nil {
+				return sharedListener, fmt.Errorf("room \"%s\": %s", r, err.Error())
+			}
+		} else {
+			if sharedListener == nil {
+				//  INFO(Santiago): Rooms on the shared port always use the cherry.root's certificate.
+				var err error
+				sharedListener, err = listen(c.GetSharedPort(), c.GetTLSCertificate(r), c.GetTLSKey(r), c)
+				if err != nil {
+					return nil, fmt.Errorf("shared port: %s", err.Error())
+				}
+				//  INFO(Santiago): Here the room is unknown until each request comes.
+				go accept(sharedListener, "", c)
+			}
+			c.GetRoom(r).MainPeer = sharedListener
+		}
+		go messageplexer.RoomMessagePlexer(r, c)
+	}
+	return sharedListener, nil
+}
+
+// reloadRooms parses the cherry file again and applies the differences to the running rooms. When the cherry
+// file has errors nothing is changed.
+func reloadRooms(configPath string, sharedListener net.Listener, c *config.CherryRooms) net.Listener {
+	newRooms, err := parser.ParseCherryFile(configPath)
+	if err != nil {
+		fmt.Println(err.Error())
+		fmt.Println("WARN: the cherry file was not reloaded, the running rooms were kept untouched.")
+		return sharedListener
+	}
+	if newRooms.GetServername() != c.GetServername() || newRooms.GetSharedPort() != c.GetSharedPort() ||
+		newRooms.GetHistoryDirectory() != c.GetHistoryDirectory() {
+		fmt.Println("WARN: changes in cherry.root's servername, shared-port or history-directory will take effect only after restarting.")
+	}
+	for _, r := range c.GetRooms() {
+		if !newRooms.HasRoom(r) {
+			c.RemoveRoom(r)
+			fmt.Println("INFO: room \"" + r + "\" was closed.")
+		}
+	}
+	var addedRooms []string
+	for _, r := range newRooms.GetRooms() {
+		if c.HasRoom(r) {
+			if !c.ReloadRoom(r, newRooms) {
+				fmt.Println("WARN: changes in the listen port or certificates of room \"" + r + "\" will take effect only after restarting.")
+			}
+		} else if c.AdoptRoom(r, newRooms) {
+			addedRooms = append(addedRooms, r)
+			if indexErr := c.IndexHistory(r); indexErr != nil {
+				fmt.Println("WARN: the history of room \"" + r + "\" is not fully searchable [more details: " + indexErr.Error() + "].")
+			}
+		} else {
+			fmt.Println("WARN: room \"" + r + "\" was not opened, its port is busy.")
+		}
+	}
+	for _, r := range addedRooms {
+		var startErr error
+		sharedListener, startErr = startRooms([]string{r}, sharedListener, c)
+		if startErr != nil {
+			fmt.Println("ERROR: " + startErr.Error())
+			//  INFO(Santiago): A room without listener is useless.
+			c.RemoveRoom(r)
+		} else {
+			fmt.Println("INFO: room \"" + r + "\" was opened.")
+		}
+	}
+	if bansErr := c.GetBans().Reload(); bansErr != nil {
+		fmt.Println("WARN: the ban list was not reloaded [more details: " + bansErr.Error() + "].")
+	}
+	fmt.Println("INFO: the cherry file was reloaded.")
+	return sharedListener
+}
+
 func getOption(option, defaultValue string, flagOption ...bool) string {
 	isFlagOption := false
 	if len(flagOption) > 0 {
@@ -90,6 +206,76 @@ func announceVersion() {
 
 func offerHelp() {
 	fmt.Println("usage: cherry [--config=<cherry config filepath> | --help | --version]")
+	fmt.Println("       cherry --config=<cherry config filepath> --export-transcript=<room> [--since=<time>] [--until=<time>] " +
+		"[--format=html|json|text]")
+	fmt.Println("       cherry --hash-password < <file with the password>")
+}
+
+// hashPassword writes the hash of the password read from the standard input, ready to be put in the cherry
+// file. It returns the exit code.
+func hashPassword() int {
+	password, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	password = strings.TrimRight(password, "\r\n")
+	if len(password) == 0 {
+		fmt.Fprintln(os.Stderr, "ERROR: no password was given.")
+		return 1
+	}
+	secret, err := config.HashPassword(password, config.PasswordIterations)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "ERROR: "+err.Error()+".")
+		return 1
+	}
+	fmt.Println(secret)
+	return 0
+}
+
+// exportTranscript writes the transcript of a room to the standard output. It returns the exit code.
+func exportTranscript(configPath, roomName string) int {
+	cherryRooms, err := parser.ParseCherryFile(configPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	if !cherryRooms.HasRoom(roomName) {
+		fmt.Fprintln(os.Stderr, "ERROR: there is no room \""+roomName+"\".")
+		return 1
+	}
+	if len(cherryRooms.GetHistoryDirectory()) == 0 {
+		fmt.Fprintln(os.Stderr, "ERROR: cherry.root has no history-directory, there is no transcript to export.")
+		return 1
+	}
+	format := getOption("format", "text")
+	if !transcript.IsValidFormat(format) {
+		fmt.Fprintln(os.Stderr, "ERROR: unknown transcript format \""+format+"\".")
+		return 1
+	}
+	failure := func(err error) int {
+		fmt.Fprintln(os.Stderr, "ERROR: "+err.Error()+".")
+		return 1
+	}
+	since, timeErr := transcript.ParseTime(getOption("since", ""))
+	if timeErr != nil {
+		return failure(timeErr)
+	}
+	until, timeErr := transcript.ParseTime(getOption("until", ""))
+	if timeErr != nil {
+		return failure(timeErr)
+	}
+	store, storeErr := history.NewFileStore(cherryRooms.GetHistoryDirectory())
+	if storeErr != nil {
+		return failure(storeErr)
+	}
+	defer store.Close()
+	entries, storeErr := store.Range(roomName, since, until)
+	if storeErr != nil {
+		return failure(storeErr)
+	}
+	data, exportErr := transcript.Export(cherryRooms, roomName, entries, format)
+	if exportErr != nil {
+		return failure(exportErr)
+	}
+	fmt.Print(data)
+	return 0
 }
 
 func openRooms(configPath string) {
@@ -99,18 +285,48 @@ func openRooms(configPath string) {
 	if err != nil {
 		fmt.Println(err.Error())
 		os.Exit(1)
-	} else {
-		rooms := cherryRooms.GetRooms()
-		for _, r := range rooms {
-			go messageplexer.RoomMessagePlexer(r, cherryRooms)
-			go peer(r, cherryRooms)
+	}
+	if len(cherryRooms.GetHistoryDirectory()) > 0 {
+		store, historyErr := history.NewFileStore(cherryRooms.GetHistoryDirectory())
+		if historyErr != nil {
+			fmt.Println("ERROR: unable to open the history directory [more details: " + historyErr.Error() + "].")
+			os.Exit(1)
 		}
+		cherryRooms.SetHistory(store)
+		defer store.Close()
+		for _, r := range cherryRooms.GetRooms() {
+			if indexErr := cherryRooms.IndexHistory(r); indexErr != nil {
+				fmt.Println("WARN: the history of room \"" + r + "\" is not fully searchable [more details: " + indexErr.Error() + "].")
+			}
+		}
+	}
+	banList, bansErr := bans.Load(configPath + ".bans")
+	if bansErr != nil {
+		fmt.Println("ERROR: unable to load the ban list [more details: " + bansErr.Error() + "].")
+		os.Exit(1)
+	}
+	cherryRooms.SetBans(banList)
+	sharedListener, startErr := startRooms(cherryRooms.GetRooms(), nil, cherryRooms)
+	if startErr != nil {
+		fmt.Println("ERROR: " + startErr.Error())
+		os.Exit(1)
 	}
 	sigintWatchdog := make(chan os.Signal, 1)
 	signal.Notify(sigintWatchdog, os.Interrupt)
 	signal.Notify(sigintWatchdog, syscall.SIGINT|syscall.SIGTERM)
-	<-sigintWatchdog
-	cleanup()
+	sighupWatchdog := make(chan os.Signal, 1)
+	signal.Notify(sighupWatchdog, syscall.SIGHUP)
+	for {
+		select {
+		case <-sighupWatchdog:
+			sharedListener = reloadRooms(configPath, sharedListener, cherryRooms)
+			break
+
+		case <-sigintWatchdog:
+			cleanup()
+			return
+		}
+	}
 }
 
 func main() {
@@ -124,10 +340,16 @@ func main() {
 		offerHelp()
 		os.Exit(0)
 	}
+	if len(getOption("hash-password", "", true)) > 0 {
+		os.Exit(hashPassword())
+	}
 	configPath := getOption("config", "")
 	if len(configPath) == 0 {
 		offerHelp()
 		os.Exit(1)
 	}
+	if roomName := getOption("export-transcript", ""); len(roomName) > 0 {
+		os.Exit(exportTranscript(configPath, roomName))
+	}
 	openRooms(configPath)
 }
@@ -0,0 +1,114 @@
+/*
+ *                                Copyright (C) 2016 by Rafael Santiago
+ *
+ * This is a free software. You can redistribute it and/or modify under
+ * the terms of the GNU General Public License version 2.
+ *
+ */
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"path/filepath"
+	"pkg/config"
+	"testing"
+	"time"
+)
+
+// writeSelfSignedCertificate writes a throwaway certificate for "localhost" and its key into @directory.
+func writeSelfSignedCertificate(t *testing.T, directory string) (string, string, *x509.Certificate) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1"), net.ParseIP("::1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certificate, _ := x509.ParseCertificate(der)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certificateFile := filepath.Join(directory, "cert.pem")
+	keyFile := filepath.Join(directory, "key.pem")
+	ioutil.WriteFile(certificateFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+	return certificateFile, keyFile, certificate
+}
+
+func TestTLSListener(t *testing.T) {
+	rooms := config.NewCherryRooms()
+	directory := t.TempDir()
+	certificateFile, keyFile, certificate := writeSelfSignedCertificate(t, directory)
+
+	if _, err := listen("0", certificateFile, filepath.Join(directory, "nowhere.pem"), rooms); err == nil {
+		t.Error("a listener was created without its key.")
+	}
+
+	listener, err := listen("0", certificateFile, keyFile, rooms)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		conn.Write([]byte("HTTP/1.1 200 OK\r\n\r\n"))
+		conn.Close()
+	}()
+	roots := x509.NewCertPool()
+	roots.AddCert(certificate)
+	conn, err := tls.Dial("tcp", listener.Addr().String(), &tls.Config{RootCAs: roots, ServerName: "localhost"})
+	if err != nil {
+		t.Fatalf("the handshake has failed: %v", err)
+	}
+	defer conn.Close()
+	if reply, _ := ioutil.ReadAll(conn); string(reply) != "HTTP/1.1 200 OK\r\n\r\n" {
+		t.Errorf("unexpected reply: %q", reply)
+	}
+
+	//  INFO(Santiago): Without certificate the listener speaks plain text.
+	plain, err := listen("0", "", "", rooms)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer plain.Close()
+	go func() {
+		conn, err := plain.Accept()
+		if err != nil {
+			return
+		}
+		conn.Write([]byte("boo!"))
+		conn.Close()
+	}()
+	plainConn, err := net.Dial("tcp", plain.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer plainConn.Close()
+	if reply, _ := ioutil.ReadAll(plainConn); string(reply) != "boo!" {
+		t.Errorf("unexpected plain reply: %q", reply)
+	}
+}
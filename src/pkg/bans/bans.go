@@ -0,0 +1,253 @@
+/*
+Package bans keeps people out of the rooms by nickname, address or address range.
+--
+ *                               Copyright (C) 2015 by Rafael Santiago
+ *
+ * This is a free software. You can redistribute it and/or modify under
+ * the terms of the GNU General Public License version 2.
+ *
+*/
+package bans
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Ban keeps out of a room who uses a nickname matching the Nickname pattern (e.g. "agent*") or comes from
+// the Address, that can be an IP or a CIDR range. A ban with both fields matches any of them.
+type Ban struct {
+	Room     string    `json:"room"`
+	Nickname string    `json:"nickname,omitempty"`
+	Address  string    `json:"address,omitempty"`
+	Until    time.Time `json:"until"`
+	By       string    `json:"by,omitempty"`
+}
+
+// List is the ban list of all rooms. When it has a file, each change is written to it.
+type List struct {
+	mutex    *sync.Mutex
+	filepath string
+	bans     []Ban
+}
+
+// NewList creates an empty list that is never written.
+func NewList() *List {
+	return &List{mutex: new(sync.Mutex)}
+}
+
+// Load reads the list kept in a file with one JSON ban per line. A missing file is an empty list.
+func Load(filepath string) (*List, error) {
+	list := &List{mutex: new(sync.Mutex), filepath: filepath}
+	if err := list.Reload(); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// Reload reads the list file again (it was edited by hand). When the file has errors the list is not changed.
+func (l *List) Reload() error {
+	if len(l.filepath) == 0 {
+		return nil
+	}
+	data, err := ioutil.ReadFile(l.filepath)
+	if os.IsNotExist(err) {
+		data, err = nil, nil
+	}
+	if err != nil {
+		return err
+	}
+	var bans []Ban
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+		var ban Ban
+		if err = json.Unmarshal(scanner.Bytes(), &ban); err == nil {
+			err = ban.Validate()
+		}
+		if err != nil {
+			return fmt.Errorf("%s:%d: %s", l.filepath, line, err.Error())
+		}
+		bans = append(bans, ban)
+	}
+	if err = scanner.Err(); err != nil {
+		return err
+	}
+	l.mutex.Lock()
+	l.bans = bans
+	l.mutex.Unlock()
+	return nil
+}
+
+// Validate verifies if a ban is well-formed.
+func (b Ban) Validate() error {
+	if len(b.Room) == 0 {
+		return fmt.Errorf("ban without room")
+	}
+	if len(b.Nickname) == 0 && len(b.Address) == 0 {
+		return fmt.Errorf("ban without nickname and address")
+	}
+	if _, err := path.Match(b.Nickname, ""); err != nil {
+		return fmt.Errorf("invalid nickname pattern \"%s\"", b.Nickname)
+	}
+	if len(b.Address) > 0 && !IsAddress(b.Address) {
+		return fmt.Errorf("invalid address \"%s\"", b.Address)
+	}
+	return nil
+}
+
+// IsExpired verifies if a ban is over.
+func (b Ban) IsExpired(now time.Time) bool {
+	return !b.Until.IsZero() && now.After(b.Until)
+}
+
+// Matches verifies if a ban applies to who uses @nickname and comes from @addr (any of them can be empty).
+func (b Ban) Matches(nickname, addr string) bool {
+	if len(b.Nickname) > 0 && len(nickname) > 0 {
+		//  INFO(Santiago): "Dunha" and "DUNHA" must not get around a ban on "dunha".
+		if matched, _ := path.Match(strings.ToLower(b.Nickname), strings.ToLower(nickname)); matched {
+			return true
+		}
+	}
+	if len(b.Address) == 0 || len(addr) == 0 {
+		return false
+	}
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	if _, ipNet, err := net.ParseCIDR(b.Address); err == nil {
+		return ipNet.Contains(ip)
+	}
+	return ip.Equal(net.ParseIP(b.Address))
+}
+
+// String describes what a ban keeps out.
+func (b Ban) String() string {
+	var targets []string
+	if len(b.Nickname) > 0 {
+		targets = append(targets, b.Nickname)
+	}
+	if len(b.Address) > 0 {
+		targets = append(targets, b.Address)
+	}
+	description := strings.Join(targets, " / ")
+	if !b.Until.IsZero() {
+		description += " until " + b.Until.Format("2006-01-02 15:04")
+	}
+	return description
+}
+
+// IsAddress verifies if a target is an IP or a CIDR range (otherwise it is a nickname pattern).
+func IsAddress(target string) bool {
+	if _, _, err := net.ParseCIDR(target); err == nil {
+		return true
+	}
+	return net.ParseIP(target) != nil
+}
+
+// Add puts a ban in the list.
+func (l *List) Add(ban Ban) error {
+	if err := ban.Validate(); err != nil {
+		return err
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.bans = append(l.bans, ban)
+	return l.save()
+}
+
+// Remove lifts the bans of a room whose nickname pattern or address is @target. It returns how many were lifted.
+func (l *List) Remove(roomName, target string) (int, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	var bans []Ban
+	for _, ban := range l.bans {
+		if ban.Room != roomName || (ban.Nickname != target && ban.Address != target) {
+			bans = append(bans, ban)
+		}
+	}
+	removed := len(l.bans) - len(bans)
+	if removed == 0 {
+		return 0, nil
+	}
+	l.bans = bans
+	return removed, l.save()
+}
+
+// IsBanned verifies if who uses @nickname and comes from @addr is banned from a room.
+func (l *List) IsBanned(roomName, nickname, addr string) bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	now := time.Now()
+	for _, ban := range l.bans {
+		if ban.Room == roomName && !ban.IsExpired(now) && ban.Matches(nickname, addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// Get returns the bans of a room that are still in force.
+func (l *List) Get(roomName string) []Ban {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	now := time.Now()
+	var bans []Ban
+	for _, ban := range l.bans {
+		if ban.Room == roomName && !ban.IsExpired(now) {
+			bans = append(bans, ban)
+		}
+	}
+	return bans
+}
+
+// save writes the list (without the expired bans) to its file. WARN(Santiago): It must be called with the list mutex acquired.
+func (l *List) save() error {
+	now := time.Now()
+	var bans []Ban
+	var data []byte
+	for _, ban := range l.bans {
+		if ban.IsExpired(now) {
+			continue
+		}
+		bans = append(bans, ban)
+		line, err := json.Marshal(ban)
+		if err != nil {
+			return err
+		}
+		data = append(append(data, line...), '\n')
+	}
+	l.bans = bans
+	if len(l.filepath) == 0 {
+		return nil
+	}
+	//  INFO(Santiago): The new list replaces the old one at once, a crash while writing it does not lose the bans.
+	temp, err := ioutil.TempFile(filepath.Dir(l.filepath), filepath.Base(l.filepath)+".")
+	if err != nil {
+		return err
+	}
+	_, err = temp.Write(data)
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(temp.Name(), l.filepath)
+	}
+	if err != nil {
+		os.Remove(temp.Name())
+	}
+	return err
+}
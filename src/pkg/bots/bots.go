@@ -0,0 +1,195 @@
+/*
+Package bots runs the room participants written in Go.
+--
+ *                               Copyright (C) 2015 by Rafael Santiago
+ *
+ * This is a free software. You can redistribute it and/or modify under
+ * the terms of the GNU General Public License version 2.
+ *
+*/
+package bots
+
+import (
+	"fmt"
+	"pkg/config"
+	"sort"
+	"sync"
+)
+
+// Bot is a room participant written in Go. Its methods are called from the bot's own goroutine, one at a time.
+type Bot interface {
+	// OnMessage is called for each message delivered in the room. The private messages are only seen when
+	// sent to the bot and the messages of other bots are never seen.
+	OnMessage(room *Room, message config.Message)
+	// OnJoin is called when someone enters the room.
+	OnJoin(room *Room, user string)
+	// OnLeave is called when someone leaves the room.
+	OnLeave(room *Room, user string)
+}
+
+// Factory creates a bot from the argument given in the cherry file.
+type Factory func(argument string) (Bot, error)
+
+// Room is the room where a bot lives, as seen by the bot.
+type Room struct {
+	Name     string
+	Nickname string
+	rooms    *config.CherryRooms
+}
+
+// runner delivers the room events to a bot.
+type runner struct {
+	nickname string
+	bot      Bot
+	events   chan config.RoomEvent
+	quit     chan bool
+	start    *sync.Once
+	stop     *sync.Once
+}
+
+// maxPendingEvents is how many events can wait for a busy bot, the next ones are dropped.
+const maxPendingEvents = 64
+
+var factories = struct {
+	sync.RWMutex
+	kinds map[string]Factory
+}{kinds: make(map[string]Factory)}
+
+func init() {
+	Register("echo", NewEchoBot)
+	Register("faq", NewFAQBot)
+}
+
+// Register makes a kind of bot available to the cherry files.
+func Register(kind string, factory Factory) {
+	factories.Lock()
+	factories.kinds[kind] = factory
+	factories.Unlock()
+}
+
+// GetKinds returns the kinds of bot that can be used (sorted).
+func GetKinds() []string {
+	factories.RLock()
+	kinds := make([]string, 0, len(factories.kinds))
+	for kind := range factories.kinds {
+		kinds = append(kinds, kind)
+	}
+	factories.RUnlock()
+	sort.Strings(kinds)
+	return kinds
+}
+
+// New creates a bot of some kind.
+func New(kind, argument string) (Bot, error) {
+	factories.RLock()
+	factory, exists := factories.kinds[kind]
+	factories.RUnlock()
+	if !exists {
+		return nil, fmt.Errorf("unknown bot kind \"%s\"", kind)
+	}
+	return factory(argument)
+}
+
+// Attach puts a bot in a room. The bot starts running with the first event of the room.
+func Attach(rooms *config.CherryRooms, roomName, nickname string, bot Bot) {
+	rooms.AddBot(roomName, nickname, &runner{nickname: nickname,
+		bot:    bot,
+		events: make(chan config.RoomEvent, maxPendingEvents),
+		quit:   make(chan bool),
+		start:  new(sync.Once),
+		stop:   new(sync.Once)})
+}
+
+// Notify queues an event for the bot.
+func (r *runner) Notify(event config.RoomEvent) {
+	r.start.Do(func() {
+		go r.run()
+	})
+	select {
+	case r.events <- event:
+	default:
+	}
+}
+
+// Stop makes the bot give up.
+func (r *runner) Stop() {
+	r.stop.Do(func() {
+		close(r.quit)
+	})
+}
+
+func (r *runner) run() {
+	for {
+		select {
+		case <-r.quit:
+			return
+
+		case event := <-r.events:
+			r.handle(event)
+		}
+	}
+}
+
+func (r *runner) handle(event config.RoomEvent) {
+	defer func() {
+		//  INFO(Santiago): A broken bot should not take the server down.
+		if err := recover(); err != nil {
+			fmt.Printf("ERROR: the bot \"%s\" of \"%s\" has panicked [more details: %v].\n", r.nickname, event.RoomName, err)
+		}
+	}()
+	if !event.Rooms.HasRoom(event.RoomName) {
+		return
+	}
+	room := &Room{event.RoomName, r.nickname, event.Rooms}
+	switch event.Kind {
+	case config.MessageDelivered:
+		message := event.Message
+		if message.From == r.nickname || event.Rooms.HasBot(event.RoomName, message.From) {
+			break
+		}
+		if message.Priv == "1" && message.To != r.nickname && message.To != event.Rooms.GetAllUsersAlias(event.RoomName) {
+			break
+		}
+		r.bot.OnMessage(room, message)
+		break
+
+	case config.UserJoined:
+		r.bot.OnJoin(room, event.User)
+		break
+
+	case config.UserLeft:
+		r.bot.OnLeave(room, event.User)
+		break
+	}
+}
+
+// Say sends a public message from the bot.
+func (r *Room) Say(says string) {
+	if r.rooms.HasRoom(r.Name) {
+		r.rooms.EnqueueMessage(r.Name, r.Nickname, r.rooms.GetAllUsersAlias(r.Name), "", "", "", says, "")
+	}
+}
+
+// Tell sends a private message from the bot.
+func (r *Room) Tell(user, says string) {
+	if r.rooms.HasRoom(r.Name) {
+		r.rooms.EnqueueMessage(r.Name, r.Nickname, user, "", "", "", says, "1")
+	}
+}
+
+// Reply answers a message in the same way that it was sent (publicly or privately).
+func (r *Room) Reply(message config.Message, says string) {
+	if message.Priv == "1" {
+		r.Tell(message.From, says)
+	} else {
+		r.Say(says)
+	}
+}
+
+// Users returns who is in the room (the bots are not included).
+func (r *Room) Users() []string {
+	if !r.rooms.HasRoom(r.Name) {
+		return nil
+	}
+	return r.rooms.GetRoomUsers(r.Name)
+}
@@ -0,0 +1,37 @@
+/*
+ *                               Copyright (C) 2015 by Rafael Santiago
+ *
+ * This is a free software. You can redistribute it and/or modify under
+ * the terms of the GNU General Public License version 2.
+ *
+ */
+
+package bots
+
+import (
+	"pkg/config"
+)
+
+// EchoBot repeats what is said to it. It is useful to check if a room is alive.
+type EchoBot struct{}
+
+// NewEchoBot creates an echo bot, it has no argument.
+func NewEchoBot(argument string) (Bot, error) {
+	return &EchoBot{}, nil
+}
+
+// OnMessage repeats the messages sent to the bot.
+func (e *EchoBot) OnMessage(room *Room, message config.Message) {
+	if message.Notice || message.To != room.Nickname || len(message.Say) == 0 {
+		return
+	}
+	room.Reply(message, message.Say)
+}
+
+// OnJoin does nothing.
+func (e *EchoBot) OnJoin(room *Room, user string) {
+}
+
+// OnLeave does nothing.
+func (e *EchoBot) OnLeave(room *Room, user string) {
+}
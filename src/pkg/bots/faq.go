@@ -0,0 +1,112 @@
+/*
+ *                               Copyright (C) 2015 by Rafael Santiago
+ *
+ * This is a free software. You can redistribute it and/or modify under
+ * the terms of the GNU General Public License version 2.
+ *
+ */
+
+package bots
+
+import (
+	"fmt"
+	"io/ioutil"
+	"pkg/config"
+	"pkg/search"
+	"strings"
+)
+
+// FAQBot answers the frequently asked questions of a room.
+type FAQBot struct {
+	entries []faqEntry
+}
+
+type faqEntry struct {
+	keywords []string
+	answer   string
+}
+
+// NewFAQBot creates a FAQ bot. The argument is the path of a file where each line is "<keywords> = <answer>",
+// lines starting with "#" are comments.
+func NewFAQBot(argument string) (Bot, error) {
+	data, err := ioutil.ReadFile(argument)
+	if err != nil {
+		return nil, err
+	}
+	return ParseFAQ(string(data))
+}
+
+// ParseFAQ creates a FAQ bot from the FAQ data.
+func ParseFAQ(data string) (*FAQBot, error) {
+	faq := &FAQBot{}
+	for l, line := range strings.Split(data, "\n") {
+		line = strings.TrimSpace(line)
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		fields := strings.SplitN(line, "=", 2)
+		var entry faqEntry
+		if len(fields) == 2 {
+			entry = faqEntry{search.Tokenize(fields[0]), strings.TrimSpace(fields[1])}
+		}
+		if len(entry.keywords) == 0 || len(entry.answer) == 0 {
+			return nil, fmt.Errorf("invalid FAQ entry at line %d", l+1)
+		}
+		faq.entries = append(faq.entries, entry)
+	}
+	if len(faq.entries) == 0 {
+		return nil, fmt.Errorf("empty FAQ")
+	}
+	return faq, nil
+}
+
+// Answer returns the answer whose keywords are all in the question. When more than one answer fits, the one with
+// more keywords wins.
+func (f *FAQBot) Answer(question string) string {
+	words := make(map[string]bool)
+	for _, word := range search.Tokenize(question) {
+		words[word] = true
+	}
+	var best *faqEntry
+	for e := range f.entries {
+		entry := &f.entries[e]
+		matches := true
+		for _, keyword := range entry.keywords {
+			if !words[keyword] {
+				matches = false
+				break
+			}
+		}
+		if matches && (best == nil || len(entry.keywords) > len(best.keywords)) {
+			best = entry
+		}
+	}
+	if best == nil {
+		return ""
+	}
+	return best.answer
+}
+
+// OnMessage answers the questions sent to the bot and the questions made to everybody.
+func (f *FAQBot) OnMessage(room *Room, message config.Message) {
+	if message.Notice {
+		return
+	}
+	toBot := (message.To == room.Nickname)
+	if !toBot && !strings.HasSuffix(strings.TrimSpace(message.Say), "?") {
+		return
+	}
+	if answer := f.Answer(message.Say); len(answer) > 0 {
+		room.Reply(message, answer)
+	} else if toBot {
+		room.Reply(message, "sorry, I have no answer for that.")
+	}
+}
+
+// OnJoin does nothing.
+func (f *FAQBot) OnJoin(room *Room, user string) {
+}
+
+// OnLeave does nothing.
+func (f *FAQBot) OnLeave(room *Room, user string) {
+}
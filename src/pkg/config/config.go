@@ -10,13 +10,22 @@ Package config "classify" (:P) a plain cherry file.
 package config
 
 import (
-	"crypto/md5"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
 	"fmt"
-	"io"
 	"net"
+	"pkg/bans"
+	"pkg/history"
+	"pkg/ratelimit"
+	"pkg/search"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
 // RoomMisc gathers the misc options for a room.
@@ -34,6 +43,42 @@ type RoomMisc struct {
 	maxFloodAllowedBeforeKick int
 	allUsersAlias             string
 	publicDirectory           string
+	tlsCertificate            string
+	tlsKey                    string
+	sessionLifetime           int
+	sessionCookie             bool
+	waitingLine               bool
+	waitingLineTimeout        int
+	floodRate                 int
+	floodBurst                int
+	floodWarningsBeforeMute   int
+	floodMuteTime             int
+	floodForgiveTime          int
+	floodWarningMessage       string
+	floodMuteMessage          string
+	floodKickMessage          string
+	slowClientPolicy          string
+	outboundQueueSize         int
+	writeTimeout              int
+	allowedMarkup             []string
+	historyReplay             int
+	transcriptToken           string
+	hookRate                  int
+	hookBurst                 int
+	kickAction                string
+	muteAction                string
+	banAction                 string
+	kickMessage               string
+	muteMessage               string
+	banMessage                string
+	muteTime                  int
+	banTime                   int
+	moderatorMarker           string
+	roomPassword              string
+	inviteOnly                bool
+	inviteTime                int
+	joinFailureRate           int
+	joinFailureBurst          int
 }
 
 // RoomAction gathers the label and the template (data) from an action.
@@ -54,20 +99,98 @@ type Message struct {
 	From   string
 	To     string
 	Action string
-	//    Sound string
-	Image string
-	Say   string
-	Priv  string
+	Sound  string
+	Image  string
+	Say    string
+	Priv   string
+	Notice bool
+	Time   time.Time
+}
+
+// RoomEventKind tells what happened in a room.
+type RoomEventKind int
+
+const (
+	// MessageDelivered means that a message was delivered to the room.
+	MessageDelivered RoomEventKind = iota
+	// UserJoined means that someone has just entered the room.
+	UserJoined
+	// UserLeft means that someone has just left the room.
+	UserLeft
+	// UserIgnored means that someone (User) has just ignored someone else (Target).
+	UserIgnored
+	// UserDeignored means that someone (User) is not ignoring someone else (Target) anymore.
+	UserDeignored
+)
+
+// RoomEvent is something that happened in a room. The bots and the webhooks are notified about it.
+type RoomEvent struct {
+	Kind     RoomEventKind
+	Rooms    *CherryRooms
+	RoomName string
+	User     string
+	Target   string
+	Message  Message
+	Time     time.Time
+}
+
+// RoomObserver is notified about the events of a room (see the packages bots and webhooks).
+type RoomObserver interface {
+	// Notify hands an event to the observer. It must not block.
+	Notify(event RoomEvent)
+	// Stop releases the observer, it will not be notified anymore.
+	Stop()
 }
 
+// incomingHook is a token that allows other programs to post into a room (see "POST /hook/<token>").
+type incomingHook struct {
+	nickname string
+	action   string
+	limit    *ratelimit.Bucket
+}
+
+// MeAction is the action of the messages where the users talk about themselves in the third person ("/me").
+const MeAction = "/me"
+
 // RoomUser is the user context.
 type RoomUser struct {
-	sessionID  string
-	color      string
-	ignoreList []string
-	kickout    bool
-	conn       net.Conn
-	addr       string
+	sessionID     string
+	color         string
+	ignoreList    []string
+	kickout       bool
+	conn          net.Conn
+	addr          string
+	lastSeen      time.Time
+	flood         *ratelimit.Bucket
+	violations    int
+	lastViolation time.Time
+	mutedUntil    time.Time
+	silencedUntil time.Time
+	outbox        chan []byte
+}
+
+// FloodVerdict is what the flooding police decided about a message.
+type FloodVerdict int
+
+const (
+	// FloodAllowed means that the message can be delivered.
+	FloodAllowed FloodVerdict = iota
+	// FloodWarned means that the message was dropped and the user must be warned.
+	FloodWarned
+	// FloodMuted means that the message was dropped and the user has just been muted.
+	FloodMuted
+	// FloodDropped means that the message was dropped because the user is still muted.
+	FloodDropped
+	// FloodKicked means that the user must be kicked out.
+	FloodKicked
+)
+
+// waitingUser is someone waiting for a free place in a full room.
+type waitingUser struct {
+	ticket   string
+	nickname string
+	color    string
+	lastSeen time.Time
 }
 
 // RoomConfig represents in memory a defined room loaded from a cherry file.
@@ -78,30 +201,61 @@ type RoomConfig struct {
 	publicMessages []string
 	users          map[string]*RoomUser
 	templates      map[string]string
+	compiled       map[string]interface{}
 	misc           *RoomMisc
 	actions        map[string]*RoomAction
 	images         map[string]*RoomMediaResource
-	//sounds map[string]*RoomMediaResource
+	sounds         map[string]*RoomMediaResource
 	ignoreAction   string
 	deignoreAction string
+	topic          string
+	bots           map[string]RoomObserver
+	webhooks       RoomObserver
+	hooks          map[string]*incomingHook
+	moderators     map[string]string
+	invites        map[string]time.Time
+	joinFailures   map[string]*ratelimit.Bucket
+	waitingLine    []*waitingUser
+	admitted       map[string]*waitingUser
+	delivering     *sync.Mutex
+	pending        chan bool
+	quit           chan bool
+	closed         bool
 }
 
 // CherryRooms represents your cherry tree... I mean your cherry server.
 type CherryRooms struct {
-	configs    map[string]*RoomConfig
-	servername string
+	mutex          *sync.RWMutex
+	configs        map[string]*RoomConfig
+	servername     string
+	tlsCertificate string
+	tlsKey         string
+	sharedPort     int16
+	historyDir     string
+	history        history.Store
+	index          *search.Index
+	bans           *bans.List
 }
 
 // NewCherryRooms creates a new server container.
 func NewCherryRooms() *CherryRooms {
-	return &CherryRooms{make(map[string]*RoomConfig), "localhost"}
+	return &CherryRooms{mutex: new(sync.RWMutex), configs: make(map[string]*RoomConfig), servername: "localhost",
+		index: search.NewIndex(), bans: bans.NewList()}
+}
+
+// room returns the configuration of a room (nil when it never existed). A removed room is still returned (closed),
+// so the requests and workers that are finishing their jobs on it do not need to care about the removal.
+func (c *CherryRooms) room(roomName string) *RoomConfig {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.configs[roomName]
 }
 
 // GetRoomActionLabel spits a room action label.
 func (c *CherryRooms) GetRoomActionLabel(roomName, action string) string {
 	c.Lock(roomName)
 	var label string
-	label = c.configs[roomName].actions[action].label
+	label = c.room(roomName).actions[action].label
 	c.Unlock(roomName)
 	return label
 }
@@ -111,7 +265,7 @@ func (c *CherryRooms) GetRoomUsers(roomName string) []string {
 	var users []string
 	users = make([]string, 0)
 	c.Lock(roomName)
-	for user := range c.configs[roomName].users {
+	for user := range c.room(roomName).users {
 		users = append(users, user)
 	}
 	c.Unlock(roomName)
@@ -122,9 +276,13 @@ func (c *CherryRooms) GetRoomUsers(roomName string) []string {
 func (c *CherryRooms) GetRooms() []string {
 	var rooms []string
 	rooms = make([]string, 0)
-	for room := range c.configs {
-		rooms = append(rooms, room)
+	c.mutex.RLock()
+	for roomName, room := range c.configs {
+		if !room.closed {
+			rooms = append(rooms, roomName)
+		}
 	}
+	c.mutex.RUnlock()
 	return rooms
 }
 
@@ -132,7 +290,9 @@ func (c *CherryRooms) GetRooms() []string {
 func (c *CherryRooms) GetUserConnection(roomName, user string) net.Conn {
 	var conn net.Conn
 	c.Lock(roomName)
-	conn = c.configs[roomName].users[user].conn
+	if u, ok := c.room(roomName).users[user]; ok {
+		conn = u.conn
+	}
 	c.Unlock(roomName)
 	return conn
 }
@@ -141,371 +301,1197 @@ func (c *CherryRooms) GetUserConnection(roomName, user string) net.Conn {
 func (c *CherryRooms) GetRoomActionTemplate(roomName, action string) string {
 	c.Lock(roomName)
 	var template string
-	template = c.configs[roomName].actions[action].template
+	template = c.room(roomName).actions[action].template
 	c.Unlock(roomName)
 	return template
 }
 
 // AddUser does what it is saying. BELIEVE or NOT!!!
 func (c *CherryRooms) AddUser(roomName, nickname, color string, kickout bool) {
-	c.configs[roomName].mutex.Lock()
-	md := md5.New()
-	io.WriteString(md, roomName+nickname+color)
-	id := fmt.Sprintf("%x", md.Sum(nil))
-	c.configs[roomName].users[nickname] = &RoomUser{id, color, make([]string, 0), kickout, nil, ""}
-	c.configs[roomName].mutex.Unlock()
+	c.room(roomName).mutex.Lock()
+	c.addUser(roomName, nickname, color, kickout)
+	c.room(roomName).mutex.Unlock()
 }
 
-// RemoveUser removes a user...
-func (c *CherryRooms) RemoveUser(roomName, nickname string) {
-	c.configs[roomName].mutex.Lock()
-	delete(c.configs[roomName].users, nickname)
-	c.configs[roomName].mutex.Unlock()
+func (c *CherryRooms) addUser(roomName, nickname, color string, kickout bool) {
+	if u, ok := c.room(roomName).users[nickname]; ok && u.outbox != nil {
+		close(u.outbox)
+	}
+	//  INFO(Santiago): Each (re)join gets a brand new session ID, so previous ones become useless.
+	c.room(roomName).users[nickname] = &RoomUser{sessionID: newSessionID(),
+		color:      color,
+		ignoreList: make([]string, 0),
+		kickout:    kickout,
+		lastSeen:   time.Now()}
+	c.notifyObservers(roomName, RoomEvent{Kind: UserJoined, User: nickname})
+}
+
+func newSessionID() string {
+	var token [16]byte
+	rand.Read(token[:])
+	return hex.EncodeToString(token[:])
+}
+
+// AdmitUser adds the user only if the room is not full. It returns "false" when the user was refused.
+func (c *CherryRooms) AdmitUser(roomName, nickname, color string, kickout bool) bool {
+	c.room(roomName).mutex.Lock()
+	defer c.room(roomName).mutex.Unlock()
+	c.updateWaitingLine(roomName)
+	if c.isFull(roomName) {
+		return false
+	}
+	c.addUser(roomName, nickname, color, kickout)
+	return true
 }
 
-// EnqueueMessage adds to the queue an user message.
-func (c *CherryRooms) EnqueueMessage(roomName, from, to, action, image, say, priv string) {
-	c.configs[roomName].mutex.Lock()
-	c.configs[roomName].messageQueue = append(c.configs[roomName].messageQueue, Message{from, to, action, image, say, priv})
-	c.configs[roomName].mutex.Unlock()
+// RemoveUser removes a user... The next one in the waiting line (if any) is admitted.
+func (c *CherryRooms) RemoveUser(roomName, nickname string) {
+	c.room(roomName).mutex.Lock()
+	c.removeUser(roomName, nickname)
+	c.room(roomName).mutex.Unlock()
 }
 
-// DequeueMessage removes from the queue the oldest user message.
-func (c *CherryRooms) DequeueMessage(roomName string) {
-	c.configs[roomName].mutex.Lock()
-	if len(c.configs[roomName].messageQueue) >= 1 {
-		c.configs[roomName].messageQueue = c.configs[roomName].messageQueue[1:]
+// removeUser does the RemoveUser's job. WARN(Santiago): It must be called with the room mutex acquired.
+func (c *CherryRooms) removeUser(roomName, nickname string) {
+	room := c.room(roomName)
+	if u, ok := room.users[nickname]; ok && u.outbox != nil {
+		close(u.outbox)
 	}
-	c.configs[roomName].mutex.Unlock()
+	if _, ok := room.users[nickname]; ok {
+		delete(room.users, nickname)
+		c.notifyObservers(roomName, RoomEvent{Kind: UserLeft, User: nickname})
+	}
+	c.updateWaitingLine(roomName)
 }
 
-// GetNextMessage returns the next message that should be processed.
-func (c *CherryRooms) GetNextMessage(roomName string) Message {
-	c.configs[roomName].mutex.Lock()
-	var message Message
-	if len(c.configs[roomName].messageQueue) > 0 {
-		message = c.configs[roomName].messageQueue[0]
-	} else {
-		message = Message{}
+// RenameUser changes the nickname of a connected user keeping the session, the connection and the ignore lists.
+// It returns "false" when the user is not connected, is a moderator or the new nickname is already taken.
+func (c *CherryRooms) RenameUser(roomName, nickname, newNickname string) bool {
+	room := c.room(roomName)
+	room.mutex.Lock()
+	defer room.mutex.Unlock()
+	u, ok := room.users[nickname]
+	if !ok || len(newNickname) == 0 {
+		return false
 	}
-	c.configs[roomName].mutex.Unlock()
-	return message
+	if _, taken := room.users[newNickname]; taken {
+		return false
+	}
+	//  INFO(Santiago): The moderators are declared by their nicknames, so they keep them.
+	if _, moderator := room.moderators[nickname]; moderator {
+		return false
+	}
+	delete(room.users, nickname)
+	room.users[newNickname] = u
+	for _, other := range room.users {
+		for i, ignored := range other.ignoreList {
+			if ignored == nickname {
+				other.ignoreList[i] = newNickname
+			}
+		}
+	}
+	c.notifyObservers(roomName, RoomEvent{Kind: UserLeft, User: nickname})
+	c.notifyObservers(roomName, RoomEvent{Kind: UserJoined, User: newNickname})
+	return true
 }
 
-// GetSessionID returns the user's session ID.
-func (c *CherryRooms) GetSessionID(from, roomName string) string {
-	if len(from) == 0 || !c.HasUser(roomName, from) {
-		return ""
-	}
-	c.configs[roomName].mutex.Lock()
-	var sessionID string
-	sessionID = c.configs[roomName].users[from].sessionID
-	c.configs[roomName].mutex.Unlock()
-	return sessionID
+// AddBot puts a bot in a room under a nickname that nobody else can use.
+func (c *CherryRooms) AddBot(roomName, nickname string, bot RoomObserver) {
+	c.Lock(roomName)
+	c.room(roomName).bots[nickname] = bot
+	c.Unlock(roomName)
 }
 
-// GetColor returns the user's nickname color.
-func (c *CherryRooms) GetColor(from, roomName string) string {
-	if len(from) == 0 || !c.HasUser(roomName, from) {
-		return ""
+// HasBot verifies if a nickname belongs to a bot of the room.
+func (c *CherryRooms) HasBot(roomName, nickname string) bool {
+	room := c.room(roomName)
+	if room == nil {
+		return false
 	}
-	c.configs[roomName].mutex.Lock()
-	var color string
-	color = c.configs[roomName].users[from].color
-	c.configs[roomName].mutex.Unlock()
-	return color
+	room.mutex.Lock()
+	_, ok := room.bots[nickname]
+	room.mutex.Unlock()
+	return ok
 }
 
-// GetIgnoreList returns all users ignored by an user.
-func (c *CherryRooms) GetIgnoreList(from, roomName string) string {
-	if len(from) == 0 || !c.HasUser(roomName, from) {
-		return ""
-	}
-	c.configs[roomName].mutex.Lock()
-	var ignoreList string
-	ignoring := c.configs[roomName].users[from].ignoreList
-	lastIndex := len(ignoring) - 1
-	for c, who := range ignoring {
-		ignoreList += "\"" + who + "\""
-		if c != lastIndex {
-			ignoreList += ", "
-		}
+// GetRoomBots returns the nicknames of the bots of a room (sorted).
+func (c *CherryRooms) GetRoomBots(roomName string) []string {
+	c.Lock(roomName)
+	bots := make([]string, 0, len(c.room(roomName).bots))
+	for nickname := range c.room(roomName).bots {
+		bots = append(bots, nickname)
 	}
-	c.configs[roomName].mutex.Unlock()
-	return ignoreList
+	c.Unlock(roomName)
+	sort.Strings(bots)
+	return bots
 }
 
-// AddToIgnoreList add to the user context some user to be ignored.
-func (c *CherryRooms) AddToIgnoreList(from, to, roomName string) {
-	if len(from) == 0 || len(to) == 0 || !c.HasUser(roomName, from) || !c.HasUser(roomName, to) {
-		return
+// SetWebhooks sets who posts the events of a room to other places.
+func (c *CherryRooms) SetWebhooks(roomName string, webhooks RoomObserver) {
+	c.Lock(roomName)
+	c.room(roomName).webhooks = webhooks
+	c.Unlock(roomName)
+}
+
+// AddIncomingHook allows the holder of @token to post into a room as @nickname using @action.
+func (c *CherryRooms) AddIncomingHook(roomName, token, nickname, action string) {
+	c.Lock(roomName)
+	c.room(roomName).hooks[token] = &incomingHook{nickname: nickname, action: action}
+	c.Unlock(roomName)
+}
+
+// HasIncomingHook verifies if a token was already given to an incoming hook of the room.
+func (c *CherryRooms) HasIncomingHook(roomName, token string) bool {
+	c.Lock(roomName)
+	_, ok := c.room(roomName).hooks[token]
+	c.Unlock(roomName)
+	return ok
+}
+
+// IsIncomingHookNickname verifies if a nickname is used by an incoming hook of the room.
+func (c *CherryRooms) IsIncomingHookNickname(roomName, nickname string) bool {
+	room := c.room(roomName)
+	if room == nil {
+		return false
 	}
-	c.configs[roomName].mutex.Lock()
-	for _, t := range c.configs[roomName].users[from].ignoreList {
-		if t == to {
-			c.configs[roomName].mutex.Unlock()
-			return
+	room.mutex.Lock()
+	defer room.mutex.Unlock()
+	for _, hook := range room.hooks {
+		if hook.nickname == nickname {
+			return true
 		}
 	}
-	c.configs[roomName].users[from].ignoreList = append(c.configs[roomName].users[from].ignoreList, to)
-	c.configs[roomName].mutex.Unlock()
+	return false
 }
 
-// DelFromIgnoreList removes from the user context a previous ignored user.
-func (c *CherryRooms) DelFromIgnoreList(from, to, roomName string) {
-	if len(from) == 0 || len(to) == 0 || !c.HasUser(roomName, from) || !c.HasUser(roomName, to) {
-		return
-	}
-	var index = -1
-	c.configs[roomName].mutex.Lock()
-	for it, t := range c.configs[roomName].users[from].ignoreList {
-		if t == to {
-			index = it
-			break
+// GetIncomingHookSender returns the nickname and the action used by the posts of the token's holder.
+// The token is compared against all the room's tokens in constant time.
+func (c *CherryRooms) GetIncomingHookSender(roomName, token string) (string, string, bool) {
+	c.Lock(roomName)
+	defer c.Unlock(roomName)
+	var found *incomingHook
+	for hookToken, hook := range c.room(roomName).hooks {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(hookToken)) == 1 {
+			found = hook
 		}
 	}
-	if index != -1 {
-		c.configs[roomName].users[from].ignoreList = append(c.configs[roomName].users[from].ignoreList[:index], c.configs[roomName].users[from].ignoreList[index+1:]...)
+	if found == nil {
+		return "", "", false
 	}
-	c.configs[roomName].mutex.Unlock()
+	return found.nickname, found.action, true
 }
 
-// IsIgnored returns "true" if the user U is ignoring the asshole A, otherwise guess what.
-func (c *CherryRooms) IsIgnored(from, to, roomName string) bool {
-	if len(from) == 0 || len(to) == 0 || !c.HasUser(roomName, from) || !c.HasUser(roomName, to) {
+// AllowIncomingHookPost takes one token from the bucket of an incoming hook. It returns "false" when
+// the hook is posting faster than the room's hook-rate and hook-burst allow.
+func (c *CherryRooms) AllowIncomingHookPost(roomName, token string) bool {
+	c.Lock(roomName)
+	defer c.Unlock(roomName)
+	room := c.room(roomName)
+	hook, ok := room.hooks[token]
+	if !ok {
 		return false
 	}
-	var retval = false
-	c.configs[roomName].mutex.Lock()
-	for _, t := range c.configs[roomName].users[from].ignoreList {
-		if t == to {
-			retval = true
-			break
-		}
+	if hook.limit == nil {
+		hook.limit = ratelimit.NewBucket(float64(room.misc.hookRate)/60.0, room.misc.hookBurst)
 	}
-	c.configs[roomName].mutex.Unlock()
-	return retval
+	return hook.limit.Allow()
 }
 
-// GetGreetingMessage returns the pre-configurated greeting message.
-func (c *CherryRooms) GetGreetingMessage(roomName string) string {
-	c.configs[roomName].mutex.Lock()
-	var message string
-	message = c.configs[roomName].misc.greetingMessage
-	c.configs[roomName].mutex.Unlock()
-	return message
+// AddModerator declares a moderator of the room. The secret is the hash of the moderator's password written
+// as "pbkdf2-sha256:<iterations>:<salt>:<hex>" (see HashPassword).
+func (c *CherryRooms) AddModerator(roomName, nickname, secret string) {
+	c.Lock(roomName)
+	c.room(roomName).moderators[nickname] = secret
+	c.Unlock(roomName)
 }
 
-// GetJoinMessage returns the pre-configurated join message.
-func (c *CherryRooms) GetJoinMessage(roomName string) string {
-	c.configs[roomName].mutex.Lock()
-	var message string
-	message = c.configs[roomName].misc.joinMessage
-	c.configs[roomName].mutex.Unlock()
-	return message
+// HasModerator verifies if a nickname was declared as a moderator of the room. Nobody can use this
+// nickname without the moderator's password.
+func (c *CherryRooms) HasModerator(roomName, nickname string) bool {
+	room := c.room(roomName)
+	if room == nil {
+		return false
+	}
+	room.mutex.Lock()
+	_, ok := room.moderators[nickname]
+	room.mutex.Unlock()
+	return ok
 }
 
-// GetExitMessage returns the pre-configurated exit message.
-func (c *CherryRooms) GetExitMessage(roomName string) string {
-	c.configs[roomName].mutex.Lock()
-	var message string
-	message = c.configs[roomName].misc.exitMessage
-	c.configs[roomName].mutex.Unlock()
-	return message
+// IsModerator verifies if a user in the room is a moderator.
+func (c *CherryRooms) IsModerator(roomName, user string) bool {
+	return c.HasModerator(roomName, user) && c.HasUser(roomName, user)
 }
 
-// GetOnIgnoreMessage returns the pre-configurated "on ignore" message.
-func (c *CherryRooms) GetOnIgnoreMessage(roomName string) string {
-	c.configs[roomName].mutex.Lock()
-	var message string
-	message = c.configs[roomName].misc.onIgnoreMessage
-	c.configs[roomName].mutex.Unlock()
-	return message
+// IsValidModeratorPassword verifies the password of a moderator.
+func (c *CherryRooms) IsValidModeratorPassword(roomName, nickname, password string) bool {
+	c.Lock(roomName)
+	secret, ok := c.room(roomName).moderators[nickname]
+	c.Unlock(roomName)
+	return ok && isValidPassword(secret, password)
 }
 
-// GetOnDeIgnoreMessage returns the pre-configurated "on deignore" message.
-func (c *CherryRooms) GetOnDeIgnoreMessage(roomName string) string {
-	c.configs[roomName].mutex.Lock()
-	var message string
-	message = c.configs[roomName].misc.onDeIgnoreMessage
-	c.configs[roomName].mutex.Unlock()
-	return message
-}
+// PasswordIterations is how many PBKDF2 iterations are done by the password hashes made by HashPassword.
+const PasswordIterations = 600000
 
-// GetPrivateMessageMarker returns the private message marker.
-func (c *CherryRooms) GetPrivateMessageMarker(roomName string) string {
-	c.configs[roomName].mutex.Lock()
-	var message string
-	message = c.configs[roomName].misc.privateMessageMarker
-	c.configs[roomName].mutex.Unlock()
-	return message
-}
+// MinPasswordIterations is the least number of PBKDF2 iterations accepted for the passwords in the cherry file.
+const MinPasswordIterations = 100000
 
-// GetMaxUsers returns the total of users allowed in a room.
-func (c *CherryRooms) GetMaxUsers(roomName string) string {
-	c.configs[roomName].mutex.Lock()
-	var max string
-	max = fmt.Sprintf("%d", c.configs[roomName].misc.maxUsers)
-	c.configs[roomName].mutex.Unlock()
-	return max
+// passwordHashPrefix starts the password hashes, they are written as "pbkdf2-sha256:<iterations>:<salt>:<hex>".
+const passwordHashPrefix = "pbkdf2-sha256:"
+
+// HashPassword derives from a password (with a random salt) the secret that is written in the cherry file.
+func HashPassword(password string, iterations int) (string, error) {
+	var salt [16]byte
+	if _, err := rand.Read(salt[:]); err != nil {
+		return "", err
+	}
+	key, err := pbkdf2.Key(sha256.New, password, salt[:], iterations, sha256.Size)
+	if err != nil {
+		return "", err
+	}
+	return passwordHashPrefix + strconv.Itoa(iterations) + ":" + hex.EncodeToString(salt[:]) + ":" + hex.EncodeToString(key), nil
 }
 
-// GetAllUsersAlias returns the "all users" alias.
-func (c *CherryRooms) GetAllUsersAlias(roomName string) string {
-	c.configs[roomName].mutex.Lock()
-	var alias string
-	alias = c.configs[roomName].misc.allUsersAlias
-	c.configs[roomName].mutex.Unlock()
-	return alias
+// CheckPasswordHash verifies if a secret is a password hash good enough to be written in the cherry file.
+func CheckPasswordHash(secret string) error {
+	iterations, _, _, err := parsePasswordHash(secret)
+	if err != nil {
+		return err
+	}
+	if iterations < MinPasswordIterations {
+		return fmt.Errorf("the password hash must take at least %d iterations", MinPasswordIterations)
+	}
+	return nil
 }
 
-// GetActionList returns a well-formatted "HTML combo" containing all actions.
-func (c *CherryRooms) GetActionList(roomName string) string {
-	c.Lock(roomName)
-	var actionList = ""
-	var actions []string
-	actions = make([]string, 0)
-	for action := range c.configs[roomName].actions {
-		actions = append(actions, action)
+// parsePasswordHash splits a "pbkdf2-sha256:<iterations>:<salt>:<hex>" secret.
+func parsePasswordHash(secret string) (int, []byte, []byte, error) {
+	fields := strings.Split(strings.TrimPrefix(secret, passwordHashPrefix), ":")
+	if !strings.HasPrefix(secret, passwordHashPrefix) || len(fields) != 3 {
+		return 0, nil, nil, fmt.Errorf("the password must be written as \"%s<iterations>:<salt>:<hex>\"", passwordHashPrefix)
 	}
-	sort.Strings(actions)
-	for _, action := range actions {
-		actionList += "<option value = \"" + action + "\">" + c.configs[roomName].actions[action].label + "\n"
+	iterations, err := strconv.Atoi(fields[0])
+	if err != nil || iterations <= 0 {
+		return 0, nil, nil, fmt.Errorf("invalid number of iterations in the password hash")
 	}
-	c.Unlock(roomName)
-	return actionList
+	salt, err := hex.DecodeString(fields[1])
+	if err != nil || len(salt) < 8 {
+		return 0, nil, nil, fmt.Errorf("the salt of the password hash must have at least 8 bytes written in hex")
+	}
+	key, err := hex.DecodeString(fields[2])
+	if err != nil || len(key) != sha256.Size {
+		return 0, nil, nil, fmt.Errorf("the password hash must have %d bytes written in hex", sha256.Size)
+	}
+	return iterations, salt, key, nil
 }
 
-func (c *CherryRooms) getMediaResourceList(roomName string, mediaResource map[string]*RoomMediaResource) string {
-	c.Lock(roomName)
-	var mediaRsrcList = ""
-	var resources []string
-	resources = make([]string, 0)
-	for resource := range mediaResource {
-		resources = append(resources, resource)
+// isValidPassword verifies a password against a secret written as "pbkdf2-sha256:<iterations>:<salt>:<hex>".
+// The comparison is done in constant time.
+func isValidPassword(secret, password string) bool {
+	if len(password) == 0 {
+		return false
 	}
-	sort.Strings(resources)
-	for _, resource := range resources {
-		mediaRsrcList += "<option value = \"" + resource + "\">" + mediaResource[resource].label + "\n"
+	iterations, salt, key, err := parsePasswordHash(secret)
+	if err != nil {
+		return false
 	}
-	c.Unlock(roomName)
-	return mediaRsrcList
+	derived, err := pbkdf2.Key(sha256.New, password, salt, iterations, len(key))
+	return err == nil && subtle.ConstantTimeCompare(derived, key) == 1
 }
 
-// GetImageList returns a well-formatted "HTML combo" containing all images.
-func (c *CherryRooms) GetImageList(roomName string) string {
-	return c.getMediaResourceList(roomName, c.configs[roomName].images)
+// IsModerationAction verifies if an action is the kick, mute or ban action of the room.
+func (c *CherryRooms) IsModerationAction(roomName, action string) bool {
+	c.Lock(roomName)
+	is := c.isModerationAction(roomName, action)
+	c.Unlock(roomName)
+	return is
 }
 
-//func (c *CherryRooms) GetSoundList(room_name string) string {
-//    return c.getMediaResourceList(room_name, c.configs[room_name].sounds)
-//}
+// isModerationAction does the IsModerationAction's job. WARN(Santiago): It must be called with the room mutex acquired.
+func (c *CherryRooms) isModerationAction(roomName, action string) bool {
+	misc := c.room(roomName).misc
+	return len(action) > 0 && (action == misc.kickAction || action == misc.muteAction || action == misc.banAction)
+}
 
-// GetUsersList returns a well-formatted "HTML combo" containing all users connected on a room.
-func (c *CherryRooms) GetUsersList(roomName string) string {
+// MuteUser keeps a user from posting during some seconds.
+func (c *CherryRooms) MuteUser(roomName, user string, seconds int) {
 	c.Lock(roomName)
-	var users []string
-	users = make([]string, 0)
-	for user := range c.configs[roomName].users {
-		users = append(users, user)
-	}
-	//  WARN(Santiago): Already locked, we can acquire this piece of information directly... otherwise we got a deadlock.
-	allUsersAlias := c.configs[roomName].misc.allUsersAlias
-	var usersList = "<option value = \"" + allUsersAlias + "\">" + allUsersAlias + "\n"
-	sort.Strings(users)
-	for _, user := range users {
-		usersList += "<option value = \"" + user + "\">" + user + "\n"
+	if u, ok := c.room(roomName).users[user]; ok {
+		u.silencedUntil = time.Now().Add(time.Duration(seconds) * time.Second)
 	}
 	c.Unlock(roomName)
-	return usersList
 }
 
-func (c *CherryRooms) getRoomTemplate(roomName, template string) string {
-	c.configs[roomName].mutex.Lock()
-	var data string
-	data = c.configs[roomName].templates[template]
-	c.configs[roomName].mutex.Unlock()
-	return data
+// IsMuted verifies if a user was muted by a moderator and this mute has not expired.
+func (c *CherryRooms) IsMuted(roomName, user string) bool {
+	c.Lock(roomName)
+	defer c.Unlock(roomName)
+	u, ok := c.room(roomName).users[user]
+	return ok && time.Now().Before(u.silencedUntil)
 }
 
-// SetPublicDirectory sets the public directory for a room.
-func (c *CherryRooms) SetPublicDirectory(roomName, value string) {
+// SetRoomPassword sets the hash of the password asked to who joins the room, written as
+// "pbkdf2-sha256:<iterations>:<salt>:<hex>" (see HashPassword).
+func (c *CherryRooms) SetRoomPassword(roomName, secret string) {
+	c.room(roomName).misc.roomPassword = secret
+}
+
+// HasRoomPassword verifies if a password is asked to who joins the room.
+func (c *CherryRooms) HasRoomPassword(roomName string) bool {
 	c.Lock(roomName)
-	c.configs[roomName].misc.publicDirectory = value
+	value := len(c.room(roomName).misc.roomPassword) > 0
 	c.Unlock(roomName)
+	return value
 }
 
-// GetPublicDirectory spits the room's public directory.
-func (c *CherryRooms) GetPublicDirectory(roomName string) string {
+// IsValidRoomPassword verifies the password of the room. Any password is valid when the room has none.
+func (c *CherryRooms) IsValidRoomPassword(roomName, password string) bool {
 	c.Lock(roomName)
-	dirPath := c.configs[roomName].misc.publicDirectory
+	secret := c.room(roomName).misc.roomPassword
 	c.Unlock(roomName)
-	return dirPath
+	return len(secret) == 0 || isValidPassword(secret, password)
 }
 
-// GetTopTemplate spits the top template data.
-func (c *CherryRooms) GetTopTemplate(roomName string) string {
-	return c.getRoomTemplate(roomName, "top")
+// SetInviteOnly sets if only the invited ones (and the moderators) can join the room.
+func (c *CherryRooms) SetInviteOnly(roomName string, value bool) {
+	c.room(roomName).misc.inviteOnly = value
 }
 
-// GetBodyTemplate spits the body template data.
-func (c *CherryRooms) GetBodyTemplate(roomName string) string {
-	return c.getRoomTemplate(roomName, "body")
+// IsInviteOnly verifies if only the invited ones (and the moderators) can join the room.
+func (c *CherryRooms) IsInviteOnly(roomName string) bool {
+	c.Lock(roomName)
+	value := c.room(roomName).misc.inviteOnly
+	c.Unlock(roomName)
+	return value
 }
 
-// GetBannerTemplate spits the banner template data.
-func (c *CherryRooms) GetBannerTemplate(roomName string) string {
-	return c.getRoomTemplate(roomName, "banner")
+// IsPrivate verifies if the room asks for a password or an invite. Nothing about a private room is shown
+// to who is outside (briefs, searches and so on).
+func (c *CherryRooms) IsPrivate(roomName string) bool {
+	return c.HasRoomPassword(roomName) || c.IsInviteOnly(roomName)
 }
 
-// GetHighlightTemplate spits the highlight template data.
-func (c *CherryRooms) GetHighlightTemplate(roomName string) string {
-	return c.getRoomTemplate(roomName, "highlight")
+// SetInviteTime sets for how many seconds an invite can be used.
+func (c *CherryRooms) SetInviteTime(roomName string, seconds int) {
+	c.room(roomName).misc.inviteTime = seconds
 }
 
-// GetEntranceTemplate spits the entrance template data.
-func (c *CherryRooms) GetEntranceTemplate(roomName string) string {
-	return c.getRoomTemplate(roomName, "entrance")
+// GetInviteTime returns for how many seconds an invite can be used.
+func (c *CherryRooms) GetInviteTime(roomName string) int {
+	c.Lock(roomName)
+	value := c.room(roomName).misc.inviteTime
+	c.Unlock(roomName)
+	return value
 }
 
-// GetExitTemplate spits the exit template data.
-func (c *CherryRooms) GetExitTemplate(roomName string) string {
-	return c.getRoomTemplate(roomName, "exit")
+// NewInvite creates an invite to the room. It can be used only once before the invite-time is over.
+func (c *CherryRooms) NewInvite(roomName string) string {
+	token := newSessionID()
+	c.Lock(roomName)
+	room := c.room(roomName)
+	now := time.Now()
+	for invite, until := range room.invites {
+		if now.After(until) {
+			delete(room.invites, invite)
+		}
+	}
+	room.invites[token] = now.Add(time.Duration(room.misc.inviteTime) * time.Second)
+	c.Unlock(roomName)
+	return token
 }
 
-// GetNickclashTemplate spits the nickclash template data.
-func (c *CherryRooms) GetNickclashTemplate(roomName string) string {
-	return c.getRoomTemplate(roomName, "nickclash")
+// IsValidInvite verifies if an invite to the room exists and is not expired, without using it.
+func (c *CherryRooms) IsValidInvite(roomName, token string) bool {
+	c.Lock(roomName)
+	until, ok := c.room(roomName).invites[token]
+	c.Unlock(roomName)
+	return ok && time.Now().Before(until)
 }
 
-// GetSkeletonTemplate spits the skeleton template data.
-func (c *CherryRooms) GetSkeletonTemplate(roomName string) string {
-	return c.getRoomTemplate(roomName, "skeleton")
+// ClaimInvite uses an invite to the room. It returns "false" when the invite is invalid or was already used.
+func (c *CherryRooms) ClaimInvite(roomName, token string) bool {
+	c.Lock(roomName)
+	defer c.Unlock(roomName)
+	room := c.room(roomName)
+	until, ok := room.invites[token]
+	delete(room.invites, token)
+	return ok && time.Now().Before(until)
 }
 
-// GetBriefTemplate spits the brief template data.
-func (c *CherryRooms) GetBriefTemplate(roomName string) string {
-	return c.getRoomTemplate(roomName, "brief")
+// SetJoinFailureRate sets how many failed joins per minute an address can do in the long run.
+func (c *CherryRooms) SetJoinFailureRate(roomName string, value int) {
+	c.room(roomName).misc.joinFailureRate = value
 }
 
-// GetFindResultsHeadTemplate spits the find template data (HEAD).
-func (c *CherryRooms) GetFindResultsHeadTemplate(roomName string) string {
-	return c.getRoomTemplate(roomName, "find-results-head")
+// GetJoinFailureRate returns how many failed joins per minute an address can do in the long run.
+func (c *CherryRooms) GetJoinFailureRate(roomName string) int {
+	c.Lock(roomName)
+	value := c.room(roomName).misc.joinFailureRate
+	c.Unlock(roomName)
+	return value
 }
 
-// GetFindResultsBodyTemplate spits the find template data (BODY).
-func (c *CherryRooms) GetFindResultsBodyTemplate(roomName string) string {
-	return c.getRoomTemplate(roomName, "find-results-body")
+// SetJoinFailureBurst sets how many failed joins an address can do in a row.
+func (c *CherryRooms) SetJoinFailureBurst(roomName string, value int) {
+	c.room(roomName).misc.joinFailureBurst = value
 }
 
-// GetFindResultsTailTemplate spits the find template data (TAIL).
-func (c *CherryRooms) GetFindResultsTailTemplate(roomName string) string {
-	return c.getRoomTemplate(roomName, "find-results-tail")
+// GetJoinFailureBurst returns how many failed joins an address can do in a row.
+func (c *CherryRooms) GetJoinFailureBurst(roomName string) int {
+	c.Lock(roomName)
+	value := c.room(roomName).misc.joinFailureBurst
+	c.Unlock(roomName)
+	return value
 }
 
-// GetFindBotTemplate spits the find bot template data.
-func (c *CherryRooms) GetFindBotTemplate(roomName string) string {
-	return c.getRoomTemplate(roomName, "find-bot")
+// AddJoinFailure counts a failed join (wrong password or invite) coming from an address.
+func (c *CherryRooms) AddJoinFailure(roomName, addr string) {
+	c.Lock(roomName)
+	defer c.Unlock(roomName)
+	room := c.room(roomName)
+	failures, ok := room.joinFailures[addr]
+	if !ok {
+		//  INFO(Santiago): The addresses that are not failing anymore are forgotten.
+		for failedAddr, bucket := range room.joinFailures {
+			if bucket.IsFull() {
+				delete(room.joinFailures, failedAddr)
+			}
+		}
+		failures = ratelimit.NewBucket(float64(room.misc.joinFailureRate)/60.0, room.misc.joinFailureBurst)
+		room.joinFailures[addr] = failures
+	}
+	failures.Allow()
+}
+
+// IsJoinBlocked verifies if an address has failed to join the room more than the join-failure-rate and
+// join-failure-burst allow. Its join attempts must be refused until it calms down.
+func (c *CherryRooms) IsJoinBlocked(roomName, addr string) bool {
+	c.Lock(roomName)
+	failures, ok := c.room(roomName).joinFailures[addr]
+	c.Unlock(roomName)
+	return ok && failures.IsEmpty()
+}
+
+// SetBans sets the ban list of all rooms.
+func (c *CherryRooms) SetBans(list *bans.List) {
+	c.bans = list
+}
+
+// GetBans returns the ban list of all rooms.
+func (c *CherryRooms) GetBans() *bans.List {
+	return c.bans
+}
+
+// BanUser keeps a user (the nickname and the address used) out of the room during @duration (zero means forever).
+func (c *CherryRooms) BanUser(roomName, user, by string, duration time.Duration) error {
+	c.Lock(roomName)
+	var addr string
+	if u, ok := c.room(roomName).users[user]; ok {
+		addr = u.addr
+	}
+	c.Unlock(roomName)
+	ban := bans.Ban{Room: roomName, Nickname: user, Address: addr, By: by}
+	if duration > 0 {
+		ban.Until = time.Now().Add(duration)
+	}
+	return c.bans.Add(ban)
+}
+
+// IsBanned verifies if a nickname or an address was banned from the room.
+func (c *CherryRooms) IsBanned(roomName, nickname, addr string) bool {
+	return c.bans.IsBanned(roomName, nickname, addr)
+}
+
+// GetBannedUsers returns the users in the room (except the moderators) that are banned (e.g. by a ban that has
+// just been added).
+func (c *CherryRooms) GetBannedUsers(roomName string) []string {
+	c.Lock(roomName)
+	defer c.Unlock(roomName)
+	var banned []string
+	for nickname, u := range c.room(roomName).users {
+		if _, isModerator := c.room(roomName).moderators[nickname]; !isModerator && c.bans.IsBanned(roomName, nickname, u.addr) {
+			banned = append(banned, nickname)
+		}
+	}
+	sort.Strings(banned)
+	return banned
+}
+
+// NotifyObservers tells the bots and the webhooks of a room about something that happened there.
+func (c *CherryRooms) NotifyObservers(roomName string, event RoomEvent) {
+	c.Lock(roomName)
+	c.notifyObservers(roomName, event)
+	c.Unlock(roomName)
+}
+
+// notifyObservers does the NotifyObservers' job. WARN(Santiago): It must be called with the room mutex acquired.
+func (c *CherryRooms) notifyObservers(roomName string, event RoomEvent) {
+	event.Rooms = c
+	event.RoomName = roomName
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	for _, bot := range c.room(roomName).bots {
+		bot.Notify(event)
+	}
+	if webhooks := c.room(roomName).webhooks; webhooks != nil {
+		webhooks.Notify(event)
+	}
+}
+
+// IsFull verifies if the room reached its max-users.
+func (c *CherryRooms) IsFull(roomName string) bool {
+	c.room(roomName).mutex.Lock()
+	c.updateWaitingLine(roomName)
+	full := c.isFull(roomName)
+	c.room(roomName).mutex.Unlock()
+	return full
+}
+
+func (c *CherryRooms) isFull(roomName string) bool {
+	maxUsers := c.room(roomName).misc.maxUsers
+	return maxUsers > 0 && len(c.room(roomName).users)+len(c.room(roomName).admitted) >= maxUsers
+}
+
+// updateWaitingLine drops who gave up waiting and admits the next ones while there are free places.
+// WARN(Santiago): It must be called with the room mutex acquired.
+func (c *CherryRooms) updateWaitingLine(roomName string) {
+	room := c.room(roomName)
+	//  INFO(Santiago): It is the time that someone in the waiting line (or just admitted) has to show up again.
+	waitingLineTimeout := time.Duration(room.misc.waitingLineTimeout) * time.Second
+	for ticket, w := range room.admitted {
+		if time.Since(w.lastSeen) > waitingLineTimeout {
+			delete(room.admitted, ticket)
+		}
+	}
+	waiting := make([]*waitingUser, 0)
+	for _, w := range room.waitingLine {
+		if time.Since(w.lastSeen) <= waitingLineTimeout {
+			waiting = append(waiting, w)
+		}
+	}
+	room.waitingLine = waiting
+	for len(room.waitingLine) > 0 && !c.isFull(roomName) {
+		next := room.waitingLine[0]
+		room.waitingLine = room.waitingLine[1:]
+		next.lastSeen = time.Now()
+		room.admitted[next.ticket] = next
+	}
+}
+
+// SetWaitingLine sets if people should wait for a place when the room is full.
+func (c *CherryRooms) SetWaitingLine(roomName string, value bool) {
+	c.room(roomName).misc.waitingLine = value
+}
+
+// SetWaitingLineTimeout sets for how many seconds someone in the waiting line (or just admitted) keeps the place
+// without showing up again.
+func (c *CherryRooms) SetWaitingLineTimeout(roomName string, seconds int) {
+	c.room(roomName).misc.waitingLineTimeout = seconds
+}
+
+// GetWaitingLineTimeout returns for how many seconds someone in the waiting line (or just admitted) keeps the place
+// without showing up again.
+func (c *CherryRooms) GetWaitingLineTimeout(roomName string) int {
+	c.Lock(roomName)
+	value := c.room(roomName).misc.waitingLineTimeout
+	c.Unlock(roomName)
+	return value
+}
+
+// IsUsingWaitingLine verifies if the room has a waiting line.
+func (c *CherryRooms) IsUsingWaitingLine(roomName string) bool {
+	c.Lock(roomName)
+	value := c.room(roomName).misc.waitingLine
+	c.Unlock(roomName)
+	return value
+}
+
+// EnqueueWaitingUser puts someone at the end of the waiting line and returns the ticket that identifies this person.
+func (c *CherryRooms) EnqueueWaitingUser(roomName, nickname, color string) string {
+	ticket := newSessionID()
+	c.Lock(roomName)
+	c.room(roomName).waitingLine = append(c.room(roomName).waitingLine, &waitingUser{ticket, nickname, color, time.Now()})
+	c.updateWaitingLine(roomName)
+	c.Unlock(roomName)
+	return ticket
+}
+
+// IsWaiting verifies if a nickname is reserved by someone in the waiting line.
+func (c *CherryRooms) IsWaiting(roomName, nickname string) bool {
+	c.Lock(roomName)
+	defer c.Unlock(roomName)
+	c.updateWaitingLine(roomName)
+	for _, w := range c.room(roomName).waitingLine {
+		if w.nickname == nickname {
+			return true
+		}
+	}
+	for _, w := range c.room(roomName).admitted {
+		if w.nickname == nickname {
+			return true
+		}
+	}
+	return false
+}
+
+// GetWaitingPosition returns the position (starting from 1) of a ticket in the waiting line, zero means
+// that the ticket is unknown or already admitted.
+func (c *CherryRooms) GetWaitingPosition(roomName, ticket string) int {
+	c.Lock(roomName)
+	defer c.Unlock(roomName)
+	c.updateWaitingLine(roomName)
+	for p, w := range c.room(roomName).waitingLine {
+		if w.ticket == ticket {
+			w.lastSeen = time.Now()
+			return p + 1
+		}
+	}
+	return 0
+}
+
+// ClaimAdmission adds to the room the user admitted from the waiting line with the indicated ticket.
+// It returns the user's nickname or an empty string if the ticket was not admitted.
+func (c *CherryRooms) ClaimAdmission(roomName, ticket string) string {
+	c.Lock(roomName)
+	defer c.Unlock(roomName)
+	c.updateWaitingLine(roomName)
+	w, admitted := c.room(roomName).admitted[ticket]
+	if !admitted {
+		return ""
+	}
+	delete(c.room(roomName).admitted, ticket)
+	c.addUser(roomName, w.nickname, w.color, true)
+	return w.nickname
+}
+
+// EnqueueMessage adds to the queue an user message.
+func (c *CherryRooms) EnqueueMessage(roomName, from, to, action, image, sound, say, priv string) {
+	c.room(roomName).mutex.Lock()
+	c.room(roomName).messageQueue = append(c.room(roomName).messageQueue, Message{From: from, To: to, Action: action,
+		Sound: sound, Image: image, Say: say, Priv: priv, Time: time.Now()})
+	c.room(roomName).mutex.Unlock()
+	c.wakeUpDispatcher(roomName)
+}
+
+// EnqueueNotice adds a message written by the server itself (join, exit, ignore confirmations, etc). Unlike the
+// users' messages, it is trusted markup.
+func (c *CherryRooms) EnqueueNotice(roomName, from, say, priv string) {
+	c.room(roomName).mutex.Lock()
+	c.room(roomName).messageQueue = append(c.room(roomName).messageQueue, Message{From: from, Say: say, Priv: priv,
+		Notice: true, Time: time.Now()})
+	c.room(roomName).mutex.Unlock()
+	c.wakeUpDispatcher(roomName)
+}
+
+func (c *CherryRooms) wakeUpDispatcher(roomName string) {
+	//  INFO(Santiago): Waking up the dispatcher, if it was already woken up there is nothing to do.
+	select {
+	case c.room(roomName).pending <- true:
+	default:
+	}
+}
+
+// HasPendingMessages verifies if there are messages waiting for delivery.
+func (c *CherryRooms) HasPendingMessages(roomName string) bool {
+	c.room(roomName).mutex.Lock()
+	pending := len(c.room(roomName).messageQueue) > 0
+	c.room(roomName).mutex.Unlock()
+	return pending
+}
+
+// DequeueMessage removes from the queue the oldest user message.
+func (c *CherryRooms) DequeueMessage(roomName string) {
+	c.room(roomName).mutex.Lock()
+	if len(c.room(roomName).messageQueue) >= 1 {
+		c.room(roomName).messageQueue = c.room(roomName).messageQueue[1:]
+	}
+	c.room(roomName).mutex.Unlock()
+}
+
+// GetNextMessage returns the next message that should be processed.
+func (c *CherryRooms) GetNextMessage(roomName string) Message {
+	c.room(roomName).mutex.Lock()
+	var message Message
+	if len(c.room(roomName).messageQueue) > 0 {
+		message = c.room(roomName).messageQueue[0]
+	} else {
+		message = Message{}
+	}
+	c.room(roomName).mutex.Unlock()
+	return message
+}
+
+// GetSessionID returns the user's session ID.
+func (c *CherryRooms) GetSessionID(from, roomName string) string {
+	if len(from) == 0 || !c.HasRoom(roomName) {
+		return ""
+	}
+	c.room(roomName).mutex.Lock()
+	var sessionID string
+	if user, ok := c.room(roomName).users[from]; ok {
+		sessionID = user.sessionID
+	}
+	c.room(roomName).mutex.Unlock()
+	return sessionID
+}
+
+// GetColor returns the user's nickname color.
+func (c *CherryRooms) GetColor(from, roomName string) string {
+	if len(from) == 0 || !c.HasRoom(roomName) {
+		return ""
+	}
+	c.room(roomName).mutex.Lock()
+	var color string
+	if user, ok := c.room(roomName).users[from]; ok {
+		color = user.color
+	}
+	c.room(roomName).mutex.Unlock()
+	return color
+}
+
+// GetIgnoreList returns all users ignored by an user.
+func (c *CherryRooms) GetIgnoreList(from, roomName string) string {
+	if len(from) == 0 || !c.HasRoom(roomName) {
+		return ""
+	}
+	c.room(roomName).mutex.Lock()
+	var ignoreList string
+	var ignoring []string
+	if user, ok := c.room(roomName).users[from]; ok {
+		ignoring = user.ignoreList
+	}
+	lastIndex := len(ignoring) - 1
+	for c, who := range ignoring {
+		ignoreList += "\"" + who + "\""
+		if c != lastIndex {
+			ignoreList += ", "
+		}
+	}
+	c.room(roomName).mutex.Unlock()
+	return ignoreList
+}
+
+// AddToIgnoreList add to the user context some user to be ignored.
+func (c *CherryRooms) AddToIgnoreList(from, to, roomName string) {
+	if len(from) == 0 || len(to) == 0 || !c.HasRoom(roomName) {
+		return
+	}
+	c.room(roomName).mutex.Lock()
+	if !c.hasUsers(roomName, from, to) {
+		c.room(roomName).mutex.Unlock()
+		return
+	}
+	for _, t := range c.room(roomName).users[from].ignoreList {
+		if t == to {
+			c.room(roomName).mutex.Unlock()
+			return
+		}
+	}
+	c.room(roomName).users[from].ignoreList = append(c.room(roomName).users[from].ignoreList, to)
+	c.notifyObservers(roomName, RoomEvent{Kind: UserIgnored, User: from, Target: to})
+	c.room(roomName).mutex.Unlock()
+}
+
+// DelFromIgnoreList removes from the user context a previous ignored user.
+func (c *CherryRooms) DelFromIgnoreList(from, to, roomName string) {
+	if len(from) == 0 || len(to) == 0 || !c.HasRoom(roomName) {
+		return
+	}
+	var index = -1
+	c.room(roomName).mutex.Lock()
+	if !c.hasUsers(roomName, from, to) {
+		c.room(roomName).mutex.Unlock()
+		return
+	}
+	for it, t := range c.room(roomName).users[from].ignoreList {
+		if t == to {
+			index = it
+			break
+		}
+	}
+	if index != -1 {
+		c.room(roomName).users[from].ignoreList = append(c.room(roomName).users[from].ignoreList[:index], c.room(roomName).users[from].ignoreList[index+1:]...)
+		c.notifyObservers(roomName, RoomEvent{Kind: UserDeignored, User: from, Target: to})
+	}
+	c.room(roomName).mutex.Unlock()
+}
+
+// IsIgnored returns "true" if the user U is ignoring the asshole A, otherwise guess what.
+func (c *CherryRooms) IsIgnored(from, to, roomName string) bool {
+	if len(from) == 0 || len(to) == 0 || !c.HasRoom(roomName) {
+		return false
+	}
+	var retval = false
+	c.room(roomName).mutex.Lock()
+	if !c.hasUsers(roomName, from, to) {
+		c.room(roomName).mutex.Unlock()
+		return false
+	}
+	for _, t := range c.room(roomName).users[from].ignoreList {
+		if t == to {
+			retval = true
+			break
+		}
+	}
+	c.room(roomName).mutex.Unlock()
+	return retval
+}
+
+// SetTopic sets what the room is talking about.
+func (c *CherryRooms) SetTopic(roomName, topic string) {
+	c.Lock(roomName)
+	c.room(roomName).topic = topic
+	c.Unlock(roomName)
+}
+
+// GetTopic returns what the room is talking about.
+func (c *CherryRooms) GetTopic(roomName string) string {
+	c.Lock(roomName)
+	topic := c.room(roomName).topic
+	c.Unlock(roomName)
+	return topic
+}
+
+// GetGreetingMessage returns the pre-configurated greeting message.
+func (c *CherryRooms) GetGreetingMessage(roomName string) string {
+	c.room(roomName).mutex.Lock()
+	var message string
+	message = c.room(roomName).misc.greetingMessage
+	c.room(roomName).mutex.Unlock()
+	return message
+}
+
+// GetJoinMessage returns the pre-configurated join message.
+func (c *CherryRooms) GetJoinMessage(roomName string) string {
+	c.room(roomName).mutex.Lock()
+	var message string
+	message = c.room(roomName).misc.joinMessage
+	c.room(roomName).mutex.Unlock()
+	return message
+}
+
+// GetExitMessage returns the pre-configurated exit message.
+func (c *CherryRooms) GetExitMessage(roomName string) string {
+	c.room(roomName).mutex.Lock()
+	var message string
+	message = c.room(roomName).misc.exitMessage
+	c.room(roomName).mutex.Unlock()
+	return message
+}
+
+// GetOnIgnoreMessage returns the pre-configurated "on ignore" message.
+func (c *CherryRooms) GetOnIgnoreMessage(roomName string) string {
+	c.room(roomName).mutex.Lock()
+	var message string
+	message = c.room(roomName).misc.onIgnoreMessage
+	c.room(roomName).mutex.Unlock()
+	return message
+}
+
+// GetOnDeIgnoreMessage returns the pre-configurated "on deignore" message.
+func (c *CherryRooms) GetOnDeIgnoreMessage(roomName string) string {
+	c.room(roomName).mutex.Lock()
+	var message string
+	message = c.room(roomName).misc.onDeIgnoreMessage
+	c.room(roomName).mutex.Unlock()
+	return message
+}
+
+// GetPrivateMessageMarker returns the private message marker.
+func (c *CherryRooms) GetPrivateMessageMarker(roomName string) string {
+	c.room(roomName).mutex.Lock()
+	var message string
+	message = c.room(roomName).misc.privateMessageMarker
+	c.room(roomName).mutex.Unlock()
+	return message
+}
+
+// GetMaxUsers returns the total of users allowed in a room.
+func (c *CherryRooms) GetMaxUsers(roomName string) string {
+	c.room(roomName).mutex.Lock()
+	var max string
+	max = fmt.Sprintf("%d", c.room(roomName).misc.maxUsers)
+	c.room(roomName).mutex.Unlock()
+	return max
+}
+
+// GetAllUsersAlias returns the "all users" alias.
+func (c *CherryRooms) GetAllUsersAlias(roomName string) string {
+	c.room(roomName).mutex.Lock()
+	var alias string
+	alias = c.room(roomName).misc.allUsersAlias
+	c.room(roomName).mutex.Unlock()
+	return alias
+}
+
+// ListItem is an entry of the lists that templates can go through (users, actions, images and sounds).
+type ListItem struct {
+	Value string
+	Label string
+}
+
+// GetActionList returns a well-formatted "HTML combo" containing all actions.
+func (c *CherryRooms) GetActionList(roomName string) string {
+	return makeHTMLCombo(c.GetActionItems(roomName))
+}
+
+// GetUserActionList returns a well-formatted "HTML combo" containing the actions that a user can do
+// (the moderation actions are only offered to the moderators).
+func (c *CherryRooms) GetUserActionList(roomName, user string) string {
+	items := c.GetActionItems(roomName)
+	if c.IsModerator(roomName, user) {
+		c.Lock(roomName)
+		misc := c.room(roomName).misc
+		for _, action := range []string{misc.kickAction, misc.muteAction, misc.banAction} {
+			if roomAction, ok := c.room(roomName).actions[action]; ok {
+				items = append(items, ListItem{action, roomAction.label})
+			}
+		}
+		c.Unlock(roomName)
+	}
+	return makeHTMLCombo(items)
+}
+
+// GetActionItems returns all actions (except the moderation ones) sorted by their ids.
+func (c *CherryRooms) GetActionItems(roomName string) []ListItem {
+	c.Lock(roomName)
+	var actions []string
+	actions = make([]string, 0)
+	for action := range c.room(roomName).actions {
+		if c.isModerationAction(roomName, action) {
+			continue
+		}
+		actions = append(actions, action)
+	}
+	sort.Strings(actions)
+	items := make([]ListItem, 0, len(actions))
+	for _, action := range actions {
+		items = append(items, ListItem{action, c.room(roomName).actions[action].label})
+	}
+	c.Unlock(roomName)
+	return items
+}
+
+// getMediaResourceItems returns the resources picked by @media sorted by their ids. The resources are picked
+// with the room mutex acquired because a reload can replace them.
+func (c *CherryRooms) getMediaResourceItems(roomName string, media func(*RoomConfig) map[string]*RoomMediaResource) []ListItem {
+	c.Lock(roomName)
+	mediaResource := media(c.room(roomName))
+	var resources []string
+	resources = make([]string, 0)
+	for resource := range mediaResource {
+		resources = append(resources, resource)
+	}
+	sort.Strings(resources)
+	items := make([]ListItem, 0, len(resources))
+	for _, resource := range resources {
+		items = append(items, ListItem{resource, mediaResource[resource].label})
+	}
+	c.Unlock(roomName)
+	return items
+}
+
+func makeHTMLCombo(items []ListItem) string {
+	var combo string
+	for _, item := range items {
+		combo += "<option value = \"" + item.Value + "\">" + item.Label + "\n"
+	}
+	return combo
+}
+
+// GetImageList returns a well-formatted "HTML combo" containing all images.
+func (c *CherryRooms) GetImageList(roomName string) string {
+	return makeHTMLCombo(c.GetImageItems(roomName))
+}
+
+// GetImageItems returns all images sorted by their ids.
+func (c *CherryRooms) GetImageItems(roomName string) []ListItem {
+	return c.getMediaResourceItems(roomName, func(room *RoomConfig) map[string]*RoomMediaResource { return room.images })
+}
+
+// GetSoundList returns a well-formatted "HTML combo" containing all sounds.
+func (c *CherryRooms) GetSoundList(roomName string) string {
+	return makeHTMLCombo(c.GetSoundItems(roomName))
+}
+
+// GetSoundItems returns all sounds sorted by their ids.
+func (c *CherryRooms) GetSoundItems(roomName string) []ListItem {
+	return c.getMediaResourceItems(roomName, func(room *RoomConfig) map[string]*RoomMediaResource { return room.sounds })
+}
+
+// GetSoundURL returns the url of a sound.
+func (c *CherryRooms) GetSoundURL(roomName, id string) string {
+	c.Lock(roomName)
+	var url string
+	if sound, ok := c.room(roomName).sounds[id]; ok {
+		url = sound.url
+	}
+	c.Unlock(roomName)
+	return url
+}
+
+// GetUsersList returns a well-formatted "HTML combo" containing all users connected on a room (and its bots).
+func (c *CherryRooms) GetUsersList(roomName string) string {
+	c.Lock(roomName)
+	var users []string
+	users = make([]string, 0)
+	for user := range c.room(roomName).users {
+		users = append(users, user)
+	}
+	for bot := range c.room(roomName).bots {
+		users = append(users, bot)
+	}
+	//  WARN(Santiago): Already locked, we can acquire this piece of information directly... otherwise we got a deadlock.
+	allUsersAlias := c.room(roomName).misc.allUsersAlias
+	var usersList = "<option value = \"" + allUsersAlias + "\">" + allUsersAlias + "\n"
+	sort.Strings(users)
+	for _, user := range users {
+		usersList += "<option value = \"" + user + "\">" + c.getUserLabel(roomName, user) + "\n"
+	}
+	c.Unlock(roomName)
+	return usersList
+}
+
+// GetUserItems returns all users connected on a room (and its bots) sorted by their nicknames.
+func (c *CherryRooms) GetUserItems(roomName string) []ListItem {
+	users := append(c.GetRoomUsers(roomName), c.GetRoomBots(roomName)...)
+	sort.Strings(users)
+	items := make([]ListItem, 0, len(users))
+	c.Lock(roomName)
+	for _, user := range users {
+		items = append(items, ListItem{user, c.getUserLabel(roomName, user)})
+	}
+	c.Unlock(roomName)
+	return items
+}
+
+// getUserLabel returns how a user is listed, the moderators are marked. WARN(Santiago): It must be called
+// with the room mutex acquired.
+func (c *CherryRooms) getUserLabel(roomName, user string) string {
+	if _, moderator := c.room(roomName).moderators[user]; moderator {
+		return c.room(roomName).misc.moderatorMarker + user
+	}
+	return user
+}
+
+func (c *CherryRooms) getRoomTemplate(roomName, template string) string {
+	c.room(roomName).mutex.Lock()
+	var data string
+	data = c.room(roomName).templates[template]
+	c.room(roomName).mutex.Unlock()
+	return data
+}
+
+// SetPublicDirectory sets the public directory for a room.
+func (c *CherryRooms) SetPublicDirectory(roomName, value string) {
+	c.Lock(roomName)
+	c.room(roomName).misc.publicDirectory = value
+	c.Unlock(roomName)
+}
+
+// GetPublicDirectory spits the room's public directory.
+func (c *CherryRooms) GetPublicDirectory(roomName string) string {
+	c.Lock(roomName)
+	dirPath := c.room(roomName).misc.publicDirectory
+	c.Unlock(roomName)
+	return dirPath
+}
+
+// GetTopTemplate spits the top template data.
+func (c *CherryRooms) GetTopTemplate(roomName string) string {
+	return c.getRoomTemplate(roomName, "top")
+}
+
+// GetBodyTemplate spits the body template data.
+func (c *CherryRooms) GetBodyTemplate(roomName string) string {
+	return c.getRoomTemplate(roomName, "body")
+}
+
+// GetBannerTemplate spits the banner template data.
+func (c *CherryRooms) GetBannerTemplate(roomName string) string {
+	return c.getRoomTemplate(roomName, "banner")
+}
+
+// GetHighlightTemplate spits the highlight template data.
+func (c *CherryRooms) GetHighlightTemplate(roomName string) string {
+	return c.getRoomTemplate(roomName, "highlight")
+}
+
+// GetEntranceTemplate spits the entrance template data.
+func (c *CherryRooms) GetEntranceTemplate(roomName string) string {
+	return c.getRoomTemplate(roomName, "entrance")
+}
+
+// GetExitTemplate spits the exit template data.
+func (c *CherryRooms) GetExitTemplate(roomName string) string {
+	return c.getRoomTemplate(roomName, "exit")
+}
+
+// GetNickclashTemplate spits the nickclash template data.
+func (c *CherryRooms) GetNickclashTemplate(roomName string) string {
+	return c.getRoomTemplate(roomName, "nickclash")
+}
+
+// GetSkeletonTemplate spits the skeleton template data.
+func (c *CherryRooms) GetSkeletonTemplate(roomName string) string {
+	return c.getRoomTemplate(roomName, "skeleton")
+}
+
+// GetRoomFullTemplate spits the room-full template data.
+func (c *CherryRooms) GetRoomFullTemplate(roomName string) string {
+	return c.getRoomTemplate(roomName, "room-full")
+}
+
+// GetWaitingLineTemplate spits the waiting-line template data.
+func (c *CherryRooms) GetWaitingLineTemplate(roomName string) string {
+	return c.getRoomTemplate(roomName, "waiting-line")
+}
+
+// GetBriefTemplate spits the brief template data.
+func (c *CherryRooms) GetBriefTemplate(roomName string) string {
+	return c.getRoomTemplate(roomName, "brief")
+}
+
+// GetFindResultsHeadTemplate spits the find template data (HEAD).
+func (c *CherryRooms) GetFindResultsHeadTemplate(roomName string) string {
+	return c.getRoomTemplate(roomName, "find-results-head")
+}
+
+// GetFindResultsBodyTemplate spits the find template data (BODY).
+func (c *CherryRooms) GetFindResultsBodyTemplate(roomName string) string {
+	return c.getRoomTemplate(roomName, "find-results-body")
+}
+
+// GetFindResultsTailTemplate spits the find template data (TAIL).
+func (c *CherryRooms) GetFindResultsTailTemplate(roomName string) string {
+	return c.getRoomTemplate(roomName, "find-results-tail")
+}
+
+// GetSearchHeadTemplate spits the message search results template data (HEAD).
+func (c *CherryRooms) GetSearchHeadTemplate(roomName string) string {
+	return c.getRoomTemplate(roomName, "search-head")
+}
+
+// GetSearchBodyTemplate spits the message search results template data (BODY).
+func (c *CherryRooms) GetSearchBodyTemplate(roomName string) string {
+	return c.getRoomTemplate(roomName, "search-body")
+}
+
+// GetSearchTailTemplate spits the message search results template data (TAIL).
+func (c *CherryRooms) GetSearchTailTemplate(roomName string) string {
+	return c.getRoomTemplate(roomName, "search-tail")
+}
+
+// GetSearchBotTemplate spits the message search form template data.
+func (c *CherryRooms) GetSearchBotTemplate(roomName string) string {
+	return c.getRoomTemplate(roomName, "search-bot")
+}
+
+// GetFindBotTemplate spits the find bot template data.
+func (c *CherryRooms) GetFindBotTemplate(roomName string) string {
+	return c.getRoomTemplate(roomName, "find-bot")
 }
 
 // GetLastPublicMessages spits the last public messages (well-formatted in HTML).
@@ -515,7 +1501,7 @@ func (c *CherryRooms) GetLastPublicMessages(roomName string) string {
 	}
 	var retval string
 	c.Lock(roomName)
-	msgs := c.configs[roomName].publicMessages
+	msgs := c.room(roomName).publicMessages
 	c.Unlock(roomName)
 	for _, m := range msgs {
 		retval += m
@@ -529,28 +1515,28 @@ func (c *CherryRooms) AddPublicMessage(roomName, message string) {
 		return
 	}
 	c.Lock(roomName)
-	if len(c.configs[roomName].publicMessages) == 10 {
-		c.configs[roomName].publicMessages = c.configs[roomName].publicMessages[1 : len(c.configs[roomName].publicMessages)-1]
+	if len(c.room(roomName).publicMessages) == 10 {
+		c.room(roomName).publicMessages = c.room(roomName).publicMessages[1 : len(c.room(roomName).publicMessages)-1]
 	}
-	c.configs[roomName].publicMessages = append(c.configs[roomName].publicMessages, message)
+	c.room(roomName).publicMessages = append(c.room(roomName).publicMessages, message)
 	c.Unlock(roomName)
 }
 
 // GetListenPort returns the port that is being used for the room serving.
 func (c *CherryRooms) GetListenPort(roomName string) string {
-	c.configs[roomName].mutex.Lock()
+	c.room(roomName).mutex.Lock()
 	var port string
-	port = fmt.Sprintf("%d", c.configs[roomName].misc.listenPort)
-	c.configs[roomName].mutex.Unlock()
+	port = fmt.Sprintf("%d", c.room(roomName).misc.listenPort)
+	c.room(roomName).mutex.Unlock()
 	return port
 }
 
 // GetUsersTotal returns the total of users currently talking in a room.
 func (c *CherryRooms) GetUsersTotal(roomName string) string {
-	c.configs[roomName].mutex.Lock()
+	c.room(roomName).mutex.Lock()
 	var total string
-	total = fmt.Sprintf("%d", len(c.configs[roomName].users))
-	c.configs[roomName].mutex.Unlock()
+	total = fmt.Sprintf("%d", len(c.room(roomName).users))
+	c.room(roomName).mutex.Unlock()
 	return total
 }
 
@@ -559,24 +1545,152 @@ func (c *CherryRooms) AddRoom(roomName string, listenPort int16) bool {
 	if c.HasRoom(roomName) || c.PortBusyByAnotherRoom(listenPort) {
 		return false
 	}
+	c.mutex.Lock()
 	c.configs[roomName] = c.initConfig()
-	c.configs[roomName].misc.listenPort = listenPort
+	c.mutex.Unlock()
+	c.room(roomName).misc.listenPort = listenPort
+	return true
+}
+
+// AdoptRoom moves a room loaded in @from to the "memory". It returns "false" when the room port is busy.
+func (c *CherryRooms) AdoptRoom(roomName string, from *CherryRooms) bool {
+	room := from.room(roomName)
+	if room == nil || c.HasRoom(roomName) || c.PortBusyByAnotherRoom(room.misc.listenPort) {
+		return false
+	}
+	c.mutex.Lock()
+	c.configs[roomName] = room
+	c.mutex.Unlock()
+	return true
+}
+
+// ReloadRoom applies in place the templates, actions, media resources and misc options of a room loaded in @from.
+// The users stay connected. The listening options are kept and "false" is returned when they were changed.
+func (c *CherryRooms) ReloadRoom(roomName string, from *CherryRooms) bool {
+	newRoom := from.room(roomName)
+	sameListening := (c.GetListenPort(roomName) == from.GetListenPort(roomName) &&
+		c.IsSharingPort(roomName) == from.IsSharingPort(roomName) &&
+		c.GetTLSCertificate(roomName) == from.GetTLSCertificate(roomName) &&
+		c.GetTLSKey(roomName) == from.GetTLSKey(roomName))
+	room := c.room(roomName)
+	room.mutex.Lock()
+	misc := *newRoom.misc
+	misc.listenPort = room.misc.listenPort
+	misc.tlsCertificate = room.misc.tlsCertificate
+	misc.tlsKey = room.misc.tlsKey
+	room.misc = &misc
+	room.templates = newRoom.templates
+	room.compiled = newRoom.compiled
+	room.actions = newRoom.actions
+	room.images = newRoom.images
+	room.sounds = newRoom.sounds
+	room.ignoreAction = newRoom.ignoreAction
+	room.deignoreAction = newRoom.deignoreAction
+	for _, bot := range room.bots {
+		bot.Stop()
+	}
+	room.bots = newRoom.bots
+	if room.webhooks != nil {
+		room.webhooks.Stop()
+	}
+	room.webhooks = newRoom.webhooks
+	room.hooks = newRoom.hooks
+	room.moderators = newRoom.moderators
+	for _, user := range room.users {
+		//  INFO(Santiago): The buckets will be refilled following the new flood options.
+		user.flood = nil
+	}
+	c.updateWaitingLine(roomName)
+	room.mutex.Unlock()
+	return sameListening
+}
+
+// RemoveRoom stops the message delivering of a room, disconnects its users and closes it. The room listener
+// is closed too, unless it is the shared port listener. From now on HasRoom does not find the room, but who
+// is still using it by the name (requests being answered, user writers, etc) keeps working on the closed room.
+func (c *CherryRooms) RemoveRoom(roomName string) {
+	if !c.HasRoom(roomName) {
+		return
+	}
+	room := c.room(roomName)
+	sharing := c.IsSharingPort(roomName)
+	close(room.quit)
+	//  INFO(Santiago): Waiting for any message being delivered, after that the plexer will give up.
+	room.delivering.Lock()
+	c.mutex.Lock()
+	room.closed = true
+	c.mutex.Unlock()
+	room.delivering.Unlock()
+	room.mutex.Lock()
+	for _, bot := range room.bots {
+		bot.Stop()
+	}
+	if room.webhooks != nil {
+		room.webhooks.Stop()
+	}
+	for _, user := range room.users {
+		if user.outbox != nil {
+			close(user.outbox)
+			user.outbox = nil
+		}
+		if user.conn != nil {
+			user.conn.Close()
+		}
+	}
+	//  INFO(Santiago): Nobody will read these anymore.
+	room.users = make(map[string]*RoomUser)
+	room.messageQueue = make([]Message, 0)
+	room.publicMessages = make([]string, 0)
+	room.waitingLine = make([]*waitingUser, 0)
+	room.admitted = make(map[string]*waitingUser)
+	room.mutex.Unlock()
+	if !sharing && room.MainPeer != nil {
+		room.MainPeer.Close()
+	}
+}
+
+// WaitForMessages blocks until some message is enqueued. It returns "false" when the room was removed.
+func (r *RoomConfig) WaitForMessages() bool {
+	select {
+	case <-r.pending:
+		return true
+
+	case <-r.quit:
+		return false
+	}
+}
+
+// BeginDelivery must be called before delivering a message of the room. It returns "false" when the room was removed.
+func (r *RoomConfig) BeginDelivery() bool {
+	r.delivering.Lock()
+	select {
+	case <-r.quit:
+		r.delivering.Unlock()
+		return false
+	default:
+	}
 	return true
 }
 
+// EndDelivery must be called after delivering a message of the room.
+func (r *RoomConfig) EndDelivery() {
+	r.delivering.Unlock()
+}
+
 // AddAction adds an action to the "memory".
 func (c *CherryRooms) AddAction(roomName, id, label, template string) {
-	c.configs[roomName].actions[id] = &RoomAction{label, template}
+	c.room(roomName).actions[id] = &RoomAction{label, template}
 }
 
 // AddImage adds an image (data that represents an image) to the "memory".
 func (c *CherryRooms) AddImage(roomName, id, label, template, url string) {
-	c.configs[roomName].images[id] = c.newMediaResource(label, template, url)
+	c.room(roomName).images[id] = c.newMediaResource(label, template, url)
 }
 
-//func (c *CherryRooms) AddSound(room_name, id, label, template, url string) {
-//    c.configs[room_name].sounds[id] = c.newMediaResource(label, template, url)
-//}
+// AddSound adds a sound to the "memory".
+func (c *CherryRooms) AddSound(roomName, id, label, template, url string) {
+	c.room(roomName).sounds[id] = c.newMediaResource(label, template, url)
+}
 
 func (c *CherryRooms) newMediaResource(label, template, url string) *RoomMediaResource {
 	return &RoomMediaResource{label, template, url}
@@ -584,41 +1698,62 @@ func (c *CherryRooms) newMediaResource(label, template, url string) *RoomMediaRe
 
 // HasAction verifies if an action really exists for the indicated room.
 func (c *CherryRooms) HasAction(roomName, id string) bool {
-	_, ok := c.configs[roomName].actions[id]
+	c.Lock(roomName)
+	_, ok := c.room(roomName).actions[id]
+	c.Unlock(roomName)
 	return ok
 }
 
 // HasImage verifies if an image really exists for the indicated room.
 func (c *CherryRooms) HasImage(roomName, id string) bool {
-	_, ok := c.configs[roomName].images[id]
+	c.Lock(roomName)
+	_, ok := c.room(roomName).images[id]
+	c.Unlock(roomName)
 	return ok
 }
 
-//func (c *CherryRooms) HasSound(room_name, id string) bool {
-//    _, ok := c.configs[room_name].sounds[id]
-//    return ok
-//}
+// HasSound verifies if a sound really exists for the indicated room.
+func (c *CherryRooms) HasSound(roomName, id string) bool {
+	c.Lock(roomName)
+	_, ok := c.room(roomName).sounds[id]
+	c.Unlock(roomName)
+	return ok
+}
 
 // HasRoom verifies if a room really exists in this server.
 func (c *CherryRooms) HasRoom(roomName string) bool {
-	_, ok := c.configs[roomName]
-	return ok
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	room, ok := c.configs[roomName]
+	return ok && !room.closed
 }
 
 // PortBusyByAnotherRoom verifies if there is some port clash between rooms.
 func (c *CherryRooms) PortBusyByAnotherRoom(port int16) bool {
-	for _, c := range c.configs {
-		if c.misc.listenPort == port {
+	if c.sharedPort != 0 && c.sharedPort == port {
+		return false
+	}
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	for _, r := range c.configs {
+		if !r.closed && r.misc.listenPort == port {
 			return true
 		}
 	}
 	return false
 }
 
+// GetRoom returns a room (all configuration from it) given its name.
+func (c *CherryRooms) GetRoom(roomName string) *RoomConfig {
+	return c.room(roomName)
+}
+
 // GetRoomByPort returns a room (all configuration from it) given a port.
 func (c *CherryRooms) GetRoomByPort(port int16) *RoomConfig {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
 	for _, r := range c.configs {
-		if r.misc.listenPort == port {
+		if !r.closed && r.misc.listenPort == port {
 			return r
 		}
 	}
@@ -628,95 +1763,299 @@ func (c *CherryRooms) GetRoomByPort(port int16) *RoomConfig {
 func (c *CherryRooms) initConfig() *RoomConfig {
 	var roomConfig *RoomConfig
 	roomConfig = new(RoomConfig)
-	roomConfig.misc = &RoomMisc{}
+	roomConfig.misc = &RoomMisc{floodRate: 30,
+		floodBurst:                5,
+		floodWarningsBeforeMute:   2,
+		floodMuteTime:             30,
+		floodForgiveTime:          600,
+		maxFloodAllowedBeforeKick: 5,
+		floodWarningMessage:       "(only you can see this) slow down, please.",
+		floodMuteMessage:          "(only you can see this) you were muted for flooding.",
+		floodKickMessage:          "was kicked out for flooding.",
+		slowClientPolicy:          "drop",
+		outboundQueueSize:         64,
+		writeTimeout:              10,
+		waitingLineTimeout:        60,
+		hookRate:                  30,
+		hookBurst:                 5,
+		kickMessage:               "was kicked out by a moderator.",
+		muteMessage:               "was muted by a moderator.",
+		banMessage:                "was banned by a moderator.",
+		muteTime:                  300,
+		moderatorMarker:           "@",
+		inviteTime:                86400,
+		joinFailureRate:           2,
+		joinFailureBurst:          5}
 	roomConfig.messageQueue = make([]Message, 0)
 	roomConfig.publicMessages = make([]string, 0)
 	roomConfig.users = make(map[string]*RoomUser)
 	roomConfig.templates = make(map[string]string)
+	roomConfig.compiled = make(map[string]interface{})
 	roomConfig.actions = make(map[string]*RoomAction)
 	roomConfig.images = make(map[string]*RoomMediaResource)
-	//room_config.sounds = make(map[string]*RoomMediaResource)
+	roomConfig.waitingLine = make([]*waitingUser, 0)
+	roomConfig.admitted = make(map[string]*waitingUser)
+	roomConfig.sounds = make(map[string]*RoomMediaResource)
+	roomConfig.bots = make(map[string]RoomObserver)
+	roomConfig.hooks = make(map[string]*incomingHook)
+	roomConfig.moderators = make(map[string]string)
+	roomConfig.invites = make(map[string]time.Time)
+	roomConfig.joinFailures = make(map[string]*ratelimit.Bucket)
 	roomConfig.mutex = new(sync.Mutex)
+	roomConfig.delivering = new(sync.Mutex)
+	roomConfig.pending = make(chan bool, 1)
+	roomConfig.quit = make(chan bool)
 	return roomConfig
 }
 
 // AddTemplate adds a template based on room name, ID.
 func (c *CherryRooms) AddTemplate(roomName, id, template string) {
-	c.configs[roomName].templates[id] = template
+	c.room(roomName).templates[id] = template
+}
+
+// AddCompiledTemplate keeps the compiled form of a template (or action template) data of a room, it is done
+// when the cherry file is loaded, so the requests only execute it.
+func (c *CherryRooms) AddCompiledTemplate(roomName, data string, template interface{}) {
+	c.room(roomName).compiled[data] = template
+}
+
+// GetCompiledTemplate returns the compiled form of a template data of a room or nil when it was not compiled.
+func (c *CherryRooms) GetCompiledTemplate(roomName, data string) interface{} {
+	c.Lock(roomName)
+	template := c.room(roomName).compiled[data]
+	c.Unlock(roomName)
+	return template
+}
+
+// HasTemplate verifies if a template really exists for a room.
+func (c *CherryRooms) HasTemplate(roomName, id string) bool {
+	c.Lock(roomName)
+	_, ok := c.room(roomName).templates[id]
+	c.Unlock(roomName)
+	return ok
+}
+
+// SetJoinMessage sets the join message.
+func (c *CherryRooms) SetJoinMessage(roomName, message string) {
+	c.room(roomName).misc.joinMessage = message
+}
+
+// SetExitMessage sets the exit message.
+func (c *CherryRooms) SetExitMessage(roomName, message string) {
+	c.room(roomName).misc.exitMessage = message
+}
+
+// SetOnIgnoreMessage sets the "on ignore" message.
+func (c *CherryRooms) SetOnIgnoreMessage(roomName, message string) {
+	c.room(roomName).misc.onIgnoreMessage = message
+}
+
+// SetOnDeIgnoreMessage sets the "on deignore" message.
+func (c *CherryRooms) SetOnDeIgnoreMessage(roomName, message string) {
+	c.room(roomName).misc.onDeIgnoreMessage = message
+}
+
+// SetGreetingMessage sets the greeting message.
+func (c *CherryRooms) SetGreetingMessage(roomName, message string) {
+	c.room(roomName).misc.greetingMessage = message
+}
+
+// SetPrivateMessageMarker sets the private message marker.
+func (c *CherryRooms) SetPrivateMessageMarker(roomName, marker string) {
+	c.room(roomName).misc.privateMessageMarker = marker
+}
+
+// SetMaxUsers sets the maximum of users allowed in a room.
+func (c *CherryRooms) SetMaxUsers(roomName string, value int) {
+	c.room(roomName).misc.maxUsers = value
+}
+
+// SetAllowBrief sets the allow brief option.
+func (c *CherryRooms) SetAllowBrief(roomName string, value bool) {
+	c.room(roomName).misc.allowBrief = value
+}
+
+// IsAllowingBriefs verifies if briefs are allowed for a room.
+func (c *CherryRooms) IsAllowingBriefs(roomName string) bool {
+	return c.room(roomName).misc.allowBrief
+}
+
+// SetFloodingPolice sets if the flooding police should watch the room.
+func (c *CherryRooms) SetFloodingPolice(roomName string, value bool) {
+	c.room(roomName).misc.floodingPolice = value
+}
+
+// SetMaxFloodAllowedBeforeKick sets how many floods a user can do before being kicked out (0 means never).
+func (c *CherryRooms) SetMaxFloodAllowedBeforeKick(roomName string, value int) {
+	c.room(roomName).misc.maxFloodAllowedBeforeKick = value
+}
+
+// IsUsingFloodingPolice verifies if the flooding police is watching the room.
+func (c *CherryRooms) IsUsingFloodingPolice(roomName string) bool {
+	c.Lock(roomName)
+	value := c.room(roomName).misc.floodingPolice
+	c.Unlock(roomName)
+	return value
+}
+
+// GetFloodRate returns how many messages per minute a user can send in the long run.
+func (c *CherryRooms) GetFloodRate(roomName string) int {
+	c.Lock(roomName)
+	value := c.room(roomName).misc.floodRate
+	c.Unlock(roomName)
+	return value
+}
+
+// GetFloodBurst returns how many messages a user can send in a row.
+func (c *CherryRooms) GetFloodBurst(roomName string) int {
+	c.Lock(roomName)
+	value := c.room(roomName).misc.floodBurst
+	c.Unlock(roomName)
+	return value
+}
+
+// SetFloodRate sets how many messages per minute a user can send in the long run.
+func (c *CherryRooms) SetFloodRate(roomName string, value int) {
+	c.room(roomName).misc.floodRate = value
+}
+
+// SetFloodBurst sets how many messages a user can send in a row.
+func (c *CherryRooms) SetFloodBurst(roomName string, value int) {
+	c.room(roomName).misc.floodBurst = value
+}
+
+// GetHookRate returns how many messages per minute an incoming hook can post in the long run.
+func (c *CherryRooms) GetHookRate(roomName string) int {
+	c.Lock(roomName)
+	value := c.room(roomName).misc.hookRate
+	c.Unlock(roomName)
+	return value
+}
+
+// GetHookBurst returns how many messages an incoming hook can post in a row.
+func (c *CherryRooms) GetHookBurst(roomName string) int {
+	c.Lock(roomName)
+	value := c.room(roomName).misc.hookBurst
+	c.Unlock(roomName)
+	return value
 }
 
-// HasTemplate verifies if a template really exists for a room.
-func (c *CherryRooms) HasTemplate(roomName, id string) bool {
-	_, ok := c.configs[roomName].templates[id]
-	return ok
+// SetHookRate sets how many messages per minute an incoming hook can post in the long run.
+func (c *CherryRooms) SetHookRate(roomName string, value int) {
+	c.room(roomName).misc.hookRate = value
 }
 
-// SetJoinMessage sets the join message.
-func (c *CherryRooms) SetJoinMessage(roomName, message string) {
-	c.configs[roomName].misc.joinMessage = message
+// SetHookBurst sets how many messages an incoming hook can post in a row.
+func (c *CherryRooms) SetHookBurst(roomName string, value int) {
+	c.room(roomName).misc.hookBurst = value
 }
 
-// SetExitMessage sets the exit message.
-func (c *CherryRooms) SetExitMessage(roomName, message string) {
-	c.configs[roomName].misc.exitMessage = message
+// SetFloodWarningsBeforeMute sets how many warnings a user gets before being muted.
+func (c *CherryRooms) SetFloodWarningsBeforeMute(roomName string, value int) {
+	c.room(roomName).misc.floodWarningsBeforeMute = value
 }
 
-// SetOnIgnoreMessage sets the "on ignore" message.
-func (c *CherryRooms) SetOnIgnoreMessage(roomName, message string) {
-	c.configs[roomName].misc.onIgnoreMessage = message
+// SetFloodMuteTime sets for how many seconds a flooder stays muted.
+func (c *CherryRooms) SetFloodMuteTime(roomName string, seconds int) {
+	c.room(roomName).misc.floodMuteTime = seconds
 }
 
-// SetOnDeIgnoreMessage sets the "on deignore" message.
-func (c *CherryRooms) SetOnDeIgnoreMessage(roomName, message string) {
-	c.configs[roomName].misc.onDeIgnoreMessage = message
+// SetFloodForgiveTime sets for how many seconds a user must not flood to have the previous floods forgiven.
+func (c *CherryRooms) SetFloodForgiveTime(roomName string, seconds int) {
+	c.room(roomName).misc.floodForgiveTime = seconds
 }
 
-// SetGreetingMessage sets the greeting message.
-func (c *CherryRooms) SetGreetingMessage(roomName, message string) {
-	c.configs[roomName].misc.greetingMessage = message
+// SetFloodWarningMessage sets the message that warns a flooder.
+func (c *CherryRooms) SetFloodWarningMessage(roomName, message string) {
+	c.room(roomName).misc.floodWarningMessage = message
 }
 
-// SetPrivateMessageMarker sets the private message marker.
-func (c *CherryRooms) SetPrivateMessageMarker(roomName, marker string) {
-	c.configs[roomName].misc.privateMessageMarker = marker
+// SetFloodMuteMessage sets the message that tells a flooder about the mute.
+func (c *CherryRooms) SetFloodMuteMessage(roomName, message string) {
+	c.room(roomName).misc.floodMuteMessage = message
 }
 
-// SetMaxUsers sets the maximum of users allowed in a room.
-func (c *CherryRooms) SetMaxUsers(roomName string, value int) {
-	c.configs[roomName].misc.maxUsers = value
+// SetFloodKickMessage sets the message that announces a kicked out flooder.
+func (c *CherryRooms) SetFloodKickMessage(roomName, message string) {
+	c.room(roomName).misc.floodKickMessage = message
 }
 
-// SetAllowBrief sets the allow brief option.
-func (c *CherryRooms) SetAllowBrief(roomName string, value bool) {
-	c.configs[roomName].misc.allowBrief = value
+// GetFloodWarningMessage returns the message that warns a flooder.
+func (c *CherryRooms) GetFloodWarningMessage(roomName string) string {
+	c.Lock(roomName)
+	var message string
+	message = c.room(roomName).misc.floodWarningMessage
+	c.Unlock(roomName)
+	return message
 }
 
-// IsAllowingBriefs verifies if briefs are allowed for a room.
-func (c *CherryRooms) IsAllowingBriefs(roomName string) bool {
-	return c.configs[roomName].misc.allowBrief
+// GetFloodMuteMessage returns the message that tells a flooder about the mute.
+func (c *CherryRooms) GetFloodMuteMessage(roomName string) string {
+	c.Lock(roomName)
+	var message string
+	message = c.room(roomName).misc.floodMuteMessage
+	c.Unlock(roomName)
+	return message
 }
 
-//func (c *CherryRooms) SetFloodingPolice(roomName string, value bool) {
-//    c.configs[roomName].misc.floodingPolice = value
-//}
+// GetFloodKickMessage returns the message that announces a kicked out flooder.
+func (c *CherryRooms) GetFloodKickMessage(roomName string) string {
+	c.Lock(roomName)
+	var message string
+	message = c.room(roomName).misc.floodKickMessage
+	c.Unlock(roomName)
+	return message
+}
 
-//func (c *CherryRooms) SetMaxFloodAllowedBeforeKick(roomName string, value int) {
-//    c.configs[roomName].misc.maxFloodAllowedBeforeKick = value
-//}
+// PoliceFlood takes one token from the user's bucket and decides what to do with the message.
+// Each message refused counts as a flood: the user is warned, then muted and finally kicked out.
+func (c *CherryRooms) PoliceFlood(roomName, user string) FloodVerdict {
+	c.Lock(roomName)
+	defer c.Unlock(roomName)
+	misc := c.room(roomName).misc
+	u, ok := c.room(roomName).users[user]
+	if !misc.floodingPolice || !ok {
+		return FloodAllowed
+	}
+	if u.flood == nil {
+		u.flood = ratelimit.NewBucket(float64(misc.floodRate)/60.0, misc.floodBurst)
+	}
+	now := time.Now()
+	if now.Before(u.mutedUntil) {
+		//  INFO(Santiago): The user was already punished for it, typing while muted does not make it worse.
+		return FloodDropped
+	}
+	if u.flood.Allow() {
+		return FloodAllowed
+	}
+	if now.Sub(u.lastViolation) > time.Duration(misc.floodForgiveTime)*time.Second {
+		u.violations = 0
+	}
+	u.violations++
+	u.lastViolation = now
+	if misc.maxFloodAllowedBeforeKick > 0 && u.violations >= misc.maxFloodAllowedBeforeKick {
+		return FloodKicked
+	}
+	if u.violations > misc.floodWarningsBeforeMute {
+		u.mutedUntil = time.Now().Add(time.Duration(misc.floodMuteTime) * time.Second)
+		return FloodMuted
+	}
+	return FloodWarned
+}
 
 // SetAllUsersAlias sets all users alias.
 func (c *CherryRooms) SetAllUsersAlias(roomName, alias string) {
-	c.configs[roomName].misc.allUsersAlias = alias
+	c.room(roomName).misc.allUsersAlias = alias
 }
 
 // Lock acquire the room mutex.
 func (c *CherryRooms) Lock(roomName string) {
-	c.configs[roomName].mutex.Lock()
+	c.room(roomName).mutex.Lock()
 }
 
 // Unlock dispose the room mutex.
 func (c *CherryRooms) Unlock(roomName string) {
-	c.configs[roomName].mutex.Unlock()
+	c.room(roomName).mutex.Unlock()
 }
 
 // GetServername spits the server name.
@@ -731,51 +2070,250 @@ func (c *CherryRooms) SetServername(servername string) {
 
 // HasUser verifies if the user is connected in the room.
 func (c *CherryRooms) HasUser(roomName, user string) bool {
-	_, ok := c.configs[roomName]
-	if !ok {
+	room := c.room(roomName)
+	if room == nil {
 		return false
 	}
-	_, ok = c.configs[roomName].users[user]
+	room.mutex.Lock()
+	_, ok := room.users[user]
+	room.mutex.Unlock()
 	return ok
 }
 
-// IsValidUserRequest verifies if the session ID really matches with the previously defined.
+// hasUsers verifies if all users are connected in the room. WARN(Santiago): It must be called with the room mutex acquired.
+func (c *CherryRooms) hasUsers(roomName string, users ...string) bool {
+	for _, user := range users {
+		if _, ok := c.room(roomName).users[user]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// GetRemoteAddr returns the address (without the port) of who is on the other side of @conn, IPv6 addresses
+// included.
+func GetRemoteAddr(conn net.Conn) string {
+	addr := conn.RemoteAddr().String()
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		//  INFO(Santiago): It is not a host:port pair (e.g. an in-memory connection), so there is no port to cut.
+		return addr
+	}
+	return host
+}
+
+// IsValidUserRequest verifies if the session ID really matches with the previously defined and if it is not expired.
 func (c *CherryRooms) IsValidUserRequest(roomName, user, id string, userConn net.Conn) bool {
 	var valid = false
 	if c.HasUser(roomName, user) {
-		valid = (id == c.GetSessionID(user, roomName))
+		valid = (len(id) > 0 && subtle.ConstantTimeCompare([]byte(id), []byte(c.GetSessionID(user, roomName))) == 1)
 		if valid {
 			c.Lock(roomName)
-			userAddr := strings.Split(userConn.RemoteAddr().String(), ":")
-			realAddr := c.configs[roomName].users[user].addr
+			u, ok := c.room(roomName).users[user]
+			valid = ok
+			if valid {
+				userAddr := GetRemoteAddr(userConn)
+				if len(u.addr) > 0 {
+					valid = (u.addr == userAddr)
+				}
+				//  INFO(Santiago): The moderators are never kept out by the bans.
+				_, isModerator := c.room(roomName).moderators[user]
+				valid = valid && (isModerator || !c.bans.IsBanned(roomName, user, userAddr))
+			}
+			expired := ok && c.isExpired(roomName, u)
+			if expired {
+				valid = false
+				c.removeUser(roomName, user)
+			}
+			if valid {
+				u.lastSeen = time.Now()
+			}
 			c.Unlock(roomName)
-			if len(realAddr) > 0 && len(userAddr) > 0 {
-				valid = (realAddr == userAddr[0])
+			if expired {
+				c.EnqueueNotice(roomName, user, c.GetExitMessage(roomName), "")
 			}
 		}
 	}
 	return valid
 }
 
+// isExpired verifies if a session was not used during the session-lifetime. A user with an attached stream (body
+// or WebSocket) is still here. WARN(Santiago): It must be called with the room mutex acquired.
+func (c *CherryRooms) isExpired(roomName string, u *RoomUser) bool {
+	lifetime := time.Duration(c.room(roomName).misc.sessionLifetime) * time.Second
+	return lifetime > 0 && u.conn == nil && time.Since(u.lastSeen) > lifetime
+}
+
+// ExpireSessions removes the users whose sessions expired and announces their exits. Their nicknames and
+// places become free.
+func (c *CherryRooms) ExpireSessions(roomName string) {
+	var expired []string
+	c.Lock(roomName)
+	for nickname, u := range c.room(roomName).users {
+		if c.isExpired(roomName, u) {
+			expired = append(expired, nickname)
+		}
+	}
+	for _, nickname := range expired {
+		c.removeUser(roomName, nickname)
+	}
+	c.Unlock(roomName)
+	for _, nickname := range expired {
+		c.EnqueueNotice(roomName, nickname, c.GetExitMessage(roomName), "")
+	}
+}
+
+// SetSessionLifetime sets how many seconds a session survives without requests (zero means forever).
+func (c *CherryRooms) SetSessionLifetime(roomName string, seconds int) {
+	c.room(roomName).misc.sessionLifetime = seconds
+}
+
+// SetSessionCookie sets if the session ID should be transported through a cookie instead of the URLs.
+func (c *CherryRooms) SetSessionCookie(roomName string, value bool) {
+	c.room(roomName).misc.sessionCookie = value
+}
+
+// IsUsingSessionCookie verifies if the session ID is transported through a cookie.
+func (c *CherryRooms) IsUsingSessionCookie(roomName string) bool {
+	c.Lock(roomName)
+	value := c.room(roomName).misc.sessionCookie
+	c.Unlock(roomName)
+	return value
+}
+
+// GetSessionCookieName returns the name of the cookie that carries the session ID of a room.
+func (c *CherryRooms) GetSessionCookieName(roomName string) string {
+	return "cherry-" + roomName
+}
+
 // SetIgnoreAction sets the action that will be used for ignoring.
 func (c *CherryRooms) SetIgnoreAction(roomName, action string) {
 	c.Lock(roomName)
-	c.configs[roomName].ignoreAction = action
+	c.room(roomName).ignoreAction = action
 	c.Unlock(roomName)
 }
 
 // SetDeIgnoreAction sets the action that will be used for "deignoring".
 func (c *CherryRooms) SetDeIgnoreAction(roomName, action string) {
 	c.Lock(roomName)
-	c.configs[roomName].deignoreAction = action
+	c.room(roomName).deignoreAction = action
+	c.Unlock(roomName)
+}
+
+// SetKickAction sets the action that kicks a user out (only for moderators).
+func (c *CherryRooms) SetKickAction(roomName, action string) {
+	c.room(roomName).misc.kickAction = action
+}
+
+// SetMuteAction sets the action that mutes a user (only for moderators).
+func (c *CherryRooms) SetMuteAction(roomName, action string) {
+	c.room(roomName).misc.muteAction = action
+}
+
+// SetBanAction sets the action that bans a user (only for moderators).
+func (c *CherryRooms) SetBanAction(roomName, action string) {
+	c.room(roomName).misc.banAction = action
+}
+
+// GetKickAction returns the action that kicks a user out.
+func (c *CherryRooms) GetKickAction(roomName string) string {
+	c.Lock(roomName)
+	value := c.room(roomName).misc.kickAction
+	c.Unlock(roomName)
+	return value
+}
+
+// GetMuteAction returns the action that mutes a user.
+func (c *CherryRooms) GetMuteAction(roomName string) string {
+	c.Lock(roomName)
+	value := c.room(roomName).misc.muteAction
+	c.Unlock(roomName)
+	return value
+}
+
+// GetBanAction returns the action that bans a user.
+func (c *CherryRooms) GetBanAction(roomName string) string {
+	c.Lock(roomName)
+	value := c.room(roomName).misc.banAction
+	c.Unlock(roomName)
+	return value
+}
+
+// SetKickMessage sets the message that announces a user kicked out by a moderator.
+func (c *CherryRooms) SetKickMessage(roomName, message string) {
+	c.room(roomName).misc.kickMessage = message
+}
+
+// SetMuteMessage sets the message that announces a user muted by a moderator.
+func (c *CherryRooms) SetMuteMessage(roomName, message string) {
+	c.room(roomName).misc.muteMessage = message
+}
+
+// SetBanMessage sets the message that announces a user banned by a moderator.
+func (c *CherryRooms) SetBanMessage(roomName, message string) {
+	c.room(roomName).misc.banMessage = message
+}
+
+// GetKickMessage returns the message that announces a user kicked out by a moderator.
+func (c *CherryRooms) GetKickMessage(roomName string) string {
+	c.Lock(roomName)
+	value := c.room(roomName).misc.kickMessage
+	c.Unlock(roomName)
+	return value
+}
+
+// GetMuteMessage returns the message that announces a user muted by a moderator.
+func (c *CherryRooms) GetMuteMessage(roomName string) string {
+	c.Lock(roomName)
+	value := c.room(roomName).misc.muteMessage
+	c.Unlock(roomName)
+	return value
+}
+
+// GetBanMessage returns the message that announces a user banned by a moderator.
+func (c *CherryRooms) GetBanMessage(roomName string) string {
+	c.Lock(roomName)
+	value := c.room(roomName).misc.banMessage
+	c.Unlock(roomName)
+	return value
+}
+
+// SetMuteTime sets for how many seconds the mute action keeps a user quiet.
+func (c *CherryRooms) SetMuteTime(roomName string, seconds int) {
+	c.room(roomName).misc.muteTime = seconds
+}
+
+// SetBanTime sets for how many seconds the ban action keeps a user out (zero means forever).
+func (c *CherryRooms) SetBanTime(roomName string, seconds int) {
+	c.room(roomName).misc.banTime = seconds
+}
+
+// GetBanTime returns for how many seconds the ban action keeps a user out (zero means forever).
+func (c *CherryRooms) GetBanTime(roomName string) int {
+	c.Lock(roomName)
+	value := c.room(roomName).misc.banTime
+	c.Unlock(roomName)
+	return value
+}
+
+// GetMuteTime returns for how many seconds the mute action keeps a user quiet.
+func (c *CherryRooms) GetMuteTime(roomName string) int {
+	c.Lock(roomName)
+	value := c.room(roomName).misc.muteTime
 	c.Unlock(roomName)
+	return value
+}
+
+// SetModeratorMarker sets what is put before the moderators' nicknames in the users list.
+func (c *CherryRooms) SetModeratorMarker(roomName, marker string) {
+	c.room(roomName).misc.moderatorMarker = marker
 }
 
 // GetIgnoreAction returns the action that represents the ignoring.
 func (c *CherryRooms) GetIgnoreAction(roomName string) string {
 	c.Lock(roomName)
 	var retval string
-	retval = c.configs[roomName].ignoreAction
+	retval = c.room(roomName).ignoreAction
 	c.Unlock(roomName)
 	return retval
 }
@@ -784,23 +2322,341 @@ func (c *CherryRooms) GetIgnoreAction(roomName string) string {
 func (c *CherryRooms) GetDeIgnoreAction(roomName string) string {
 	c.Lock(roomName)
 	var retval string
-	retval = c.configs[roomName].deignoreAction
+	retval = c.room(roomName).deignoreAction
 	c.Unlock(roomName)
 	return retval
 }
 
 // SetUserConnection registers a connection for a user recently enrolled in a room.
+// From now on the messages sent to this user are written to this connection by its own writer.
 func (c *CherryRooms) SetUserConnection(roomName, user string, conn net.Conn) {
 	c.Lock(roomName)
-	c.configs[roomName].users[user].conn = conn
-	remoteAddr := strings.Split(conn.RemoteAddr().String(), ":")
-	if len(remoteAddr) > 0 {
-		c.configs[roomName].users[user].addr = remoteAddr[0]
+	room := c.room(roomName)
+	u, ok := room.users[user]
+	if !ok {
+		//  INFO(Santiago): The user has just left.
+		c.Unlock(roomName)
+		conn.Close()
+		return
+	}
+	u.conn = conn
+	u.addr = GetRemoteAddr(conn)
+	if u.outbox != nil {
+		//  INFO(Santiago): The writer of the previous connection gives up.
+		close(u.outbox)
+	}
+	u.outbox = make(chan []byte, room.misc.outboundQueueSize)
+	go c.userWriter(roomName, u, conn, u.outbox, time.Duration(room.misc.writeTimeout)*time.Second)
+	c.Unlock(roomName)
+}
+
+// userWriter writes to the user connection what comes from the outbound queue. A write that takes
+// more than @timeout drops the connection. The writer holds the user itself instead of the nickname,
+// because the nickname can change (see RenameUser) while the connection lives.
+func (c *CherryRooms) userWriter(roomName string, u *RoomUser, conn net.Conn, outbox chan []byte, timeout time.Duration) {
+	for data := range outbox {
+		if timeout > 0 {
+			conn.SetWriteDeadline(time.Now().Add(timeout))
+		}
+		if _, err := conn.Write(data); err != nil {
+			c.dropConnection(roomName, conn)
+			return
+		}
+		//  INFO(Santiago): Someone that only reads the stream is still using the session.
+		if room := c.room(roomName); room != nil {
+			room.mutex.Lock()
+			if u.conn == conn {
+				u.lastSeen = time.Now()
+			}
+			room.mutex.Unlock()
+		}
+		if timeout > 0 {
+			//  INFO(Santiago): Other writes (e.g. WebSocket pongs) must not inherit this deadline.
+			conn.SetWriteDeadline(time.Time{})
+		}
+	}
+}
+
+// SendToUser puts data in the user outbound queue. It returns "false" when the queue is full, it means that
+// the user fell behind.
+func (c *CherryRooms) SendToUser(roomName, user string, data []byte) bool {
+	c.Lock(roomName)
+	defer c.Unlock(roomName)
+	u, ok := c.room(roomName).users[user]
+	if !ok || u.outbox == nil {
+		return true
+	}
+	select {
+	case u.outbox <- data:
+		return true
+
+	default:
+		return false
+	}
+}
+
+// DisconnectUser closes the user connection, removes the user and announces the exit.
+func (c *CherryRooms) DisconnectUser(roomName, user string) {
+	if !c.HasUser(roomName, user) {
+		return
+	}
+	if conn := c.GetUserConnection(roomName, user); conn != nil {
+		c.dropConnection(roomName, conn)
+	}
+}
+
+// dropConnection closes a broken (or too slow) connection. The user is removed only if this is still
+// the user's connection, whatever the user's nickname is now.
+func (c *CherryRooms) dropConnection(roomName string, conn net.Conn) {
+	room := c.room(roomName)
+	var user string
+	var owner bool
+	if room != nil {
+		room.mutex.Lock()
+		user, owner = c.getConnectionOwner(roomName, conn)
+		if owner {
+			c.removeUser(roomName, user)
+		}
+		room.mutex.Unlock()
+	}
+	//  INFO(Santiago): Closing may have to wait for a blocked write, nobody else should wait for it.
+	go conn.Close()
+	if owner {
+		c.EnqueueNotice(roomName, user, c.GetExitMessage(roomName), "")
+	}
+}
+
+// getConnectionOwner returns the nickname of the user that owns @conn. WARN(Santiago): It must be called
+// with the room mutex acquired.
+func (c *CherryRooms) getConnectionOwner(roomName string, conn net.Conn) (string, bool) {
+	for user, u := range c.room(roomName).users {
+		if u.conn == conn {
+			return user, true
+		}
 	}
+	return "", false
+}
+
+// SetAllowedMarkup sets the tags that the users can use in their messages.
+func (c *CherryRooms) SetAllowedMarkup(roomName string, tags []string) {
+	c.room(roomName).misc.allowedMarkup = tags
+}
+
+// GetAllowedMarkup returns the tags that the users can use in their messages.
+func (c *CherryRooms) GetAllowedMarkup(roomName string) []string {
+	c.Lock(roomName)
+	tags := c.room(roomName).misc.allowedMarkup
+	c.Unlock(roomName)
+	return tags
+}
+
+// SetSlowClientPolicy sets what to do with users that fall behind ("drop" their messages or "disconnect" them).
+func (c *CherryRooms) SetSlowClientPolicy(roomName, policy string) {
+	c.room(roomName).misc.slowClientPolicy = policy
+}
+
+// GetSlowClientPolicy returns what to do with users that fall behind.
+func (c *CherryRooms) GetSlowClientPolicy(roomName string) string {
+	c.Lock(roomName)
+	policy := c.room(roomName).misc.slowClientPolicy
+	c.Unlock(roomName)
+	return policy
+}
+
+// SetOutboundQueueSize sets how many messages can wait to be written to a user.
+func (c *CherryRooms) SetOutboundQueueSize(roomName string, size int) {
+	c.room(roomName).misc.outboundQueueSize = size
+}
+
+// GetOutboundQueueSize returns how many messages can wait to be written to a user.
+func (c *CherryRooms) GetOutboundQueueSize(roomName string) int {
+	c.Lock(roomName)
+	size := c.room(roomName).misc.outboundQueueSize
 	c.Unlock(roomName)
+	return size
+}
+
+// SetWriteTimeout sets how many seconds a write to a user can take (zero means forever).
+func (c *CherryRooms) SetWriteTimeout(roomName string, seconds int) {
+	c.room(roomName).misc.writeTimeout = seconds
 }
 
 // GetServerName spits the server name.
 func (c *CherryRooms) GetServerName() string {
 	return c.servername
 }
+
+// SetTLSCertificate sets the default certificate file path used by all rooms.
+func (c *CherryRooms) SetTLSCertificate(filePath string) {
+	c.tlsCertificate = filePath
+}
+
+// SetTLSKey sets the default private key file path used by all rooms.
+func (c *CherryRooms) SetTLSKey(filePath string) {
+	c.tlsKey = filePath
+}
+
+// SetRoomTLSCertificate sets a certificate file path only for the indicated room.
+func (c *CherryRooms) SetRoomTLSCertificate(roomName, filePath string) {
+	c.room(roomName).misc.tlsCertificate = filePath
+}
+
+// SetRoomTLSKey sets a private key file path only for the indicated room.
+func (c *CherryRooms) SetRoomTLSKey(roomName, filePath string) {
+	c.room(roomName).misc.tlsKey = filePath
+}
+
+// GetTLSCertificate returns the certificate file path used by a room (the room's own or the cherry.root's one).
+func (c *CherryRooms) GetTLSCertificate(roomName string) string {
+	if c.IsSharingPort(roomName) {
+		return c.tlsCertificate
+	}
+	c.Lock(roomName)
+	filePath := c.room(roomName).misc.tlsCertificate
+	c.Unlock(roomName)
+	if len(filePath) == 0 {
+		filePath = c.tlsCertificate
+	}
+	return filePath
+}
+
+// GetTLSKey returns the private key file path used by a room (the room's own or the cherry.root's one).
+func (c *CherryRooms) GetTLSKey(roomName string) string {
+	if c.IsSharingPort(roomName) {
+		return c.tlsKey
+	}
+	c.Lock(roomName)
+	filePath := c.room(roomName).misc.tlsKey
+	c.Unlock(roomName)
+	if len(filePath) == 0 {
+		filePath = c.tlsKey
+	}
+	return filePath
+}
+
+// IsUsingTLS verifies if the room must be served over TLS.
+func (c *CherryRooms) IsUsingTLS(roomName string) bool {
+	return len(c.GetTLSCertificate(roomName)) > 0 && len(c.GetTLSKey(roomName)) > 0
+}
+
+// SetSharedPort sets the port which serves many rooms at once (path-based routing).
+func (c *CherryRooms) SetSharedPort(port int16) {
+	c.sharedPort = port
+}
+
+// GetSharedPort returns the port which serves many rooms at once ("" when there is no shared port).
+func (c *CherryRooms) GetSharedPort() string {
+	if c.sharedPort == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%d", c.sharedPort)
+}
+
+// SetHistoryDirectory sets the directory where the history of the rooms is kept.
+func (c *CherryRooms) SetHistoryDirectory(directory string) {
+	c.historyDir = directory
+}
+
+// GetHistoryDirectory returns the directory where the history of the rooms is kept ("" when it is not kept).
+func (c *CherryRooms) GetHistoryDirectory() string {
+	return c.historyDir
+}
+
+// SetHistory sets the store that records the public messages of all rooms.
+func (c *CherryRooms) SetHistory(store history.Store) {
+	c.history = store
+}
+
+// GetHistory returns the store that records the public messages of all rooms (nil when there is none).
+func (c *CherryRooms) GetHistory() history.Store {
+	return c.history
+}
+
+// RecordMessage records a delivered public message in the history of the room and makes it searchable.
+func (c *CherryRooms) RecordMessage(roomName string, message Message, formatted string) {
+	entry := history.Entry{Time: message.Time, From: message.From, To: message.To, Action: message.Action,
+		Say: message.Say, Image: message.Image, Sound: message.Sound, Notice: message.Notice, Formatted: formatted}
+	c.index.Add(roomName, entry)
+	if c.history == nil {
+		return
+	}
+	if err := c.history.Append(roomName, entry); err != nil {
+		fmt.Println("ERROR: unable to record the history of room \"" + roomName + "\" [more details: " + err.Error() + "].")
+	}
+}
+
+// IndexHistory makes the messages from the history of a room searchable. A room indexed before (e.g. reopened
+// by a reload) is not indexed again.
+func (c *CherryRooms) IndexHistory(roomName string) error {
+	if c.history == nil || c.index.HasRoom(roomName) {
+		return nil
+	}
+	entries, err := c.history.Range(roomName, time.Time{}, time.Time{})
+	for _, entry := range entries {
+		c.index.Add(roomName, entry)
+	}
+	return err
+}
+
+// SearchMessages searches the public messages of the rooms.
+func (c *CherryRooms) SearchMessages(query search.Query) []search.Result {
+	return c.index.Search(query)
+}
+
+// SetHistoryReplay sets how many messages from the history are replayed to a user that has just entered the room.
+func (c *CherryRooms) SetHistoryReplay(roomName string, total int) {
+	c.room(roomName).misc.historyReplay = total
+}
+
+// GetHistoryReplay returns how many messages from the history are replayed to a user that has just entered the room.
+func (c *CherryRooms) GetHistoryReplay(roomName string) int {
+	c.Lock(roomName)
+	total := c.room(roomName).misc.historyReplay
+	c.Unlock(roomName)
+	return total
+}
+
+// SetTranscriptToken sets the token that must be presented in order to download the room's transcripts.
+func (c *CherryRooms) SetTranscriptToken(roomName, token string) {
+	c.room(roomName).misc.transcriptToken = token
+}
+
+// IsServingTranscripts verifies if the room's transcripts can be downloaded.
+func (c *CherryRooms) IsServingTranscripts(roomName string) bool {
+	c.Lock(roomName)
+	serving := len(c.room(roomName).misc.transcriptToken) > 0
+	c.Unlock(roomName)
+	return serving && c.history != nil
+}
+
+// IsValidTranscriptToken verifies if a token allows downloading the room's transcripts.
+func (c *CherryRooms) IsValidTranscriptToken(roomName, token string) bool {
+	c.Lock(roomName)
+	expected := c.room(roomName).misc.transcriptToken
+	c.Unlock(roomName)
+	return len(expected) > 0 && subtle.ConstantTimeCompare([]byte(token), []byte(expected)) == 1
+}
+
+// HasSharedPort verifies if the server has a port serving many rooms at once.
+func (c *CherryRooms) HasSharedPort() bool {
+	return c.sharedPort != 0
+}
+
+// IsSharingPort verifies if the room is served through the shared port.
+func (c *CherryRooms) IsSharingPort(roomName string) bool {
+	if c.sharedPort == 0 || !c.HasRoom(roomName) {
+		return false
+	}
+	c.Lock(roomName)
+	sharing := (c.room(roomName).misc.listenPort == c.sharedPort)
+	c.Unlock(roomName)
+	return sharing
+}
+
+// GetRoomPath returns the path prefix that identifies a room served through the shared port ("" otherwise).
+func (c *CherryRooms) GetRoomPath(roomName string) string {
+	if !c.IsSharingPort(roomName) {
+		return ""
+	}
+	return "/r/" + roomName
+}
@@ -12,7 +12,12 @@ package parser
 import (
 	"fmt"
 	"io/ioutil"
+	"net/url"
+	"os"
+	"pkg/bots"
 	"pkg/config"
+	"pkg/html"
+	"pkg/webhooks"
 	"strconv"
 	"strings"
 )
@@ -175,7 +180,7 @@ func GetNextSetFromData(data string, currLine int, tok string) ([]string, int, s
 			return make([]string, 0), currLine, ""
 		}
 	}
-	set := strings.Split(line, tok)
+	set := strings.SplitN(line, tok, 2)
 	if len(set) == 2 {
 		set[0] = StripBlanks(set[0])
 		set[1] = StripBlanks(set[1])
@@ -229,13 +234,44 @@ func ParseCherryFile(filepath string) (*config.CherryRooms, *CherryFileError) {
 			cherryRooms.SetServername(set[1][1 : len(set[1])-1])
 			break
 
+		case "tls-certificate":
+			if !verifyString(set[1]) {
+				return nil, NewCherryFileError(filepath, line, fmt.Sprintf("invalid string."))
+			}
+			cherryRooms.SetTLSCertificate(set[1][1 : len(set[1])-1])
+			break
+
+		case "shared-port":
+			var port int64
+			var convErr error
+			port, convErr = strconv.ParseInt(set[1], 10, 16)
+			if convErr != nil || port <= 0 {
+				return nil, NewCherryFileError(filepath, line, fmt.Sprintf("invalid port value \"%s\".", set[1]))
+			}
+			cherryRooms.SetSharedPort(int16(port))
+			break
+
+		case "history-directory":
+			if !verifyString(set[1]) {
+				return nil, NewCherryFileError(filepath, line, fmt.Sprintf("invalid string."))
+			}
+			cherryRooms.SetHistoryDirectory(set[1][1 : len(set[1])-1])
+			break
+
+		case "tls-key":
+			if !verifyString(set[1]) {
+				return nil, NewCherryFileError(filepath, line, fmt.Sprintf("invalid string."))
+			}
+			cherryRooms.SetTLSKey(set[1][1 : len(set[1])-1])
+			break
+
 		default:
 			return nil, NewCherryFileError(filepath, line, fmt.Sprintf("unknown config set \"%s\".", set[0]))
 		}
 		set, line, data = GetNextSetFromData(data, line, "=")
 	}
 	if cherryRooms.GetServername() == "localhost" {
-		fmt.Println("WARN: cherry.root.servername is equals to \"localhost\". Things will not work outside this node.")
+		fmt.Fprintln(os.Stderr, "WARN: cherry.root.servername is equals to \"localhost\". Things will not work outside this node.")
 	}
 	data, _, line, err = GetDataFromSection("cherry.rooms", string(cherryFileData), 1, filepath)
 	if err != nil {
@@ -276,13 +312,67 @@ func ParseCherryFile(filepath string) (*config.CherryRooms, *CherryFileError) {
 
 		_ = GetRoomImages(set[0], cherryRooms, string(cherryFileData), filepath)
 
-		//_ = GetRoomSounds(set[0], cherryRooms, string(cherryFileData), filepath)
+		_ = GetRoomSounds(set[0], cherryRooms, string(cherryFileData), filepath)
 
 		errRoomConfig = GetRoomMisc(set[0], cherryRooms, string(cherryFileData), filepath)
 		if errRoomConfig != nil {
 			return nil, errRoomConfig
 		}
 
+		errRoomConfig = GetRoomBots(set[0], cherryRooms, string(cherryFileData), filepath)
+		if errRoomConfig != nil {
+			return nil, errRoomConfig
+		}
+
+		errRoomConfig = GetRoomWebhooks(set[0], cherryRooms, string(cherryFileData), filepath)
+		if errRoomConfig != nil {
+			return nil, errRoomConfig
+		}
+
+		errRoomConfig = GetRoomHooks(set[0], cherryRooms, string(cherryFileData), filepath)
+		if errRoomConfig != nil {
+			return nil, errRoomConfig
+		}
+
+		errRoomConfig = GetRoomModerators(set[0], cherryRooms, string(cherryFileData), filepath)
+		if errRoomConfig != nil {
+			return nil, errRoomConfig
+		}
+
+		for _, action := range []string{cherryRooms.GetKickAction(set[0]), cherryRooms.GetMuteAction(set[0]), cherryRooms.GetBanAction(set[0])} {
+			if len(action) > 0 && !cherryRooms.HasAction(set[0], action) {
+				return nil, NewCherryFileError(filepath, -1, fmt.Sprintf("room \"%s\" has the undeclared action \"%s\" as a moderation action.", set[0], action))
+			}
+		}
+
+		if cherryRooms.GetHookRate(set[0]) == 0 || cherryRooms.GetHookBurst(set[0]) == 0 {
+			return nil, NewCherryFileError(filepath, -1, fmt.Sprintf("room \"%s\" has a hook-rate or hook-burst equals to zero.", set[0]))
+		}
+
+		if cherryRooms.GetJoinFailureRate(set[0]) == 0 || cherryRooms.GetJoinFailureBurst(set[0]) == 0 {
+			return nil, NewCherryFileError(filepath, -1, fmt.Sprintf("room \"%s\" has a join-failure-rate or join-failure-burst equals to zero.", set[0]))
+		}
+
+		if cherryRooms.IsUsingWaitingLine(set[0]) && !cherryRooms.HasTemplate(set[0], "waiting-line") {
+			return nil, NewCherryFileError(filepath, -1, fmt.Sprintf("room \"%s\" has a waiting line but no waiting-line template.", set[0]))
+		}
+
+		if cherryRooms.IsUsingWaitingLine(set[0]) && cherryRooms.GetWaitingLineTimeout(set[0]) == 0 {
+			return nil, NewCherryFileError(filepath, -1, fmt.Sprintf("room \"%s\" has a waiting-line-timeout equals to zero.", set[0]))
+		}
+
+		if cherryRooms.IsUsingFloodingPolice(set[0]) && (cherryRooms.GetFloodRate(set[0]) == 0 || cherryRooms.GetFloodBurst(set[0]) == 0) {
+			return nil, NewCherryFileError(filepath, -1, fmt.Sprintf("room \"%s\" has a flooding police that does not allow any message.", set[0]))
+		}
+
+		if cherryRooms.GetOutboundQueueSize(set[0]) == 0 {
+			return nil, NewCherryFileError(filepath, -1, fmt.Sprintf("room \"%s\" has an outbound-queue-size equals to zero.", set[0]))
+		}
+
+		if (len(cherryRooms.GetTLSCertificate(set[0])) == 0) != (len(cherryRooms.GetTLSKey(set[0])) == 0) {
+			return nil, NewCherryFileError(filepath, -1, fmt.Sprintf("room \"%s\" must have both tls-certificate and tls-key defined.", set[0]))
+		}
+
 		//  INFO(Santiago): Let's transfer the next room from file to the memory.
 		set, line, data = GetNextSetFromData(data, line, ":")
 	}
@@ -317,7 +407,12 @@ func GetRoomTemplates(roomName string, cherryRooms *config.CherryRooms, configDa
 		if templateDataErr != nil {
 			return NewCherryFileError(filepath, line, "unable to access room template file [more details: "+templateDataErr.Error()+"].")
 		}
+		template, compileErr := html.CompileTemplate(string(templateData))
+		if compileErr != nil {
+			return NewCherryFileError(filepath, line, "invalid room template \""+set[0]+"\" [more details: "+compileErr.Error()+"].")
+		}
 		cherryRooms.AddTemplate(roomName, set[0], string(templateData))
+		cherryRooms.AddCompiledTemplate(roomName, string(templateData), template)
 		set, line, data = GetNextSetFromData(data, line, "=")
 	}
 	return nil
@@ -339,12 +434,121 @@ func GetRoomImages(roomName string, cherryRooms *config.CherryRooms, configData,
 		roomName, cherryRooms, configData, filepath)
 }
 
-//func GetRoomSounds(roomName string, cherryRooms *config.CherryRooms, configData, filepath string) *CherryFileError {
-//    return getIndirectConfig("cherry." + roomName + ".sounds",
-//                               "cherry." + roomName + ".sounds.url",
-//                               room_sound_main_verifier, room_sound_sub_verifier, room_sound_setter,
-//                               roomName, cherryRooms, configData, filepath)
-//}
+// GetRoomSounds parses "cherry.[roomName].sounds" and "cherry.[roomName].sounds.url".
+func GetRoomSounds(roomName string, cherryRooms *config.CherryRooms, configData, filepath string) *CherryFileError {
+	return getIndirectConfig("cherry."+roomName+".sounds",
+		"cherry."+roomName+".sounds.url",
+		roomSoundMainVerifier, roomSoundSubVerifier, roomSoundSetter,
+		roomName, cherryRooms, configData, filepath)
+}
+
+// GetRoomBots parses "cherry.[roomName].bots" section. Each bot is declared as <nickname> = "<kind>[:<argument>]".
+func GetRoomBots(roomName string, cherryRooms *config.CherryRooms, configData, filepath string) *CherryFileError {
+	var data string
+	var line int
+	var err *CherryFileError
+	data, _, line, err = GetDataFromSection("cherry."+roomName+".bots", configData, 1, filepath)
+	if err != nil {
+		//  INFO(Santiago): The bots are optional.
+		return nil
+	}
+	var set []string
+	set, line, data = GetNextSetFromData(data, line, "=")
+	for len(set) == 2 {
+		if cherryRooms.HasBot(roomName, set[0]) {
+			return NewCherryFileError(filepath, line, "room bot \""+set[0]+"\" redeclared.")
+		}
+		if set[0] == cherryRooms.GetAllUsersAlias(roomName) {
+			return NewCherryFileError(filepath, line, "room bot \""+set[0]+"\" has the all-users-alias as nickname.")
+		}
+		if !verifyString(set[1]) {
+			return NewCherryFileError(filepath, line, "room bot must be set with a valid string.")
+		}
+		kind := strings.SplitN(set[1][1:len(set[1])-1], ":", 2)
+		var argument string
+		if len(kind) == 2 {
+			argument = kind[1]
+		}
+		bot, botErr := bots.New(kind[0], argument)
+		if botErr != nil {
+			return NewCherryFileError(filepath, line, "unable to create room bot \""+set[0]+"\" [more details: "+botErr.Error()+"].")
+		}
+		bots.Attach(cherryRooms, roomName, set[0], bot)
+		set, line, data = GetNextSetFromData(data, line, "=")
+	}
+	return nil
+}
+
+// GetRoomWebhooks parses "cherry.[roomName].webhooks" and "cherry.[roomName].webhooks.events".
+func GetRoomWebhooks(roomName string, cherryRooms *config.CherryRooms, configData, filepath string) *CherryFileError {
+	if _, _, _, err := GetDataFromSection("cherry."+roomName+".webhooks", configData, 1, filepath); err != nil {
+		//  INFO(Santiago): The webhooks are optional.
+		return nil
+	}
+	var hooks []webhooks.Hook
+	err := getIndirectConfig("cherry."+roomName+".webhooks",
+		"cherry."+roomName+".webhooks.events",
+		roomWebhookMainVerifier, roomWebhookSubVerifier,
+		func(cherryRooms *config.CherryRooms, roomName string, mSet, sSet []string) {
+			events, _ := webhooks.ParseEvents(sSet[1][1 : len(sSet[1])-1])
+			hooks = append(hooks, webhooks.Hook{URL: mSet[1][1 : len(mSet[1])-1], Events: events})
+		},
+		roomName, cherryRooms, configData, filepath)
+	if err != nil {
+		return err
+	}
+	if len(hooks) > 0 {
+		cherryRooms.SetWebhooks(roomName, webhooks.NewDispatcher(hooks))
+	}
+	return nil
+}
+
+// GetRoomHooks parses "cherry.[roomName].hooks" and "cherry.[roomName].hooks.senders". Each incoming hook
+// is declared as <name> = "<token>" and its sender as <name> = "<nickname>:<action>".
+func GetRoomHooks(roomName string, cherryRooms *config.CherryRooms, configData, filepath string) *CherryFileError {
+	if _, _, _, err := GetDataFromSection("cherry."+roomName+".hooks", configData, 1, filepath); err != nil {
+		//  INFO(Santiago): The incoming hooks are optional.
+		return nil
+	}
+	return getIndirectConfig("cherry."+roomName+".hooks",
+		"cherry."+roomName+".hooks.senders",
+		roomHookMainVerifier, roomHookSubVerifier, roomHookSetter,
+		roomName, cherryRooms, configData, filepath)
+}
+
+// GetRoomModerators parses "cherry.[roomName].moderators" section. Each moderator is declared as
+// <nickname> = "pbkdf2-sha256:<iterations>:<salt>:<hex>".
+func GetRoomModerators(roomName string, cherryRooms *config.CherryRooms, configData, filepath string) *CherryFileError {
+	var data string
+	var line int
+	var err *CherryFileError
+	data, _, line, err = GetDataFromSection("cherry."+roomName+".moderators", configData, 1, filepath)
+	if err != nil {
+		//  INFO(Santiago): The moderators are optional.
+		return nil
+	}
+	var set []string
+	set, line, data = GetNextSetFromData(data, line, "=")
+	for len(set) == 2 {
+		if cherryRooms.HasModerator(roomName, set[0]) {
+			return NewCherryFileError(filepath, line, "room moderator \""+set[0]+"\" redeclared.")
+		}
+		if set[0] == cherryRooms.GetAllUsersAlias(roomName) || cherryRooms.HasBot(roomName, set[0]) ||
+			cherryRooms.IsIncomingHookNickname(roomName, set[0]) {
+			return NewCherryFileError(filepath, line, "room moderator \""+set[0]+"\" uses a nickname that is already taken.")
+		}
+		if !verifyString(set[1]) || len(set[1]) == 2 {
+			return NewCherryFileError(filepath, line, "room moderator must be set with a valid password.")
+		}
+		//  INFO(Santiago): The moderators' passwords are never written in plain text.
+		if hashErr := config.CheckPasswordHash(set[1][1 : len(set[1])-1]); hashErr != nil {
+			return NewCherryFileError(filepath, line, "room moderator \""+set[0]+"\" has an invalid password hash [more details: "+hashErr.Error()+"].")
+		}
+		cherryRooms.AddModerator(roomName, set[0], set[1][1:len(set[1])-1])
+		set, line, data = GetNextSetFromData(data, line, "=")
+	}
+	return nil
+}
 
 // GetRoomMisc parses "cherry.[roomName].misc" section.
 func GetRoomMisc(roomName string, cherryRooms *config.CherryRooms, configData, filepath string) *CherryFileError {
@@ -366,12 +570,48 @@ func GetRoomMisc(roomName string, cherryRooms *config.CherryRooms, configData, f
 	verifier["private-message-marker"] = verifyString
 	verifier["max-users"] = verifyNumber
 	verifier["allow-brief"] = verifyBool
-	//verifier["flooding-police"]               = verifyBool
-	//verifier["max-flood-allowed-before-kick"] = verifyNumber
+	verifier["flooding-police"] = verifyBool
+	verifier["max-flood-allowed-before-kick"] = verifyNumber
+	verifier["flood-rate"] = verifyNumber
+	verifier["flood-burst"] = verifyNumber
+	verifier["flood-warnings-before-mute"] = verifyNumber
+	verifier["flood-mute-time"] = verifyNumber
+	verifier["flood-forgive-time"] = verifyNumber
+	verifier["flood-warning-message"] = verifyString
+	verifier["flood-mute-message"] = verifyString
+	verifier["flood-kick-message"] = verifyString
+	verifier["slow-client-policy"] = verifySlowClientPolicy
+	verifier["outbound-queue-size"] = verifyNumber
+	verifier["write-timeout"] = verifyNumber
+	verifier["allowed-markup"] = verifyAllowedMarkup
+	verifier["history-replay"] = verifyNumber
+	verifier["transcript-token"] = verifyString
 	verifier["all-users-alias"] = verifyString
 	verifier["ignore-action"] = verifyString
 	verifier["deignore-action"] = verifyString
 	verifier["public-directory"] = verifyString
+	verifier["tls-certificate"] = verifyString
+	verifier["tls-key"] = verifyString
+	verifier["session-lifetime"] = verifyNumber
+	verifier["session-cookie"] = verifyBool
+	verifier["waiting-line"] = verifyBool
+	verifier["waiting-line-timeout"] = verifyNumber
+	verifier["hook-rate"] = verifyNumber
+	verifier["hook-burst"] = verifyNumber
+	verifier["kick-action"] = verifyString
+	verifier["mute-action"] = verifyString
+	verifier["ban-action"] = verifyString
+	verifier["kick-message"] = verifyString
+	verifier["mute-message"] = verifyString
+	verifier["ban-message"] = verifyString
+	verifier["mute-time"] = verifyNumber
+	verifier["ban-time"] = verifyNumber
+	verifier["moderator-marker"] = verifyString
+	verifier["room-password"] = verifyRoomPassword
+	verifier["invite-only"] = verifyBool
+	verifier["invite-time"] = verifyNumber
+	verifier["join-failure-rate"] = verifyNumber
+	verifier["join-failure-burst"] = verifyNumber
 
 	var setter map[string]func(*config.CherryRooms, string, string)
 	setter = make(map[string]func(*config.CherryRooms, string, string))
@@ -383,12 +623,48 @@ func GetRoomMisc(roomName string, cherryRooms *config.CherryRooms, configData, f
 	setter["private-message-marker"] = setPrivateMessageMarker
 	setter["max-users"] = setMaxUsers
 	setter["allow-brief"] = setAllowBrief
-	//setter["flooding-police"]               = set_flooding_police
-	//setter["max-flood-allowed-before-kick"] = set_max_flood_allowed_before_kick
+	setter["flooding-police"] = setFloodingPolice
+	setter["max-flood-allowed-before-kick"] = setMaxFloodAllowedBeforeKick
+	setter["flood-rate"] = setFloodRate
+	setter["flood-burst"] = setFloodBurst
+	setter["flood-warnings-before-mute"] = setFloodWarningsBeforeMute
+	setter["flood-mute-time"] = setFloodMuteTime
+	setter["flood-forgive-time"] = setFloodForgiveTime
+	setter["flood-warning-message"] = setFloodWarningMessage
+	setter["flood-mute-message"] = setFloodMuteMessage
+	setter["flood-kick-message"] = setFloodKickMessage
+	setter["slow-client-policy"] = setSlowClientPolicy
+	setter["outbound-queue-size"] = setOutboundQueueSize
+	setter["write-timeout"] = setWriteTimeout
+	setter["allowed-markup"] = setAllowedMarkup
+	setter["history-replay"] = setHistoryReplay
+	setter["transcript-token"] = setTranscriptToken
 	setter["all-users-alias"] = setAllUsersAlias
 	setter["ignore-action"] = setIgnoreAction
 	setter["deignore-action"] = setDeIgnoreAction
 	setter["public-directory"] = setPublicDirectory
+	setter["tls-certificate"] = setRoomTLSCertificate
+	setter["tls-key"] = setRoomTLSKey
+	setter["session-lifetime"] = setSessionLifetime
+	setter["session-cookie"] = setSessionCookie
+	setter["waiting-line"] = setWaitingLine
+	setter["waiting-line-timeout"] = setWaitingLineTimeout
+	setter["hook-rate"] = setHookRate
+	setter["hook-burst"] = setHookBurst
+	setter["kick-action"] = setKickAction
+	setter["mute-action"] = setMuteAction
+	setter["ban-action"] = setBanAction
+	setter["kick-message"] = setKickMessage
+	setter["mute-message"] = setMuteMessage
+	setter["ban-message"] = setBanMessage
+	setter["mute-time"] = setMuteTime
+	setter["ban-time"] = setBanTime
+	setter["moderator-marker"] = setModeratorMarker
+	setter["room-password"] = setRoomPassword
+	setter["invite-only"] = setInviteOnly
+	setter["invite-time"] = setInviteTime
+	setter["join-failure-rate"] = setJoinFailureRate
+	setter["join-failure-burst"] = setJoinFailureBurst
 
 	var alreadySet map[string]bool
 	alreadySet = make(map[string]bool)
@@ -399,12 +675,48 @@ func GetRoomMisc(roomName string, cherryRooms *config.CherryRooms, configData, f
 	alreadySet["greeting-message"] = false
 	alreadySet["private-message-marker"] = false
 	alreadySet["max-users"] = false
-	//alreadySet["flooding-police"]               = false
-	//alreadySet["max-flood-allowed-before-kick"] = false
+	alreadySet["flooding-police"] = false
+	alreadySet["max-flood-allowed-before-kick"] = false
+	alreadySet["flood-rate"] = false
+	alreadySet["flood-burst"] = false
+	alreadySet["flood-warnings-before-mute"] = false
+	alreadySet["flood-mute-time"] = false
+	alreadySet["flood-forgive-time"] = false
+	alreadySet["flood-warning-message"] = false
+	alreadySet["flood-mute-message"] = false
+	alreadySet["flood-kick-message"] = false
+	alreadySet["slow-client-policy"] = false
+	alreadySet["outbound-queue-size"] = false
+	alreadySet["write-timeout"] = false
+	alreadySet["allowed-markup"] = false
+	alreadySet["history-replay"] = false
+	alreadySet["transcript-token"] = false
 	alreadySet["all-users-alias"] = false
 	alreadySet["ignore-action"] = false
 	alreadySet["deignore-action"] = false
 	alreadySet["public-directory"] = false
+	alreadySet["tls-certificate"] = false
+	alreadySet["tls-key"] = false
+	alreadySet["session-lifetime"] = false
+	alreadySet["session-cookie"] = false
+	alreadySet["waiting-line"] = false
+	alreadySet["waiting-line-timeout"] = false
+	alreadySet["hook-rate"] = false
+	alreadySet["hook-burst"] = false
+	alreadySet["kick-action"] = false
+	alreadySet["mute-action"] = false
+	alreadySet["ban-action"] = false
+	alreadySet["kick-message"] = false
+	alreadySet["mute-message"] = false
+	alreadySet["ban-message"] = false
+	alreadySet["mute-time"] = false
+	alreadySet["ban-time"] = false
+	alreadySet["moderator-marker"] = false
+	alreadySet["room-password"] = false
+	alreadySet["invite-only"] = false
+	alreadySet["invite-time"] = false
+	alreadySet["join-failure-rate"] = false
+	alreadySet["join-failure-burst"] = false
 
 	var mSet []string
 	mSet, mLine, mData = GetNextSetFromData(mData, mLine, "=")
@@ -475,21 +787,231 @@ func setPublicDirectory(cherryRooms *config.CherryRooms, roomName, value string)
 	cherryRooms.SetPublicDirectory(roomName, value[1:len(value)-1])
 }
 
-//func set_flooding_police(cherryRooms *config.CherryRooms, roomName, value string) {
-//    var impose bool
-//    impose = (value == "yes")
-//    cherryRooms.SetFloodingPolice(roomName, impose)
-//}
+func setRoomTLSCertificate(cherryRooms *config.CherryRooms, roomName, value string) {
+	cherryRooms.SetRoomTLSCertificate(roomName, value[1:len(value)-1])
+}
+
+func setSessionLifetime(cherryRooms *config.CherryRooms, roomName, value string) {
+	var intValue int64
+	intValue, _ = strconv.ParseInt(value, 10, 64)
+	cherryRooms.SetSessionLifetime(roomName, int(intValue))
+}
+
+func setSessionCookie(cherryRooms *config.CherryRooms, roomName, value string) {
+	cherryRooms.SetSessionCookie(roomName, (value == "yes" || value == "true"))
+}
+
+func setWaitingLine(cherryRooms *config.CherryRooms, roomName, value string) {
+	cherryRooms.SetWaitingLine(roomName, (value == "yes" || value == "true"))
+}
+
+func setWaitingLineTimeout(cherryRooms *config.CherryRooms, roomName, value string) {
+	var intValue int64
+	intValue, _ = strconv.ParseInt(value, 10, 64)
+	cherryRooms.SetWaitingLineTimeout(roomName, int(intValue))
+}
+
+func setRoomTLSKey(cherryRooms *config.CherryRooms, roomName, value string) {
+	cherryRooms.SetRoomTLSKey(roomName, value[1:len(value)-1])
+}
+
+func setFloodingPolice(cherryRooms *config.CherryRooms, roomName, value string) {
+	var impose bool
+	impose = (value == "yes" || value == "true")
+	cherryRooms.SetFloodingPolice(roomName, impose)
+}
 
 func setAllUsersAlias(cherryRooms *config.CherryRooms, roomName, value string) {
 	cherryRooms.SetAllUsersAlias(roomName, value[1:len(value)-1])
 }
 
-//func set_max_flood_allowed_before_kick(cherryRooms *config.CherryRooms, roomName, value string) {
-//    var intValue int64
-//    intValue, _ = strconv.ParseInt(value, 10, 64)
-//    cherryRooms.SetMaxFloodAllowedBeforeKick(roomName, int(intValue))
-//}
+func setMaxFloodAllowedBeforeKick(cherryRooms *config.CherryRooms, roomName, value string) {
+	var intValue int64
+	intValue, _ = strconv.ParseInt(value, 10, 64)
+	cherryRooms.SetMaxFloodAllowedBeforeKick(roomName, int(intValue))
+}
+
+func setFloodRate(cherryRooms *config.CherryRooms, roomName, value string) {
+	var intValue int64
+	intValue, _ = strconv.ParseInt(value, 10, 64)
+	cherryRooms.SetFloodRate(roomName, int(intValue))
+}
+
+func setFloodBurst(cherryRooms *config.CherryRooms, roomName, value string) {
+	var intValue int64
+	intValue, _ = strconv.ParseInt(value, 10, 64)
+	cherryRooms.SetFloodBurst(roomName, int(intValue))
+}
+
+func setHookRate(cherryRooms *config.CherryRooms, roomName, value string) {
+	var intValue int64
+	intValue, _ = strconv.ParseInt(value, 10, 64)
+	cherryRooms.SetHookRate(roomName, int(intValue))
+}
+
+func setHookBurst(cherryRooms *config.CherryRooms, roomName, value string) {
+	var intValue int64
+	intValue, _ = strconv.ParseInt(value, 10, 64)
+	cherryRooms.SetHookBurst(roomName, int(intValue))
+}
+
+func setKickAction(cherryRooms *config.CherryRooms, roomName, action string) {
+	cherryRooms.SetKickAction(roomName, action[1:len(action)-1])
+}
+
+func setMuteAction(cherryRooms *config.CherryRooms, roomName, action string) {
+	cherryRooms.SetMuteAction(roomName, action[1:len(action)-1])
+}
+
+func setBanAction(cherryRooms *config.CherryRooms, roomName, action string) {
+	cherryRooms.SetBanAction(roomName, action[1:len(action)-1])
+}
+
+func setKickMessage(cherryRooms *config.CherryRooms, roomName, message string) {
+	cherryRooms.SetKickMessage(roomName, message[1:len(message)-1])
+}
+
+func setMuteMessage(cherryRooms *config.CherryRooms, roomName, message string) {
+	cherryRooms.SetMuteMessage(roomName, message[1:len(message)-1])
+}
+
+func setBanMessage(cherryRooms *config.CherryRooms, roomName, message string) {
+	cherryRooms.SetBanMessage(roomName, message[1:len(message)-1])
+}
+
+func setMuteTime(cherryRooms *config.CherryRooms, roomName, value string) {
+	var intValue int64
+	intValue, _ = strconv.ParseInt(value, 10, 64)
+	cherryRooms.SetMuteTime(roomName, int(intValue))
+}
+
+func setBanTime(cherryRooms *config.CherryRooms, roomName, value string) {
+	var intValue int64
+	intValue, _ = strconv.ParseInt(value, 10, 64)
+	cherryRooms.SetBanTime(roomName, int(intValue))
+}
+
+func setModeratorMarker(cherryRooms *config.CherryRooms, roomName, marker string) {
+	cherryRooms.SetModeratorMarker(roomName, marker[1:len(marker)-1])
+}
+
+func setRoomPassword(cherryRooms *config.CherryRooms, roomName, value string) {
+	cherryRooms.SetRoomPassword(roomName, strings.ToLower(value[1:len(value)-1]))
+}
+
+func setInviteOnly(cherryRooms *config.CherryRooms, roomName, value string) {
+	cherryRooms.SetInviteOnly(roomName, (value == "yes" || value == "true"))
+}
+
+func setInviteTime(cherryRooms *config.CherryRooms, roomName, value string) {
+	var intValue int64
+	intValue, _ = strconv.ParseInt(value, 10, 64)
+	cherryRooms.SetInviteTime(roomName, int(intValue))
+}
+
+func setJoinFailureRate(cherryRooms *config.CherryRooms, roomName, value string) {
+	var intValue int64
+	intValue, _ = strconv.ParseInt(value, 10, 64)
+	cherryRooms.SetJoinFailureRate(roomName, int(intValue))
+}
+
+func setJoinFailureBurst(cherryRooms *config.CherryRooms, roomName, value string) {
+	var intValue int64
+	intValue, _ = strconv.ParseInt(value, 10, 64)
+	cherryRooms.SetJoinFailureBurst(roomName, int(intValue))
+}
+
+func setFloodWarningsBeforeMute(cherryRooms *config.CherryRooms, roomName, value string) {
+	var intValue int64
+	intValue, _ = strconv.ParseInt(value, 10, 64)
+	cherryRooms.SetFloodWarningsBeforeMute(roomName, int(intValue))
+}
+
+func setFloodMuteTime(cherryRooms *config.CherryRooms, roomName, value string) {
+	var intValue int64
+	intValue, _ = strconv.ParseInt(value, 10, 64)
+	cherryRooms.SetFloodMuteTime(roomName, int(intValue))
+}
+
+func setFloodForgiveTime(cherryRooms *config.CherryRooms, roomName, value string) {
+	var intValue int64
+	intValue, _ = strconv.ParseInt(value, 10, 64)
+	cherryRooms.SetFloodForgiveTime(roomName, int(intValue))
+}
+
+func setFloodWarningMessage(cherryRooms *config.CherryRooms, roomName, message string) {
+	cherryRooms.SetFloodWarningMessage(roomName, message[1:len(message)-1])
+}
+
+func setFloodMuteMessage(cherryRooms *config.CherryRooms, roomName, message string) {
+	cherryRooms.SetFloodMuteMessage(roomName, message[1:len(message)-1])
+}
+
+func setFloodKickMessage(cherryRooms *config.CherryRooms, roomName, message string) {
+	cherryRooms.SetFloodKickMessage(roomName, message[1:len(message)-1])
+}
+
+func setSlowClientPolicy(cherryRooms *config.CherryRooms, roomName, value string) {
+	cherryRooms.SetSlowClientPolicy(roomName, value)
+}
+
+func setOutboundQueueSize(cherryRooms *config.CherryRooms, roomName, value string) {
+	var intValue int64
+	intValue, _ = strconv.ParseInt(value, 10, 64)
+	cherryRooms.SetOutboundQueueSize(roomName, int(intValue))
+}
+
+func setWriteTimeout(cherryRooms *config.CherryRooms, roomName, value string) {
+	var intValue int64
+	intValue, _ = strconv.ParseInt(value, 10, 64)
+	cherryRooms.SetWriteTimeout(roomName, int(intValue))
+}
+
+func setHistoryReplay(cherryRooms *config.CherryRooms, roomName, value string) {
+	var intValue int64
+	intValue, _ = strconv.ParseInt(value, 10, 64)
+	cherryRooms.SetHistoryReplay(roomName, int(intValue))
+}
+
+func setTranscriptToken(cherryRooms *config.CherryRooms, roomName, value string) {
+	cherryRooms.SetTranscriptToken(roomName, value[1:len(value)-1])
+}
+
+func setAllowedMarkup(cherryRooms *config.CherryRooms, roomName, value string) {
+	cherryRooms.SetAllowedMarkup(roomName, getMarkupList(value[1:len(value)-1]))
+}
+
+func getMarkupList(buffer string) []string {
+	var tags []string
+	for _, tag := range strings.Split(buffer, ",") {
+		if tag = strings.TrimSpace(tag); len(tag) > 0 {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+func verifyAllowedMarkup(buffer string) bool {
+	if !verifyString(buffer) {
+		return false
+	}
+	for _, tag := range getMarkupList(buffer[1 : len(buffer)-1]) {
+		if !html.IsSafeMarkup(tag) {
+			return false
+		}
+	}
+	return true
+}
+
+// verifyRoomPassword accepts only the hash of the password (see config.HashPassword), the room password is
+// never written in plain text.
+func verifyRoomPassword(buffer string) bool {
+	return verifyString(buffer) && config.CheckPasswordHash(buffer[1:len(buffer)-1]) == nil
+}
+
+func verifySlowClientPolicy(buffer string) bool {
+	return (buffer == "drop" || buffer == "disconnect")
+}
 
 func verifyNumber(buffer string) bool {
 	if len(buffer) == 0 {
@@ -609,16 +1131,22 @@ func roomActionSubVerifier(mSet, sSet []string, mLine, sLine int, roomName, file
 		return NewCherryFileError(filepath, sLine, "room action template must be set with a valid string.")
 	}
 	var templatePath = sSet[1][1 : len(sSet[1])-1]
-	_, err := ioutil.ReadFile(templatePath)
+	templateData, err := ioutil.ReadFile(templatePath)
 	if err != nil {
 		return NewCherryFileError(filepath, sLine, fmt.Sprintf("unable to access file, details: [ %s ]", err.Error()))
 	}
+	if _, err = html.CompileTemplate(string(templateData)); err != nil {
+		return NewCherryFileError(filepath, sLine, fmt.Sprintf("invalid action template, details: [ %s ]", err.Error()))
+	}
 	return nil
 }
 
 func roomActionSetter(cherryRooms *config.CherryRooms, roomName string, mSet, sSet []string) {
 	data, _ := ioutil.ReadFile(sSet[1][1 : len(sSet[1])-1])
 	cherryRooms.AddAction(roomName, mSet[0], mSet[1][1:len(mSet[1])-1], string(data))
+	//  INFO(Santiago): The verifier has already compiled it with success.
+	template, _ := html.CompileTemplate(string(data))
+	cherryRooms.AddCompiledTemplate(roomName, string(data), template)
 }
 
 func roomImageMainVerifier(mSet, sSet []string, mLine, sLine int, roomName, filepath string, cherryRooms *config.CherryRooms) *CherryFileError {
@@ -652,33 +1180,102 @@ func roomImageSetter(cherryRooms *config.CherryRooms, roomName string, mSet, sSe
 	cherryRooms.AddImage(roomName, mSet[0], mSet[1][1:len(mSet[1])-1], "", sSet[1][1:len(sSet[1])-1])
 }
 
-//func room_sound_main_verifier(mSet, sSet []string, mLine, sLine int, roomName, filepath string, cherryRooms *config.CherryRooms) *CherryFileError {
-//    if cherryRooms.HasImage(roomName, mSet[0]) {
-//        return NewCherryFileError(filepath, mLine, "room sound \"" + mSet[0] + "\" redeclared.")
-//    }
-//    if len(mSet[1]) == 0 {
-//        return NewCherryFileError(filepath, mLine, "unlabeled room sound.")
-//    }
-//    if mSet[1][0] != '"' || mSet[1][len(mSet[1])-1] != '"' {
-//        return NewCherryFileError(filepath, mLine, "room sound must be set with a valid string.")
-//    }
-//    return nil
-//}
-
-//func room_sound_sub_verifier(mSet, sSet []string, mLine, sLine int, roomName, filepath string, cherryRooms *config.CherryRooms) *CherryFileError {
-//    if sSet[0] != mSet[0] {
-//        return NewCherryFileError(filepath, sLine, "there is no url for sound \"" + mSet[0] + "\".")
-//    }
-//    if len(sSet[1]) == 0 {
-//        return NewCherryFileError(filepath, sLine, "empty room sound url.")
-//    }
-//    if sSet[1][0] != '"' || sSet[1][len(sSet[1])-1] != '"' {
-//        return NewCherryFileError(filepath, sLine, "room sound url must be set with a valid string.")
-//    }
-//    return nil
-//}
-
-//func room_sound_setter(cherryRooms *config.CherryRooms, roomName string, mSet, sSet []string) {
-//    //  WARN(Santiago): by now we will pass the sound template as empty.
-//    cherryRooms.AddSound(roomName, mSet[0], mSet[1][1:len(mSet[1])-1], "", sSet[1][1:len(sSet[1])-1])
-//}
+func roomWebhookMainVerifier(mSet, sSet []string, mLine, sLine int, roomName, filepath string, cherryRooms *config.CherryRooms) *CherryFileError {
+	if !verifyString(mSet[1]) {
+		return NewCherryFileError(filepath, mLine, "room webhook must be set with a valid string.")
+	}
+	hookURL, err := url.Parse(mSet[1][1 : len(mSet[1])-1])
+	if err != nil || (hookURL.Scheme != "http" && hookURL.Scheme != "https") || len(hookURL.Host) == 0 {
+		return NewCherryFileError(filepath, mLine, "room webhook \""+mSet[0]+"\" must be an http or https URL.")
+	}
+	return nil
+}
+
+func roomWebhookSubVerifier(mSet, sSet []string, mLine, sLine int, roomName, filepath string, cherryRooms *config.CherryRooms) *CherryFileError {
+	if len(sSet) != 2 || sSet[0] != mSet[0] {
+		return NewCherryFileError(filepath, sLine, "there are no events for webhook \""+mSet[0]+"\".")
+	}
+	if !verifyString(sSet[1]) {
+		return NewCherryFileError(filepath, sLine, "room webhook events must be set with a valid string.")
+	}
+	if _, err := webhooks.ParseEvents(sSet[1][1 : len(sSet[1])-1]); err != nil {
+		return NewCherryFileError(filepath, sLine, "invalid events for webhook \""+mSet[0]+"\" [more details: "+err.Error()+"].")
+	}
+	return nil
+}
+
+func roomHookMainVerifier(mSet, sSet []string, mLine, sLine int, roomName, filepath string, cherryRooms *config.CherryRooms) *CherryFileError {
+	if !verifyString(mSet[1]) || len(mSet[1]) == 2 {
+		return NewCherryFileError(filepath, mLine, "room hook \""+mSet[0]+"\" must be set with a valid token.")
+	}
+	token := mSet[1][1 : len(mSet[1])-1]
+	if strings.ContainsAny(token, "/?&# ") {
+		return NewCherryFileError(filepath, mLine, "room hook \""+mSet[0]+"\" has a token that can not be used in a URL.")
+	}
+	if cherryRooms.HasIncomingHook(roomName, token) {
+		return NewCherryFileError(filepath, mLine, "room hook \""+mSet[0]+"\" redeclares a token.")
+	}
+	return nil
+}
+
+func roomHookSubVerifier(mSet, sSet []string, mLine, sLine int, roomName, filepath string, cherryRooms *config.CherryRooms) *CherryFileError {
+	if len(sSet) != 2 || sSet[0] != mSet[0] {
+		return NewCherryFileError(filepath, sLine, "there is no sender for hook \""+mSet[0]+"\".")
+	}
+	if !verifyString(sSet[1]) {
+		return NewCherryFileError(filepath, sLine, "room hook sender must be set with a valid string.")
+	}
+	nickname, action := getHookSender(sSet[1])
+	if len(nickname) == 0 || !cherryRooms.HasAction(roomName, action) {
+		return NewCherryFileError(filepath, sLine, "room hook sender \""+mSet[0]+"\" must be <nickname>:<action> with a declared action.")
+	}
+	if nickname == cherryRooms.GetAllUsersAlias(roomName) || cherryRooms.HasBot(roomName, nickname) {
+		return NewCherryFileError(filepath, sLine, "room hook sender \""+mSet[0]+"\" uses a nickname that is already taken.")
+	}
+	return nil
+}
+
+func roomHookSetter(cherryRooms *config.CherryRooms, roomName string, mSet, sSet []string) {
+	nickname, action := getHookSender(sSet[1])
+	cherryRooms.AddIncomingHook(roomName, mSet[1][1:len(mSet[1])-1], nickname, action)
+}
+
+func getHookSender(value string) (string, string) {
+	sender := value[1 : len(value)-1]
+	colon := strings.LastIndex(sender, ":")
+	if colon == -1 {
+		return sender, ""
+	}
+	return sender[:colon], sender[colon+1:]
+}
+
+func roomSoundMainVerifier(mSet, sSet []string, mLine, sLine int, roomName, filepath string, cherryRooms *config.CherryRooms) *CherryFileError {
+	if cherryRooms.HasSound(roomName, mSet[0]) {
+		return NewCherryFileError(filepath, mLine, "room sound \""+mSet[0]+"\" redeclared.")
+	}
+	if len(mSet[1]) == 0 {
+		return NewCherryFileError(filepath, mLine, "unlabeled room sound.")
+	}
+	if mSet[1][0] != '"' || mSet[1][len(mSet[1])-1] != '"' {
+		return NewCherryFileError(filepath, mLine, "room sound must be set with a valid string.")
+	}
+	return nil
+}
+
+func roomSoundSubVerifier(mSet, sSet []string, mLine, sLine int, roomName, filepath string, cherryRooms *config.CherryRooms) *CherryFileError {
+	if sSet[0] != mSet[0] {
+		return NewCherryFileError(filepath, sLine, "there is no url for sound \""+mSet[0]+"\".")
+	}
+	if len(sSet[1]) == 0 {
+		return NewCherryFileError(filepath, sLine, "empty room sound url.")
+	}
+	if sSet[1][0] != '"' || sSet[1][len(sSet[1])-1] != '"' {
+		return NewCherryFileError(filepath, sLine, "room sound url must be set with a valid string.")
+	}
+	return nil
+}
+
+func roomSoundSetter(cherryRooms *config.CherryRooms, roomName string, mSet, sSet []string) {
+	//  WARN(Santiago): by now we will pass the sound template as empty.
+	cherryRooms.AddSound(roomName, mSet[0], mSet[1][1:len(mSet[1])-1], "", sSet[1][1:len(sSet[1])-1])
+}
@@ -9,8 +9,10 @@ package parser
 
 import (
 	"fmt"
+	"io/ioutil"
 	"os"
 	"pkg/config"
+	"strings"
 	"testing"
 )
 
@@ -61,6 +63,10 @@ func TestGetNextSetFromData(t *testing.T) {
 	if len(set) != 2 || len(data) == 0 || set[0] != "i03" || set[1] != "\"http://www.nowhere.com/images/i03.gif\"" {
 		t.Fail()
 	}
+	set, _, _ = GetNextSetFromData("h01 = \"http://www.nowhere.com/hook?token=42\"\n", 1, "=")
+	if len(set) != 2 || set[0] != "h01" || set[1] != "\"http://www.nowhere.com/hook?token=42\"" {
+		t.Fail()
+	}
 }
 
 func TestRealCherryFileParsing(t *testing.T) {
@@ -119,12 +125,22 @@ func TestRealCherryFileParsing(t *testing.T) {
 	if !cherryRooms.IsAllowingBriefs(rooms[0]) {
 		t.Fail()
 	}
+	if !cherryRooms.HasBot(rooms[0], "echo") || !cherryRooms.HasBot(rooms[0], "ufo-faq") {
+		t.Fail()
+	}
+	if !cherryRooms.IsModerationAction(rooms[0], "a05") || !cherryRooms.IsModerationAction(rooms[0], "a07") ||
+		cherryRooms.IsModerationAction(rooms[0], "a01") {
+		t.Fail()
+	}
 	var expActionLabels map[string]string
 	expActionLabels = make(map[string]string)
 	expActionLabels["a01"] = "talks to"
 	expActionLabels["a02"] = "screams with"
 	expActionLabels["a03"] = "IGNORE"
 	expActionLabels["a04"] = "NOT IGNORE"
+	expActionLabels["a05"] = "KICK"
+	expActionLabels["a06"] = "MUTE"
+	expActionLabels["a07"] = "BAN"
 	for a, l := range expActionLabels {
 		if cherryRooms.GetRoomActionLabel(rooms[0], a) != l {
 			t.Fail()
@@ -173,16 +189,18 @@ func TestRealCherryFileParsing(t *testing.T) {
 		len(message.To) != 0 ||
 		len(message.Action) != 0 ||
 		len(message.Image) != 0 ||
+		len(message.Sound) != 0 ||
 		len(message.Say) != 0 ||
 		len(message.Priv) != 0 {
 		t.Fail()
 	}
-	cherryRooms.EnqueueMessage(rooms[0], "(null)", "(anyone)", "a01", "i01", "boo!", "1")
+	cherryRooms.EnqueueMessage(rooms[0], "(null)", "(anyone)", "a01", "i01", "s01", "boo!", "1")
 	message = cherryRooms.GetNextMessage(rooms[0])
 	if message.From != "(null)" ||
 		message.To != "(anyone)" ||
 		message.Action != "a01" ||
 		message.Image != "i01" ||
+		message.Sound != "s01" ||
 		message.Say != "boo!" ||
 		message.Priv != "1" {
 		t.Fail()
@@ -194,9 +212,35 @@ func TestRealCherryFileParsing(t *testing.T) {
 			len(message.To) != 0 ||
 			len(message.Action) != 0 ||
 			len(message.Image) != 0 ||
+			len(message.Sound) != 0 ||
 			len(message.Say) != 0 ||
 			len(message.Priv) != 0 {
 			t.Fail()
 		}
 	}
 }
+
+func TestTLSPairParsing(t *testing.T) {
+	cwd, _ := os.Getwd()
+	os.Chdir("../../../../sample")
+	defer os.Chdir(cwd)
+	directory := t.TempDir()
+	parse := func(root string) *CherryFileError {
+		cherryFile := directory + "/tls.cherry"
+		data := "cherry.root (\n    servername = \"localhost\"\n" + root + ")\n\n" +
+			"cherry.rooms (\n    aliens-on-earth:1024\n)\n\ncherry.branch conf/aliens_on_earth.cherry\n"
+		if err := ioutil.WriteFile(cherryFile, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+		_, err := ParseCherryFile(cherryFile)
+		return err
+	}
+	for _, root := range []string{"    tls-certificate = \"cert.pem\"\n", "    tls-key = \"key.pem\"\n"} {
+		if err := parse(root); err == nil || !strings.Contains(err.Error(), "must have both tls-certificate and tls-key") {
+			t.Errorf("%q was accepted: %v", root, err)
+		}
+	}
+	if err := parse("    tls-certificate = \"cert.pem\"\n    tls-key = \"key.pem\"\n"); err != nil {
+		t.Errorf("a tls pair was refused: %v", err)
+	}
+}
@@ -0,0 +1,244 @@
+/*
+Package history keeps the public messages of the rooms beyond the server lifetime.
+--
+ *                               Copyright (C) 2015 by Rafael Santiago
+ *
+ * This is a free software. You can redistribute it and/or modify under
+ * the terms of the GNU General Public License version 2.
+ *
+*/
+package history
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Entry is a public message recorded in the history of a room.
+type Entry struct {
+	Time      time.Time `json:"time"`
+	From      string    `json:"from"`
+	To        string    `json:"to,omitempty"`
+	Action    string    `json:"action,omitempty"`
+	Say       string    `json:"say,omitempty"`
+	Image     string    `json:"image,omitempty"`
+	Sound     string    `json:"sound,omitempty"`
+	Notice    bool      `json:"notice,omitempty"`
+	Formatted string    `json:"formatted"`
+}
+
+// Store is the place where the history of the rooms is kept.
+type Store interface {
+	// Append records a new entry in the history of a room.
+	Append(roomName string, entry Entry) error
+	// Last returns the @n most recent entries of a room, the oldest comes first.
+	Last(roomName string, n int) ([]Entry, error)
+	// Range returns the entries of a room recorded from @since until @until (a zero time means no limit).
+	Range(roomName string, since, until time.Time) ([]Entry, error)
+	// Close releases everything held by the store.
+	Close() error
+}
+
+// FileStore is a Store that appends the entries of each room to a file with one JSON entry per line.
+type FileStore struct {
+	mutex     *sync.Mutex
+	directory string
+	rooms     map[string]*roomHistory
+}
+
+// roomHistory is the history file of a room plus its most recent entries, kept in memory in order to replay
+// them without reading the file again.
+type roomHistory struct {
+	mutex  *sync.Mutex
+	file   *os.File
+	recent []Entry
+	size   int
+}
+
+// maxEntrySize is the biggest entry that can be read back from a history file.
+const maxEntrySize = 1024 * 1024
+
+// tailChunkSize is how much of a history file is read at once when looking for its last entries.
+const tailChunkSize = 64 * 1024
+
+// NewFileStore creates a file store that keeps its files inside @directory (created when it does not exist).
+func NewFileStore(directory string) (*FileStore, error) {
+	if err := os.MkdirAll(directory, 0700); err != nil {
+		return nil, err
+	}
+	return &FileStore{new(sync.Mutex), directory, make(map[string]*roomHistory)}, nil
+}
+
+func (f *FileStore) filepath(roomName string) string {
+	return filepath.Join(f.directory, filepath.Base(roomName)+".history")
+}
+
+// room returns the history of a room, the store mutex is only held while looking it up, so the rooms do not
+// wait for each other.
+func (f *FileStore) room(roomName string) *roomHistory {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	room, ok := f.rooms[roomName]
+	if !ok {
+		room = &roomHistory{mutex: new(sync.Mutex)}
+		f.rooms[roomName] = room
+	}
+	return room
+}
+
+// Append records a new entry in the history file of a room.
+func (f *FileStore) Append(roomName string, entry Entry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	room := f.room(roomName)
+	room.mutex.Lock()
+	defer room.mutex.Unlock()
+	if room.file == nil {
+		room.file, err = os.OpenFile(f.filepath(roomName), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+		if err != nil {
+			return err
+		}
+	}
+	if _, err = room.file.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	if room.size > 0 {
+		room.recent = append(room.recent, entry)
+		if len(room.recent) > room.size {
+			room.recent = room.recent[1:]
+		}
+	}
+	return nil
+}
+
+// Last returns the @n most recent entries of a room. They are kept in memory since the first time they were
+// asked, so only then the end of the history file is read.
+func (f *FileStore) Last(roomName string, n int) ([]Entry, error) {
+	if n <= 0 {
+		return nil, nil
+	}
+	room := f.room(roomName)
+	room.mutex.Lock()
+	defer room.mutex.Unlock()
+	if room.size < n {
+		recent, err := readTail(f.filepath(roomName), n)
+		if err != nil {
+			return nil, err
+		}
+		room.recent = recent
+		room.size = n
+	}
+	first := len(room.recent) - n
+	if first < 0 {
+		first = 0
+	}
+	return append([]Entry(nil), room.recent[first:]...), nil
+}
+
+// Range reads the entries recorded from @since until @until from the history file of a room.
+func (f *FileStore) Range(roomName string, since, until time.Time) ([]Entry, error) {
+	var entries []Entry
+	err := f.scan(roomName, func(entry Entry) {
+		if (since.IsZero() || !entry.Time.Before(since)) && (until.IsZero() || !entry.Time.After(until)) {
+			entries = append(entries, entry)
+		}
+	})
+	return entries, err
+}
+
+// scan passes each entry from the history file of a room to @visit, from the oldest to the newest. The file
+// is read through its own descriptor, so the room keeps recording while it is scanned.
+func (f *FileStore) scan(roomName string, visit func(Entry)) error {
+	file, err := os.Open(f.filepath(roomName))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return decodeEntries(file, visit)
+}
+
+// decodeEntries passes each entry read from @r to @visit.
+func decodeEntries(r io.Reader, visit func(Entry)) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxEntrySize)
+	for scanner.Scan() {
+		var entry Entry
+		if json.Unmarshal(scanner.Bytes(), &entry) != nil {
+			//  INFO(Santiago): A truncated line (e.g. the server died while writing it) is not a reason to lose
+			//                  the whole history.
+			continue
+		}
+		visit(entry)
+	}
+	return scanner.Err()
+}
+
+// readTail reads the @n last entries of a history file going backwards from its end, chunk by chunk, until
+// enough entries were found.
+func readTail(filepath string, n int) ([]Entry, error) {
+	file, err := os.Open(filepath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	var entries []Entry
+	for offset, chunkSize := info.Size(), int64(tailChunkSize); offset > 0; chunkSize *= 2 {
+		offset -= chunkSize
+		if offset < 0 {
+			offset = 0
+		}
+		entries = entries[:0]
+		tail := bufio.NewReader(io.NewSectionReader(file, offset, info.Size()-offset))
+		if offset > 0 {
+			//  INFO(Santiago): The first line read is likely only the end of an entry.
+			if _, err = tail.ReadBytes('\n'); err != nil {
+				continue
+			}
+		}
+		err = decodeEntries(tail, func(entry Entry) {
+			entries = append(entries, entry)
+		})
+		if err != nil {
+			return nil, err
+		}
+		if len(entries) >= n {
+			return entries[len(entries)-n:], nil
+		}
+	}
+	return entries, nil
+}
+
+// Close closes all history files.
+func (f *FileStore) Close() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	var err error
+	for roomName, room := range f.rooms {
+		room.mutex.Lock()
+		if room.file != nil {
+			if closeErr := room.file.Close(); closeErr != nil {
+				err = closeErr
+			}
+		}
+		room.mutex.Unlock()
+		delete(f.rooms, roomName)
+	}
+	return err
+}
@@ -20,10 +20,26 @@ import (
 // the content expansion.
 type Preprocessor struct {
 	rooms        *config.CherryRooms
-	dataExpander map[string]func(*Preprocessor, string, string, string) string
+	dataExpander map[string]func(*Preprocessor, *renderContext, string) string
 	dataValue    map[string]string
 }
 
+// renderContext is what a document is being rendered for: a message (when rendering an action template) and
+// the user that the document is about.
+type renderContext struct {
+	message config.Message
+	user    string
+	depth   int
+}
+
+// when returns the time of the rendered message, for anything else it is the current time.
+func (ctx *renderContext) when() time.Time {
+	if ctx.message.Time.IsZero() {
+		return time.Now()
+	}
+	return ctx.message.Time
+}
+
 // NewHTMLPreprocessor creates a new HTML preprocessor.
 func NewHTMLPreprocessor(rooms *config.CherryRooms) *Preprocessor {
 	var preprocessor *Preprocessor
@@ -46,7 +62,7 @@ func (p *Preprocessor) UnsetDataValue(field string) {
 func (p *Preprocessor) Init(rooms *config.CherryRooms) {
 	p.rooms = rooms
 	p.dataValue = make(map[string]string)
-	p.dataExpander = make(map[string]func(*Preprocessor, string, string, string) string)
+	p.dataExpander = make(map[string]func(*Preprocessor, *renderContext, string) string)
 	p.dataExpander["{{.nickname}}"] = nicknameExpander
 	p.dataExpander["{{.session-id}}"] = sessionIDExpander
 	p.dataExpander["{{.color}}"] = colorExpander
@@ -63,10 +79,11 @@ func (p *Preprocessor) Init(rooms *config.CherryRooms) {
 	p.dataExpander["{{.on-ignore-message}}"] = onIgnoreMessageExpander
 	p.dataExpander["{{.on-deignore-message}}"] = onDeIgnoreMessageExpander
 	p.dataExpander["{{.max-users}}"] = maxUsersExpander
+	p.dataExpander["{{.allow-brief}}"] = allowBriefExpander
 	p.dataExpander["{{.all-users-alias}}"] = allUsersAliasExpander
 	p.dataExpander["{{.action-list}}"] = actionListExpander
 	p.dataExpander["{{.image-list}}"] = imageListExpander
-	//    p.dataExpander["{{.sound-list}}"] = sound_list_expander
+	p.dataExpander["{{.sound-list}}"] = soundListExpander
 	p.dataExpander["{{.users-list}}"] = usersListExpander
 	p.dataExpander["{{.top-template}}"] = topTemplateExpander
 	p.dataExpander["{{.body-template}}"] = bodyTemplateExpander
@@ -78,14 +95,17 @@ func (p *Preprocessor) Init(rooms *config.CherryRooms) {
 	p.dataExpander["{{.last-public-messages}}"] = lastPublicMessagesExpander
 	p.dataExpander["{{.servername}}"] = servernameExpander
 	p.dataExpander["{{.listen-port}}"] = listenPortExpander
+	p.dataExpander["{{.scheme}}"] = schemeExpander
+	p.dataExpander["{{.room-path}}"] = roomPathExpander
 	p.dataExpander["{{.room-name}}"] = roomNameExpander
+	p.dataExpander["{{.room-topic}}"] = roomTopicExpander
 	p.dataExpander["{{.users-total}}"] = usersTotalExpander
 	p.dataExpander["{{.message-action-label}}"] = messageActionLabelExpander
 	p.dataExpander["{{.message-whoto}}"] = messageWhotoExpander
-	p.dataExpander["{{.message-user}}"] = nicknameExpander
+	p.dataExpander["{{.message-user}}"] = messageUserExpander
 	p.dataExpander["{{.message-colored-user}}"] = coloredNicknameExpander
 	p.dataExpander["{{.message-says}}"] = messageSaysExpander
-	//    p.dataExpander["{{.message-sound}}"] = message_sound_expander
+	p.dataExpander["{{.message-sound}}"] = messageSoundExpander
 	p.dataExpander["{{.message-image}}"] = messageImageExpander
 	p.dataExpander["{{.message-private-marker}}"] = messagePrivateMarkerExpander
 	p.dataExpander["{{.current-formatted-message}}"] = nil
@@ -96,24 +116,66 @@ func (p *Preprocessor) Init(rooms *config.CherryRooms) {
 	p.dataExpander["{{.find-result-user}}"] = nil
 	p.dataExpander["{{.find-result-room-name}}"] = nil
 	p.dataExpander["{{.find-result-users-total}}"] = nil
+	p.dataExpander["{{.search-results-total}}"] = nil
+	p.dataExpander["{{.search-result-room-name}}"] = nil
+	p.dataExpander["{{.search-result-user}}"] = nil
+	p.dataExpander["{{.search-result-time}}"] = nil
+	p.dataExpander["{{.search-result-says}}"] = nil
+	p.dataExpander["{{.search-result-message}}"] = nil
+	p.dataExpander["{{.waiting-ticket}}"] = nil
+	p.dataExpander["{{.waiting-position}}"] = nil
+	p.dataExpander["{{.invite}}"] = nil
 }
 
 // ExpandData gives preference for statical data if it does not exist the data is processed by expanders.
 func (p *Preprocessor) ExpandData(roomName, data string) string {
-	if p.rooms.HasRoom(roomName) {
-		for varName, expander := range p.dataExpander {
-			localValue, exists := p.dataValue[varName]
-			if exists && len(localValue) > 0 {
-				data = strings.Replace(data, varName, localValue, -1)
-			} else {
-				if expander == nil {
-					continue
-				}
-				data = expander(p, roomName, varName, data)
-			}
+	return p.expand(roomName, &renderContext{}, data)
+}
+
+// ExpandUserData expands data for a given user, the markers about the user (color, ignore list, etc) are
+// expanded with the data of this user.
+func (p *Preprocessor) ExpandUserData(roomName, user, data string) string {
+	return p.expand(roomName, &renderContext{user: user}, data)
+}
+
+// ExpandMessage expands data for a given message, the message markers are expanded only from the passed
+// message and the markers about the user are expanded with the data of its sender.
+func (p *Preprocessor) ExpandMessage(roomName string, message config.Message, data string) string {
+	return p.expand(roomName, &renderContext{message: message, user: message.From}, data)
+}
+
+// ExpandCompiledMessage does the same of ExpandMessage but executing an already compiled (built-in) template.
+func (p *Preprocessor) ExpandCompiledMessage(roomName string, message config.Message, template *Template) string {
+	if !p.rooms.HasRoom(roomName) {
+		return ""
+	}
+	return template.execute(p, &renderContext{message: message, user: message.From}, roomName)
+}
+
+func (p *Preprocessor) expand(roomName string, ctx *renderContext, data string) string {
+	if !p.rooms.HasRoom(roomName) {
+		return data
+	}
+	template, compiled := p.rooms.GetCompiledTemplate(roomName, data).(*Template)
+	if !compiled {
+		//  INFO(Santiago): The templates from the cherry file are compiled when it is loaded, only data that
+		//                  does not come from there is compiled here.
+		var err error
+		if template, err = CompileTemplate(data); err != nil {
+			return data
 		}
 	}
-	return data
+	return template.execute(p, ctx, roomName)
+}
+
+// expandTemplate expands a template referenced by another template.
+func (p *Preprocessor) expandTemplate(ctx *renderContext, roomName, template string) string {
+	if ctx.depth == maxTemplateDepth {
+		return ""
+	}
+	nested := *ctx
+	nested.depth++
+	return p.expand(roomName, &nested, template)
 }
 
 func expandImageRefs(data string) string {
@@ -147,11 +209,16 @@ func GetBadAssErrorData() string {
 	return "<html><h1>404 Bad ass error</h1><h3>No cherry for you!</h3></html>"
 }
 
-func briefUsersTotalExpander(p *Preprocessor, roomName, varName, data string) string {
-	return strings.Replace(data, varName, p.rooms.GetUsersTotal(roomName), -1)
+// GetRoomFullData spits the default document used when a full room has no room-full template.
+func GetRoomFullData() string {
+	return "<html><h1>This room is full</h1><h3>Try again later.</h3></html>"
 }
 
-func briefWhoAreTalkingExpander(p *Preprocessor, roomName, varName, data string) string {
+func briefUsersTotalExpander(p *Preprocessor, ctx *renderContext, roomName string) string {
+	return p.rooms.GetUsersTotal(roomName)
+}
+
+func briefWhoAreTalkingExpander(p *Preprocessor, ctx *renderContext, roomName string) string {
 	var users = p.rooms.GetRoomUsers(roomName)
 	var tableData string
 	tableData = "<table border = 0>"
@@ -159,46 +226,57 @@ func briefWhoAreTalkingExpander(p *Preprocessor, roomName, varName, data string)
 		tableData += "\n\t<tr><td>" + u + "</td></tr>"
 	}
 	tableData += "\n</table>"
-	return strings.Replace(data, varName, tableData, -1)
+	return tableData
 }
 
-func briefLastPublicMessagesExpander(p *Preprocessor, roomName, varName, data string) string {
-	return strings.Replace(data, varName, p.rooms.GetLastPublicMessages(roomName), -1)
+func briefLastPublicMessagesExpander(p *Preprocessor, ctx *renderContext, roomName string) string {
+	return p.rooms.GetLastPublicMessages(roomName)
 }
 
-func messageActionLabelExpander(p *Preprocessor, roomName, varName, data string) string {
-	action := p.rooms.GetNextMessage(roomName).Action
+func messageActionLabelExpander(p *Preprocessor, ctx *renderContext, roomName string) string {
+	action := ctx.message.Action
 	if !p.rooms.HasAction(roomName, action) {
-		return data
+		return ""
 	}
-	return strings.Replace(data, varName, p.rooms.GetRoomActionLabel(roomName, action), -1)
+	return p.rooms.GetRoomActionLabel(roomName, action)
 }
 
-func messageWhotoExpander(p *Preprocessor, roomName, varName, data string) string {
-	return strings.Replace(data, varName, p.rooms.GetNextMessage(roomName).To, -1)
+func messageWhotoExpander(p *Preprocessor, ctx *renderContext, roomName string) string {
+	return Escape(ctx.message.To)
 }
 
-func messageSaysExpander(p *Preprocessor, roomName, varName, data string) string {
-	return strings.Replace(data, varName, expandImageRefs(p.rooms.GetNextMessage(roomName).Say), -1)
+func messageSaysExpander(p *Preprocessor, ctx *renderContext, roomName string) string {
+	message := ctx.message
+	says := message.Say
+	if !message.Notice {
+		says = Sanitize(says, p.rooms.GetAllowedMarkup(roomName))
+	}
+	return expandImageRefs(says)
 }
 
-//func message_sound_expander(p *Preprocessor, roomName, varName, data string) string {
-//    sound := p.rooms.GetNextMessage(roomName).Sound
-//    if len(sound) > 0 {
-//    }
-//    return strings.Replace(data, varName, sound, -1)
-//}
+func messageSoundExpander(p *Preprocessor, ctx *renderContext, roomName string) string {
+	var sound string
+	url := p.rooms.GetSoundURL(roomName, ctx.message.Sound)
+	if len(url) > 0 {
+		sound = "<audio src = \"" + url + "\" autoplay></audio>"
+	}
+	return sound
+}
 
-func messageImageExpander(p *Preprocessor, roomName, varName, data string) string {
-	image := p.rooms.GetNextMessage(roomName).Image
+func messageImageExpander(p *Preprocessor, ctx *renderContext, roomName string) string {
+	image := ctx.message.Image
 	if len(image) > 0 {
-		image = "<br><img src = \"" + image + "\">"
+		image = "<br><img src = \"" + Escape(image) + "\">"
 	}
-	return strings.Replace(data, varName, image, -1)
+	return image
 }
 
-func nicknameExpander(p *Preprocessor, roomName, varName, data string) string {
-	return strings.Replace(data, varName, p.rooms.GetNextMessage(roomName).From, -1)
+func nicknameExpander(p *Preprocessor, ctx *renderContext, roomName string) string {
+	return Escape(ctx.user)
+}
+
+func messageUserExpander(p *Preprocessor, ctx *renderContext, roomName string) string {
+	return Escape(ctx.message.From)
 }
 
 func getHexColor(clKey string) string {
@@ -214,135 +292,163 @@ func getHexColor(clKey string) string {
 	return hexColors[clKey]
 }
 
-func coloredNicknameExpander(p *Preprocessor, roomName, varName, data string) string {
-	color := p.rooms.GetColor(p.rooms.GetNextMessage(roomName).From, roomName)
-	coloredNickname := "<font color = \"" + getHexColor(color) + "\">" + p.rooms.GetNextMessage(roomName).From + "</font>"
-	return strings.Replace(data, varName, coloredNickname, -1)
+func coloredNicknameExpander(p *Preprocessor, ctx *renderContext, roomName string) string {
+	color := p.rooms.GetColor(ctx.message.From, roomName)
+	coloredNickname := "<font color = \"" + getHexColor(color) + "\">" + Escape(ctx.message.From) + "</font>"
+	return coloredNickname
 }
 
-func sessionIDExpander(p *Preprocessor, roomName, varName, data string) string {
-	from := p.rooms.GetNextMessage(roomName).From
-	return strings.Replace(data, varName, p.rooms.GetSessionID(from, roomName), -1)
+func sessionIDExpander(p *Preprocessor, ctx *renderContext, roomName string) string {
+	if p.rooms.IsUsingSessionCookie(roomName) {
+		//  INFO(Santiago): The session ID must not leak to the URLs when it is carried by a cookie.
+		return ""
+	}
+	if len(ctx.message.From) > 0 {
+		//  INFO(Santiago): A message is delivered to everybody, the session ID of its sender must not go with it.
+		return ""
+	}
+	return p.rooms.GetSessionID(ctx.user, roomName)
 }
 
-func colorExpander(p *Preprocessor, roomName, varName, data string) string {
-	from := p.rooms.GetNextMessage(roomName).From
-	return strings.Replace(data, varName, p.rooms.GetColor(from, roomName), -1)
+func colorExpander(p *Preprocessor, ctx *renderContext, roomName string) string {
+	return p.rooms.GetColor(ctx.user, roomName)
 }
 
-func ignoreListExpander(p *Preprocessor, roomName, varName, data string) string {
-	from := p.rooms.GetNextMessage(roomName).From
-	return strings.Replace(data, varName, p.rooms.GetIgnoreList(from, roomName), -1)
+func ignoreListExpander(p *Preprocessor, ctx *renderContext, roomName string) string {
+	return p.rooms.GetIgnoreList(ctx.user, roomName)
 }
 
-func hourExpander(p *Preprocessor, roomName, varName, data string) string {
-	return strings.Replace(data, varName, fmt.Sprintf("%.2d", time.Now().Hour()), -1)
+func hourExpander(p *Preprocessor, ctx *renderContext, roomName string) string {
+	return fmt.Sprintf("%.2d", ctx.when().Hour())
 }
 
-func minuteExpander(p *Preprocessor, roomName, varName, data string) string {
-	return strings.Replace(data, varName, fmt.Sprintf("%.2d", time.Now().Minute()), -1)
+func minuteExpander(p *Preprocessor, ctx *renderContext, roomName string) string {
+	return fmt.Sprintf("%.2d", ctx.when().Minute())
 }
 
-func secondExpander(p *Preprocessor, roomName, varName, data string) string {
-	return strings.Replace(data, varName, fmt.Sprintf("%.2d", time.Now().Second()), -1)
+func secondExpander(p *Preprocessor, ctx *renderContext, roomName string) string {
+	return fmt.Sprintf("%.2d", ctx.when().Second())
 }
 
-func greetingMessageExpander(p *Preprocessor, roomName, varName, data string) string {
-	return strings.Replace(data, varName, p.rooms.GetGreetingMessage(roomName), -1)
+func greetingMessageExpander(p *Preprocessor, ctx *renderContext, roomName string) string {
+	return p.rooms.GetGreetingMessage(roomName)
 }
 
-func joinMessageExpander(p *Preprocessor, roomName, varName, data string) string {
-	return strings.Replace(data, varName, p.rooms.GetJoinMessage(roomName), -1)
+func joinMessageExpander(p *Preprocessor, ctx *renderContext, roomName string) string {
+	return p.rooms.GetJoinMessage(roomName)
 }
 
-func exitMessageExpander(p *Preprocessor, roomName, varName, data string) string {
-	return strings.Replace(data, varName, p.rooms.GetExitMessage(roomName), -1)
+func exitMessageExpander(p *Preprocessor, ctx *renderContext, roomName string) string {
+	return p.rooms.GetExitMessage(roomName)
 }
 
-func onIgnoreMessageExpander(p *Preprocessor, roomName, varName, data string) string {
-	return strings.Replace(data, varName, p.rooms.GetOnIgnoreMessage(roomName), -1)
+func onIgnoreMessageExpander(p *Preprocessor, ctx *renderContext, roomName string) string {
+	return p.rooms.GetOnIgnoreMessage(roomName)
 }
 
-func onDeIgnoreMessageExpander(p *Preprocessor, roomName, varName, data string) string {
-	return strings.Replace(data, varName, p.rooms.GetOnDeIgnoreMessage(roomName), -1)
+func onDeIgnoreMessageExpander(p *Preprocessor, ctx *renderContext, roomName string) string {
+	return p.rooms.GetOnDeIgnoreMessage(roomName)
 }
 
-func messagePrivateMarkerExpander(p *Preprocessor, roomName, varName, data string) string {
+func messagePrivateMarkerExpander(p *Preprocessor, ctx *renderContext, roomName string) string {
 	var privateMarker string
-	if p.rooms.GetNextMessage(roomName).Priv == "1" {
+	if ctx.message.Priv == "1" {
 		privateMarker = p.rooms.GetPrivateMessageMarker(roomName)
 	}
-	return strings.Replace(data, varName, privateMarker, -1)
+	return privateMarker
+}
+
+func maxUsersExpander(p *Preprocessor, ctx *renderContext, roomName string) string {
+	return p.rooms.GetMaxUsers(roomName)
+}
+
+func allowBriefExpander(p *Preprocessor, ctx *renderContext, roomName string) string {
+	if p.rooms.IsAllowingBriefs(roomName) && !p.rooms.IsPrivate(roomName) {
+		return "yes"
+	}
+	return ""
+}
+
+func allUsersAliasExpander(p *Preprocessor, ctx *renderContext, roomName string) string {
+	return p.rooms.GetAllUsersAlias(roomName)
 }
 
-func maxUsersExpander(p *Preprocessor, roomName, varName, data string) string {
-	return strings.Replace(data, varName, p.rooms.GetMaxUsers(roomName), -1)
+func actionListExpander(p *Preprocessor, ctx *renderContext, roomName string) string {
+	return p.rooms.GetUserActionList(roomName, ctx.user)
 }
 
-func allUsersAliasExpander(p *Preprocessor, roomName, varName, data string) string {
-	return strings.Replace(data, varName, p.rooms.GetAllUsersAlias(roomName), -1)
+func imageListExpander(p *Preprocessor, ctx *renderContext, roomName string) string {
+	return p.rooms.GetImageList(roomName)
 }
 
-func actionListExpander(p *Preprocessor, roomName, varName, data string) string {
-	return strings.Replace(data, varName, p.rooms.GetActionList(roomName), -1)
+func soundListExpander(p *Preprocessor, ctx *renderContext, roomName string) string {
+	return p.rooms.GetSoundList(roomName)
 }
 
-func imageListExpander(p *Preprocessor, roomName, varName, data string) string {
-	return strings.Replace(data, varName, p.rooms.GetImageList(roomName), -1)
+func usersListExpander(p *Preprocessor, ctx *renderContext, roomName string) string {
+	return p.rooms.GetUsersList(roomName)
 }
 
-//func sound_list_expander(p *Preprocessor, roomName, varName, data string) string {
-//    return strings.Replace(data, varName, p.rooms.GetSoundList(roomName), -1)
-//}
+func topTemplateExpander(p *Preprocessor, ctx *renderContext, roomName string) string {
+	return p.expandTemplate(ctx, roomName, p.rooms.GetTopTemplate(roomName))
+}
 
-func usersListExpander(p *Preprocessor, roomName, varName, data string) string {
-	return strings.Replace(data, varName, p.rooms.GetUsersList(roomName), -1)
+func bodyTemplateExpander(p *Preprocessor, ctx *renderContext, roomName string) string {
+	return p.expandTemplate(ctx, roomName, p.rooms.GetBodyTemplate(roomName))
 }
 
-func topTemplateExpander(p *Preprocessor, roomName, varName, data string) string {
-	return strings.Replace(data, varName, p.rooms.GetTopTemplate(roomName), -1)
+func bannerTemplateExpander(p *Preprocessor, ctx *renderContext, roomName string) string {
+	return p.expandTemplate(ctx, roomName, p.rooms.GetBannerTemplate(roomName))
 }
 
-func bodyTemplateExpander(p *Preprocessor, roomName, varName, data string) string {
-	return strings.Replace(data, varName, p.rooms.GetBodyTemplate(roomName), -1)
+func highlightTemplateExpander(p *Preprocessor, ctx *renderContext, roomName string) string {
+	return p.expandTemplate(ctx, roomName, p.rooms.GetHighlightTemplate(roomName))
 }
 
-func bannerTemplateExpander(p *Preprocessor, roomName, varName, data string) string {
-	return strings.Replace(data, varName, p.rooms.GetBannerTemplate(roomName), -1)
+func entranceTemplateExpander(p *Preprocessor, ctx *renderContext, roomName string) string {
+	return p.expandTemplate(ctx, roomName, p.rooms.GetEntranceTemplate(roomName))
 }
 
-func highlightTemplateExpander(p *Preprocessor, roomName, varName, data string) string {
-	return strings.Replace(data, varName, p.rooms.GetHighlightTemplate(roomName), -1)
+func exitTemplateExpander(p *Preprocessor, ctx *renderContext, roomName string) string {
+	return p.expandTemplate(ctx, roomName, p.rooms.GetExitTemplate(roomName))
 }
 
-func entranceTemplateExpander(p *Preprocessor, roomName, varName, data string) string {
-	return strings.Replace(data, varName, p.rooms.GetEntranceTemplate(roomName), -1)
+func nickclashTemplateExpander(p *Preprocessor, ctx *renderContext, roomName string) string {
+	return p.expandTemplate(ctx, roomName, p.rooms.GetNickclashTemplate(roomName))
 }
 
-func exitTemplateExpander(p *Preprocessor, roomName, varName, data string) string {
-	return strings.Replace(data, varName, p.rooms.GetExitTemplate(roomName), -1)
+func lastPublicMessagesExpander(p *Preprocessor, ctx *renderContext, roomName string) string {
+	return p.rooms.GetLastPublicMessages(roomName)
 }
 
-func nickclashTemplateExpander(p *Preprocessor, roomName, varName, data string) string {
-	return strings.Replace(data, varName, p.rooms.GetNickclashTemplate(roomName), -1)
+func servernameExpander(p *Preprocessor, ctx *renderContext, roomName string) string {
+	return p.rooms.GetServername()
 }
 
-func lastPublicMessagesExpander(p *Preprocessor, roomName, varName, data string) string {
-	return strings.Replace(data, varName, p.rooms.GetLastPublicMessages(roomName), -1)
+func listenPortExpander(p *Preprocessor, ctx *renderContext, roomName string) string {
+	return p.rooms.GetListenPort(roomName)
+}
+
+func schemeExpander(p *Preprocessor, ctx *renderContext, roomName string) string {
+	var scheme = "http"
+	if p.rooms.IsUsingTLS(roomName) {
+		scheme = "https"
+	}
+	return scheme
 }
 
-func servernameExpander(p *Preprocessor, roomName, varName, data string) string {
-	return strings.Replace(data, varName, p.rooms.GetServername(), -1)
+func roomPathExpander(p *Preprocessor, ctx *renderContext, roomName string) string {
+	return p.rooms.GetRoomPath(roomName)
 }
 
-func listenPortExpander(p *Preprocessor, roomName, varName, data string) string {
-	return strings.Replace(data, varName, p.rooms.GetListenPort(roomName), -1)
+func roomNameExpander(p *Preprocessor, ctx *renderContext, roomName string) string {
+	return roomName
 }
 
-func roomNameExpander(p *Preprocessor, roomName, varName, data string) string {
-	return strings.Replace(data, varName, roomName, -1)
+func roomTopicExpander(p *Preprocessor, ctx *renderContext, roomName string) string {
+	return Escape(p.rooms.GetTopic(roomName))
 }
 
-func usersTotalExpander(p *Preprocessor, roomName, varName, data string) string {
-	return strings.Replace(data, varName, p.rooms.GetUsersTotal(roomName), -1)
+func usersTotalExpander(p *Preprocessor, ctx *renderContext, roomName string) string {
+	return p.rooms.GetUsersTotal(roomName)
 }
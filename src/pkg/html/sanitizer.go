@@ -0,0 +1,103 @@
+/*
+ *                               Copyright (C) 2015 by Rafael Santiago
+ *
+ * This is a free software. You can redistribute it and/or modify under
+ * the terms of the GNU General Public License version 2.
+ *
+ */
+
+// This file keeps the user data from injecting markup (and scripts) into the rooms.
+
+package html
+
+import (
+	"bytes"
+	"strings"
+)
+
+// safeMarkup gathers the tags that can be allowed in user messages, the ones marked as "true" have no end tag.
+var safeMarkup = map[string]bool{
+	"b":      false,
+	"i":      false,
+	"u":      false,
+	"s":      false,
+	"em":     false,
+	"strong": false,
+	"small":  false,
+	"big":    false,
+	"sub":    false,
+	"sup":    false,
+	"code":   false,
+	"tt":     false,
+	"mark":   false,
+	"del":    false,
+	"ins":    false,
+	"q":      false,
+	"br":     true,
+	"hr":     true,
+	"wbr":    true,
+}
+
+var escaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", "\"", "&#34;", "'", "&#39;")
+
+// IsSafeMarkup verifies if a tag can be allowed in user messages.
+func IsSafeMarkup(tag string) bool {
+	_, safe := safeMarkup[strings.ToLower(tag)]
+	return safe
+}
+
+// Escape escapes all HTML special chars from data.
+func Escape(data string) string {
+	return escaper.Replace(data)
+}
+
+// Sanitize escapes the user data keeping only the allowed markup. Only plain tags (without attributes) are kept
+// and the tags left opened are closed at the end.
+func Sanitize(data string, allowedMarkup []string) string {
+	var allowed = make(map[string]bool)
+	for _, tag := range allowedMarkup {
+		if IsSafeMarkup(tag) {
+			allowed[strings.ToLower(tag)] = true
+		}
+	}
+	var sanitized bytes.Buffer
+	var opened []string
+	for len(data) > 0 {
+		if data[0] == '<' && len(allowed) > 0 {
+			if end := strings.IndexByte(data, '>'); end > 0 {
+				tag := strings.ToLower(data[1:end])
+				closing := strings.HasPrefix(tag, "/")
+				tag = strings.TrimSuffix(strings.TrimPrefix(tag, "/"), "/")
+				if allowed[tag] {
+					data = data[end+1:]
+					if safeMarkup[tag] {
+						if !closing {
+							sanitized.WriteString("<" + tag + ">")
+						}
+					} else if !closing {
+						opened = append(opened, tag)
+						sanitized.WriteString("<" + tag + ">")
+					} else {
+						//  INFO(Santiago): Closing a tag closes the ones opened inside it, stray end tags are dropped.
+						for o := len(opened) - 1; o >= 0; o-- {
+							if opened[o] == tag {
+								for len(opened) > o {
+									sanitized.WriteString("</" + opened[len(opened)-1] + ">")
+									opened = opened[:len(opened)-1]
+								}
+								break
+							}
+						}
+					}
+					continue
+				}
+			}
+		}
+		sanitized.WriteString(escaper.Replace(data[:1]))
+		data = data[1:]
+	}
+	for o := len(opened) - 1; o >= 0; o-- {
+		sanitized.WriteString("</" + opened[o] + ">")
+	}
+	return sanitized.String()
+}
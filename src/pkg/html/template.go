@@ -0,0 +1,273 @@
+/*
+ *                               Copyright (C) 2015 by Rafael Santiago
+ *
+ * This is a free software. You can redistribute it and/or modify under
+ * the terms of the GNU General Public License version 2.
+ *
+ */
+
+package html
+
+import (
+	"fmt"
+	"net/url"
+	"pkg/config"
+	"strings"
+)
+
+// Template is a compiled Cherry template. Besides the markers ("{{.name}}") it understands:
+//
+//	{{.name | html}}, {{.name | url}}               escapes the expanded marker
+//	{{if .name}} ... {{else}} ... {{end}}           true when the marker expands to something
+//	{{if not .name}} ... {{end}}
+//	{{range .users}} {{.item}} {{.item-label}} {{end}} users, actions, images and sounds can be listed
+type Template struct {
+	nodes []templateNode
+}
+
+type templateNode interface {
+	render(r *templateRenderer, out *strings.Builder)
+}
+
+type textNode string
+
+type markerNode struct {
+	key     string
+	literal string
+	filters []func(string) string
+}
+
+type ifNode struct {
+	key      string
+	negated  bool
+	thenPart []templateNode
+	elsePart []templateNode
+}
+
+type rangeNode struct {
+	list string
+	body []templateNode
+}
+
+// templateRenderer carries everything needed during a template execution.
+type templateRenderer struct {
+	p        *Preprocessor
+	ctx      *renderContext
+	roomName string
+	item     *config.ListItem
+}
+
+// maxTemplateDepth limits templates expanding templates.
+const maxTemplateDepth = 8
+
+var templateFilters = map[string]func(string) string{
+	"html": Escape,
+	"url":  url.QueryEscape,
+}
+
+var templateLists = map[string]func(*config.CherryRooms, string) []config.ListItem{
+	"users":   (*config.CherryRooms).GetUserItems,
+	"actions": (*config.CherryRooms).GetActionItems,
+	"images":  (*config.CherryRooms).GetImageItems,
+	"sounds":  (*config.CherryRooms).GetSoundItems,
+}
+
+// CompileTemplate compiles a template. The templates from the cherry file are compiled when it is loaded and
+// kept by their rooms (see CherryRooms.AddCompiledTemplate), so the requests only execute them.
+func CompileTemplate(data string) (*Template, error) {
+	nodes, _, _, err := parseTemplate(data, "")
+	if err != nil {
+		return nil, err
+	}
+	return &Template{nodes}, nil
+}
+
+// MustCompileTemplate compiles a built-in template, it panics when the template is broken.
+func MustCompileTemplate(data string) *Template {
+	template, err := CompileTemplate(data)
+	if err != nil {
+		panic("html: " + err.Error())
+	}
+	return template
+}
+
+// parseTemplate parses data until the end of the current block. It returns the parsed nodes, the data not
+// parsed yet and the action ("else" or "end") that closed the block.
+func parseTemplate(data, block string) ([]templateNode, string, string, error) {
+	var nodes []templateNode
+	for len(data) > 0 {
+		begin := strings.Index(data, "{{")
+		if begin == -1 {
+			nodes = append(nodes, textNode(data))
+			data = ""
+			break
+		}
+		end := strings.Index(data[begin:], "}}")
+		if end == -1 {
+			nodes = append(nodes, textNode(data))
+			data = ""
+			break
+		}
+		end += begin + 2
+		if begin > 0 {
+			nodes = append(nodes, textNode(data[:begin]))
+		}
+		literal := data[begin:end]
+		action := strings.TrimSpace(literal[2 : len(literal)-2])
+		data = data[end:]
+		fields := strings.Fields(action)
+		switch {
+		case action == "end" || action == "else":
+			if len(block) == 0 || (action == "else" && block != "if") {
+				return nil, "", "", fmt.Errorf("unexpected {{%s}}", action)
+			}
+			return nodes, data, action, nil
+
+		case len(fields) > 0 && fields[0] == "if":
+			node := &ifNode{}
+			if len(fields) == 3 && fields[1] == "not" {
+				node.negated = true
+				fields = fields[1:]
+			}
+			if len(fields) != 2 || !isMarkerName(fields[1]) {
+				return nil, "", "", fmt.Errorf("invalid condition {{%s}}", action)
+			}
+			node.key = markerKey(fields[1])
+			var closing string
+			var err error
+			node.thenPart, data, closing, err = parseTemplate(data, "if")
+			if err == nil && closing == "else" {
+				node.elsePart, data, closing, err = parseTemplate(data, "else")
+			}
+			if err != nil {
+				return nil, "", "", err
+			}
+			nodes = append(nodes, node)
+			break
+
+		case len(fields) > 0 && fields[0] == "range":
+			if len(fields) != 2 || !isMarkerName(fields[1]) {
+				return nil, "", "", fmt.Errorf("invalid loop {{%s}}", action)
+			}
+			if _, exists := templateLists[fields[1][1:]]; !exists {
+				return nil, "", "", fmt.Errorf("there is no list called \"%s\"", fields[1][1:])
+			}
+			node := &rangeNode{list: fields[1][1:]}
+			var err error
+			node.body, data, _, err = parseTemplate(data, "range")
+			if err != nil {
+				return nil, "", "", err
+			}
+			nodes = append(nodes, node)
+			break
+
+		default:
+			pipeline := strings.Split(action, "|")
+			name := strings.TrimSpace(pipeline[0])
+			if !isMarkerName(name) {
+				//  INFO(Santiago): It is not for us, so it is kept untouched.
+				nodes = append(nodes, textNode(literal))
+				break
+			}
+			node := &markerNode{key: markerKey(name), literal: literal}
+			for _, filter := range pipeline[1:] {
+				filterFunc, exists := templateFilters[strings.TrimSpace(filter)]
+				if !exists {
+					return nil, "", "", fmt.Errorf("unknown filter \"%s\"", strings.TrimSpace(filter))
+				}
+				node.filters = append(node.filters, filterFunc)
+			}
+			nodes = append(nodes, node)
+			break
+		}
+	}
+	if len(block) > 0 {
+		return nil, "", "", fmt.Errorf("{{%s}} without {{end}}", block)
+	}
+	return nodes, data, "", nil
+}
+
+func isMarkerName(name string) bool {
+	if len(name) < 2 || name[0] != '.' {
+		return false
+	}
+	for _, c := range name[1:] {
+		if !(c >= 'a' && c <= 'z') && !(c >= 'A' && c <= 'Z') && !(c >= '0' && c <= '9') && c != '-' && c != '_' {
+			return false
+		}
+	}
+	return true
+}
+
+func markerKey(name string) string {
+	return "{{" + name + "}}"
+}
+
+func (t *Template) execute(p *Preprocessor, ctx *renderContext, roomName string) string {
+	var out strings.Builder
+	renderNodes(t.nodes, &templateRenderer{p: p, ctx: ctx, roomName: roomName}, &out)
+	return out.String()
+}
+
+func renderNodes(nodes []templateNode, r *templateRenderer, out *strings.Builder) {
+	for _, node := range nodes {
+		node.render(r, out)
+	}
+}
+
+func (n textNode) render(r *templateRenderer, out *strings.Builder) {
+	out.WriteString(string(n))
+}
+
+func (n *markerNode) render(r *templateRenderer, out *strings.Builder) {
+	value, known := r.lookup(n.key)
+	if !known {
+		out.WriteString(n.literal)
+		return
+	}
+	for _, filter := range n.filters {
+		value = filter(value)
+	}
+	out.WriteString(value)
+}
+
+func (n *ifNode) render(r *templateRenderer, out *strings.Builder) {
+	value, _ := r.lookup(n.key)
+	if (len(value) > 0) != n.negated {
+		renderNodes(n.thenPart, r, out)
+	} else {
+		renderNodes(n.elsePart, r, out)
+	}
+}
+
+func (n *rangeNode) render(r *templateRenderer, out *strings.Builder) {
+	outerItem := r.item
+	for _, item := range templateLists[n.list](r.p.rooms, r.roomName) {
+		item := item
+		r.item = &item
+		renderNodes(n.body, r, out)
+	}
+	r.item = outerItem
+}
+
+// lookup gives the value of a marker and if this marker is known. Statical data has preference over the expanders.
+func (r *templateRenderer) lookup(key string) (string, bool) {
+	if r.item != nil {
+		switch key {
+		case "{{.item}}":
+			return r.item.Value, true
+
+		case "{{.item-label}}":
+			return r.item.Label, true
+		}
+	}
+	value, isSet := r.p.dataValue[key]
+	if isSet && len(value) > 0 {
+		return value, true
+	}
+	expander, isRegistered := r.p.dataExpander[key]
+	if isRegistered && expander != nil {
+		return expander(r.p, r.ctx, r.roomName), true
+	}
+	return "", isSet || isRegistered
+}
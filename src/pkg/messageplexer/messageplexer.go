@@ -10,63 +10,91 @@ Package messageplexer is the part responsible for messages delivering on Cherry.
 package messageplexer
 
 import (
-	"net"
 	"pkg/config"
 	"pkg/html"
 )
 
-// RoomMessagePlexer performs all message delivering stuff.
+// meActionTemplate is the template of the "/me" messages when the room has no action for them.
+var meActionTemplate = html.MustCompileTemplate("<p>({{.hour}}:{{.minute}}:{{.second}}) <i>* {{.message-colored-user}} {{.message-says}}</i>{{.message-sound}}")
+
+// defaultActionTemplate is a very basic action template, used when the message action has no template.
+var defaultActionTemplate = html.MustCompileTemplate("<p>({{.hour}}:{{.minute}}:{{.second}}) <b>{{.message-colored-user}}</b>: {{.message-says}}{{.message-sound}}")
+
+// RoomMessagePlexer performs all message delivering stuff. It sleeps while there is nothing to deliver and
+// gives up when the room is removed.
 func RoomMessagePlexer(roomName string, rooms *config.CherryRooms) {
 	preprocessor := html.NewHTMLPreprocessor(rooms)
+	room := rooms.GetRoom(roomName)
+	if room == nil {
+		return
+	}
+	for room.WaitForMessages() {
+		for room.BeginDelivery() {
+			delivered := deliverNextMessage(roomName, rooms, preprocessor)
+			room.EndDelivery()
+			if !delivered {
+				break
+			}
+		}
+	}
+}
+
+// deliverNextMessage delivers the oldest message of the queue. It returns "false" when the queue is empty.
+func deliverNextMessage(roomName string, rooms *config.CherryRooms, preprocessor *html.Preprocessor) bool {
+	if !rooms.HasPendingMessages(roomName) {
+		return false
+	}
+	currMessage := rooms.GetNextMessage(roomName)
+	if len(currMessage.Say) == 0 && len(currMessage.Image) == 0 && len(currMessage.Sound) == 0 {
+		//  INFO(Santiago): Nothing to say, it only would block the queue.
+		rooms.DequeueMessage(roomName)
+		return true
+	}
 	var allUsers = rooms.GetAllUsersAlias(roomName)
-	for {
-		currMessage := rooms.GetNextMessage(roomName)
-		if len(currMessage.Say) == 0 && len(currMessage.Image) == 0 /*&& len(currMessage.Sound) == 0*/ {
+	var actionTemplate string
+	if rooms.HasAction(roomName, currMessage.Action) {
+		actionTemplate = rooms.GetRoomActionTemplate(roomName, currMessage.Action)
+	}
+	var message string
+	switch {
+	case len(actionTemplate) > 0:
+		message = preprocessor.ExpandMessage(roomName, currMessage, actionTemplate)
+	case currMessage.Action == config.MeAction:
+		message = preprocessor.ExpandCompiledMessage(roomName, currMessage, meActionTemplate)
+	default:
+		message = preprocessor.ExpandCompiledMessage(roomName, currMessage, defaultActionTemplate)
+	}
+	if currMessage.Priv != "1" {
+		rooms.AddPublicMessage(roomName, message)
+		rooms.RecordMessage(roomName, currMessage, message)
+	}
+	preprocessor.SetDataValue("{{.current-formatted-message}}", message)
+	messageHighlighted := preprocessor.ExpandMessage(roomName, currMessage, rooms.GetHighlightTemplate(roomName))
+	preprocessor.UnsetDataValue("{{.current-formatted-message}}")
+	users := rooms.GetRoomUsers(roomName)
+	for _, user := range users {
+		if currMessage.Priv == "1" &&
+			user != currMessage.From &&
+			user != currMessage.To &&
+			currMessage.To != allUsers {
 			continue
 		}
-		var actionTemplate string
-		if rooms.HasAction(roomName, currMessage.Action) {
-			actionTemplate = rooms.GetRoomActionTemplate(roomName, currMessage.Action)
+		if rooms.IsIgnored(user, currMessage.From, roomName) {
+			continue
 		}
-		if len(actionTemplate) == 0 {
-			actionTemplate = "<p>({{.hour}}:{{.minute}}:{{.second}}) <b>{{.message-colored-user}}</b>: {{.message-says}}" //  INFO(Santiago): A very basic action template.
+		var messageBuffer []byte
+		if user == currMessage.From ||
+			user == currMessage.To {
+			messageBuffer = []byte(messageHighlighted)
+		} else {
+			messageBuffer = []byte(message)
 		}
-		message := preprocessor.ExpandData(roomName, actionTemplate)
-		if currMessage.Priv != "1" {
-			rooms.AddPublicMessage(roomName, message)
+		//  INFO(Santiago): Each user has its own writer, a stalled user cannot hold the others.
+		if !rooms.SendToUser(roomName, user, messageBuffer) && rooms.GetSlowClientPolicy(roomName) == "disconnect" {
+			rooms.DisconnectUser(roomName, user)
 		}
-		preprocessor.SetDataValue("{{.current-formatted-message}}", message)
-		messageHighlighted := preprocessor.ExpandData(roomName, rooms.GetHighlightTemplate(roomName))
-		preprocessor.UnsetDataValue("{{.current-formatted-message}}")
-		users := rooms.GetRoomUsers(roomName)
-		for _, user := range users {
-			if currMessage.Priv == "1" &&
-				user != currMessage.From &&
-				user != currMessage.To &&
-				currMessage.To != allUsers {
-				continue
-			}
-			if rooms.IsIgnored(user, currMessage.From, roomName) {
-				continue
-			}
-			var messageBuffer []byte
-			if user == currMessage.From ||
-				user == currMessage.To {
-				messageBuffer = []byte(messageHighlighted)
-			} else {
-				messageBuffer = []byte(message)
-			}
-			var conn net.Conn
-			conn = rooms.GetUserConnection(roomName, user)
-			if conn == nil {
-				continue
-			}
-			_, e := conn.Write(messageBuffer)
-			if e != nil {
-				rooms.EnqueueMessage(roomName, user, "", "", "", rooms.GetExitMessage(roomName), "")
-				rooms.RemoveUser(roomName, user)
-			}
-		}
-		rooms.DequeueMessage(roomName)
 	}
+	rooms.NotifyObservers(roomName, config.RoomEvent{Kind: config.MessageDelivered, Message: currMessage})
+	rooms.DequeueMessage(roomName)
+	return true
 }
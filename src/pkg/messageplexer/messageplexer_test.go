@@ -0,0 +1,98 @@
+/*
+ *                                Copyright (C) 2016 by Rafael Santiago
+ *
+ * This is a free software. You can redistribute it and/or modify under
+ * the terms of the GNU General Public License version 2.
+ *
+ */
+package messageplexer
+
+import (
+	"crypto/sha256"
+	"net"
+	"pkg/config"
+	"pkg/html"
+	"testing"
+)
+
+// busySpinningPlexer is the former design, kept here only as reference: it keeps polling the queue.
+func busySpinningPlexer(roomName string, rooms *config.CherryRooms) {
+	preprocessor := html.NewHTMLPreprocessor(rooms)
+	room := rooms.GetRoom(roomName)
+	if room == nil {
+		return
+	}
+	for room.BeginDelivery() {
+		deliverNextMessage(roomName, rooms, preprocessor)
+		room.EndDelivery()
+	}
+}
+
+func newTestRoom() (*config.CherryRooms, net.Conn) {
+	rooms := config.NewCherryRooms()
+	rooms.AddRoom("aliens-on-earth", 1024)
+	rooms.AddUser("aliens-on-earth", "dunha", "0", false)
+	rooms.AddUser("aliens-on-earth", "donha", "0", false)
+	conn, peer := net.Pipe()
+	rooms.SetUserConnection("aliens-on-earth", "dunha", conn)
+	return rooms, peer
+}
+
+func TestRoomMessagePlexer(t *testing.T) {
+	rooms, peer := newTestRoom()
+	go RoomMessagePlexer("aliens-on-earth", rooms)
+	//  INFO(Santiago): An empty message must not block the next ones.
+	rooms.EnqueueMessage("aliens-on-earth", "donha", "", "", "", "", "", "")
+	rooms.EnqueueMessage("aliens-on-earth", "donha", "", "", "", "", "boo!", "")
+	buf := make([]byte, 4096)
+	n, err := peer.Read(buf)
+	if err != nil || n == 0 {
+		t.Fail()
+	}
+	rooms.RemoveRoom("aliens-on-earth")
+	if _, err = peer.Read(buf); err == nil {
+		t.Fail()
+	}
+}
+
+func benchmarkDelivery(b *testing.B, plexer func(string, *config.CherryRooms)) {
+	rooms, peer := newTestRoom()
+	go plexer("aliens-on-earth", rooms)
+	buf := make([]byte, 4096)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		rooms.EnqueueMessage("aliens-on-earth", "donha", "", "", "", "", "boo!", "")
+		peer.Read(buf)
+	}
+	b.StopTimer()
+	rooms.RemoveRoom("aliens-on-earth")
+}
+
+// benchmarkIdleRoom measures how much a room without messages disturbs the rest of the server.
+func benchmarkIdleRoom(b *testing.B, plexer func(string, *config.CherryRooms)) {
+	rooms, _ := newTestRoom()
+	go plexer("aliens-on-earth", rooms)
+	data := make([]byte, 1024)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		sha256.Sum256(data)
+	}
+	b.StopTimer()
+	rooms.RemoveRoom("aliens-on-earth")
+}
+
+func BenchmarkDeliveryBusySpinning(b *testing.B) {
+	benchmarkDelivery(b, busySpinningPlexer)
+}
+
+func BenchmarkDeliveryDispatcher(b *testing.B) {
+	benchmarkDelivery(b, RoomMessagePlexer)
+}
+
+func BenchmarkIdleRoomBusySpinning(b *testing.B) {
+	benchmarkIdleRoom(b, busySpinningPlexer)
+}
+
+func BenchmarkIdleRoomDispatcher(b *testing.B) {
+	benchmarkIdleRoom(b, RoomMessagePlexer)
+}
@@ -0,0 +1,67 @@
+/*
+Package ratelimit implements the token buckets used to limit how often things can happen on Cherry.
+--
+ *                               Copyright (C) 2015 by Rafael Santiago
+ *
+ * This is a free software. You can redistribute it and/or modify under
+ * the terms of the GNU General Public License version 2.
+ *
+*/
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// Bucket is a token bucket. Each allowed event takes one token and the tokens are refilled at a constant rate.
+type Bucket struct {
+	mutex    *sync.Mutex
+	rate     float64
+	capacity float64
+	tokens   float64
+	last     time.Time
+}
+
+// NewBucket creates a full bucket that holds up to @capacity tokens refilled at @rate tokens per second.
+func NewBucket(rate float64, capacity int) *Bucket {
+	return &Bucket{new(sync.Mutex), rate, float64(capacity), float64(capacity), time.Now()}
+}
+
+// Allow takes one token from the bucket. It returns "false" when the bucket is empty.
+func (b *Bucket) Allow() bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.refill()
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// IsEmpty verifies if the next Allow would fail, without taking any token.
+func (b *Bucket) IsEmpty() bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.refill()
+	return b.tokens < 1
+}
+
+// IsFull verifies if the bucket has all its tokens again (nobody has been using it lately).
+func (b *Bucket) IsFull() bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.refill()
+	return b.tokens == b.capacity
+}
+
+// refill puts back the tokens earned since the last time. WARN(Santiago): It must be called with the bucket mutex acquired.
+func (b *Bucket) refill() {
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.capacity {
+		b.tokens = b.capacity
+	}
+	b.last = now
+}
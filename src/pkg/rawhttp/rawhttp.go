@@ -11,12 +11,42 @@ Package rawhttp implements conveniences related with HTTP request/reply operatio
 package rawhttp
 
 import (
+	"bufio"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"net"
 	"net/url"
+	"strconv"
 	"strings"
+	"sync"
 )
 
+// MaxRequestLineSize is the maximum size (in bytes) accepted for the request line and for each header line.
+const MaxRequestLineSize = 8192
+
+// MaxHeaderSize is the maximum size (in bytes) accepted for all headers of a request.
+const MaxHeaderSize = 32768
+
+// MaxBodySize is the maximum size (in bytes) accepted for a request body.
+const MaxBodySize = 1048576
+
+// ErrBadRequest is returned by ReadRequest when the request is malformed.
+var ErrBadRequest = errors.New("malformed HTTP request")
+
+// ErrRequestTooLarge is returned by ReadRequest when some request part exceeds its size limit.
+var ErrRequestTooLarge = errors.New("HTTP request too large")
+
+// Request gathers a parsed HTTP request.
+type Request struct {
+	Method  string
+	Target  string
+	Version string
+	Headers map[string]string
+	Body    []byte
+}
+
 var charLT map[string]string
 var charLTInitialized bool
 
@@ -165,6 +195,22 @@ func cherryDefaultHTTPReplyHeader(statusCode int, closeConnection bool) string {
 		header += "403 FORBIDDEN"
 		break
 
+	case 400:
+		header += "400 BAD REQUEST"
+		break
+
+	case 413:
+		header += "413 REQUEST ENTITY TOO LARGE"
+		break
+
+	case 429:
+		header += "429 TOO MANY REQUESTS"
+		break
+
+	case 500:
+		header += "500 INTERNAL SERVER ERROR"
+		break
+
 	default:
 		header += "501 NOT IMPLEMENTED"
 		break
@@ -189,6 +235,33 @@ func MakeReplyBuffer(buffer string, statusCode int, closeConnection bool) []byte
 		-1))
 }
 
+// MakeReplyBufferWithHeaders assembles the reply buffer based on the statusCode including extra header lines.
+func MakeReplyBufferWithHeaders(buffer string, statusCode int, closeConnection bool, headers []string) []byte {
+	header := cherryDefaultHTTPReplyHeader(statusCode, closeConnection)
+	header = header[:len(header)-1]
+	for _, h := range headers {
+		header += h + "\n"
+	}
+	header += "\n"
+	return []byte(strings.Replace(header+buffer, "{{.content-length}}", fmt.Sprintf("%d", len(buffer)), -1))
+}
+
+// MakeReplyBufferWithContentType assembles the reply buffer of a document that is not HTML.
+func MakeReplyBufferWithContentType(buffer string, statusCode int, contentType string) []byte {
+	header := strings.Replace(cherryDefaultHTTPReplyHeader(statusCode, true), "Content-type: text/html", "Content-type: "+contentType, 1)
+	return []byte(strings.Replace(header+buffer, "{{.content-length}}", fmt.Sprintf("%d", len(buffer)), 1))
+}
+
+// MakeEventStreamReplyBuffer assembles the reply header that opens a Server-Sent Events stream.
+func MakeEventStreamReplyBuffer() []byte {
+	return []byte("HTTP/1.1 200 OK\r\n" +
+		"Server: Cherry/0.1\r\n" +
+		"Content-type: text/event-stream\r\n" +
+		"Cache-control: no-cache\r\n" +
+		"Connection: keep-alive\r\n" +
+		"X-Accel-Buffering: no\r\n\r\n")
+}
+
 // MakeReplyBufferByFilePath assembles the reply buffer base on the file data and the statusCode.
 func MakeReplyBufferByFilePath(filePath string, statusCode int, closeConnection bool) []byte {
 	buffer, err := ioutil.ReadFile(filePath)
@@ -264,6 +337,11 @@ func splitFields(buffer string) map[string]string {
 	return retval
 }
 
+// GetFieldsFromForm returns a map containing all fields in url-encoded form data.
+func GetFieldsFromForm(data string) map[string]string {
+	return splitFields(data)
+}
+
 // GetFieldsFromPost returns a map containing all fields in a HTTP post.
 func GetFieldsFromPost(buffer string) map[string]string {
 	if !strings.HasPrefix(buffer, "POST /") {
@@ -291,3 +369,192 @@ func GetFieldsFromGet(buffer string) map[string]string {
 	}
 	return splitFields(buffer[startIndex+1 : endIndex])
 }
+
+// EventStreamConn is a net.Conn where each Write is sent as one Server-Sent Event.
+type EventStreamConn struct {
+	net.Conn
+	mutex       *sync.Mutex
+	lastEventID uint64
+}
+
+// NewEventStreamConn wraps a connection which already has received the event stream reply header.
+// The event IDs continue from @lastEventID (usually taken from the Last-Event-ID header sent on reconnections).
+func NewEventStreamConn(conn net.Conn, lastEventID uint64) *EventStreamConn {
+	return &EventStreamConn{conn, new(sync.Mutex), lastEventID}
+}
+
+// Write sends @data as one event, each line of it becomes a "data:" field.
+func (e *EventStreamConn) Write(data []byte) (int, error) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.lastEventID++
+	payload := strings.Replace(strings.Replace(string(data), "\r\n", "\n", -1), "\r", "\n", -1)
+	event := fmt.Sprintf("id: %d\n", e.lastEventID)
+	for _, line := range strings.Split(strings.TrimSuffix(payload, "\n"), "\n") {
+		event += "data: " + line + "\n"
+	}
+	event += "\n"
+	if _, err := e.Conn.Write([]byte(event)); err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
+
+// ReadRequest reads a whole HTTP request (request line, headers and body) from @reader.
+func ReadRequest(reader *bufio.Reader) (*Request, error) {
+	line, err := readLine(reader, MaxRequestLineSize)
+	for err == nil && len(line) == 0 {
+		//  INFO(Santiago): Empty lines preceding the request line must be ignored (RFC 7230, 3.5).
+		line, err = readLine(reader, MaxRequestLineSize)
+	}
+	if err != nil {
+		return nil, err
+	}
+	requestLine := strings.Split(line, " ")
+	if len(requestLine) != 3 || len(requestLine[0]) == 0 || !strings.HasPrefix(requestLine[1], "/") ||
+		!strings.HasPrefix(requestLine[2], "HTTP/") {
+		return nil, ErrBadRequest
+	}
+	req := &Request{requestLine[0], requestLine[1], requestLine[2], make(map[string]string), nil}
+	var headerSize int
+	for {
+		line, err = readLine(reader, MaxRequestLineSize)
+		if err != nil {
+			return nil, err
+		}
+		if len(line) == 0 {
+			break
+		}
+		headerSize += len(line)
+		if headerSize > MaxHeaderSize {
+			return nil, ErrRequestTooLarge
+		}
+		colon := strings.Index(line, ":")
+		if colon <= 0 {
+			return nil, ErrBadRequest
+		}
+		name := strings.ToLower(strings.TrimSpace(line[:colon]))
+		value := strings.TrimSpace(line[colon+1:])
+		if previous, exists := req.Headers[name]; exists {
+			value = previous + ", " + value
+		}
+		req.Headers[name] = value
+	}
+	if strings.Contains(strings.ToLower(req.GetHeader("Transfer-Encoding")), "chunked") {
+		req.Body, err = readChunkedBody(reader)
+	} else if contentLength := req.GetHeader("Content-Length"); len(contentLength) > 0 {
+		var length int64
+		length, err = strconv.ParseInt(contentLength, 10, 64)
+		if err != nil || length < 0 {
+			return nil, ErrBadRequest
+		}
+		if length > MaxBodySize {
+			return nil, ErrRequestTooLarge
+		}
+		req.Body = make([]byte, length)
+		_, err = io.ReadFull(reader, req.Body)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+func readLine(reader *bufio.Reader, limit int) (string, error) {
+	var line []byte
+	for {
+		chunk, isPrefix, err := reader.ReadLine()
+		if err != nil {
+			return "", err
+		}
+		line = append(line, chunk...)
+		if len(line) > limit {
+			return "", ErrRequestTooLarge
+		}
+		if !isPrefix {
+			break
+		}
+	}
+	return string(line), nil
+}
+
+func readChunkedBody(reader *bufio.Reader) ([]byte, error) {
+	var body []byte
+	for {
+		line, err := readLine(reader, MaxRequestLineSize)
+		if err != nil {
+			return nil, err
+		}
+		if semicolon := strings.Index(line, ";"); semicolon != -1 {
+			line = line[:semicolon]
+		}
+		var chunkSize int64
+		chunkSize, err = strconv.ParseInt(strings.TrimSpace(line), 16, 64)
+		if err != nil || chunkSize < 0 {
+			return nil, ErrBadRequest
+		}
+		if chunkSize == 0 {
+			break
+		}
+		if int64(len(body))+chunkSize > MaxBodySize {
+			return nil, ErrRequestTooLarge
+		}
+		chunk := make([]byte, chunkSize)
+		if _, err = io.ReadFull(reader, chunk); err != nil {
+			return nil, err
+		}
+		body = append(body, chunk...)
+		if line, err = readLine(reader, MaxRequestLineSize); err != nil || len(line) > 0 {
+			return nil, ErrBadRequest
+		}
+	}
+	//  INFO(Santiago): Trailers are read and discarded.
+	var trailerSize int
+	for {
+		line, err := readLine(reader, MaxRequestLineSize)
+		if err != nil {
+			return nil, err
+		}
+		if len(line) == 0 {
+			break
+		}
+		trailerSize += len(line)
+		if trailerSize > MaxHeaderSize {
+			return nil, ErrRequestTooLarge
+		}
+	}
+	return body, nil
+}
+
+// GetHeader returns the value of a request header (the header name is case-insensitive).
+func (r *Request) GetHeader(name string) string {
+	return r.Headers[strings.ToLower(name)]
+}
+
+// GetCookie returns the value of a cookie sent with the request.
+func (r *Request) GetCookie(name string) string {
+	for _, cookie := range strings.Split(r.GetHeader("Cookie"), ";") {
+		set := strings.SplitN(strings.TrimSpace(cookie), "=", 2)
+		if len(set) == 2 && set[0] == name {
+			return set[1]
+		}
+	}
+	return ""
+}
+
+// GetFieldsFromGet returns a map containing all fields passed through the request target.
+func (r *Request) GetFieldsFromGet() map[string]string {
+	index := strings.Index(r.Target, "&")
+	if index == -1 {
+		return make(map[string]string)
+	}
+	return splitFields(r.Target[index+1:])
+}
+
+// GetFieldsFromPost returns a map containing all fields posted through the request body.
+func (r *Request) GetFieldsFromPost() map[string]string {
+	if r.Method != "POST" {
+		return make(map[string]string)
+	}
+	return splitFields(string(r.Body))
+}
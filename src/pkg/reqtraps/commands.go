@@ -0,0 +1,206 @@
+/*
+ *                               Copyright (C) 2015 by Rafael Santiago
+ *
+ * This is a free software. You can redistribute it and/or modify under
+ * the terms of the GNU General Public License version 2.
+ *
+ */
+
+package reqtraps
+
+import (
+	"pkg/config"
+	"pkg/html"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// SlashCommand handles a command typed in the "says" field (e.g. "/nick dunha"). It receives what was typed
+// after the command name and the posted fields. It returns "true" when the post was completely handled, otherwise
+// the post (maybe changed by the command) goes on as an ordinary message.
+type SlashCommand func(roomName, args string, userData map[string]string, rooms *config.CherryRooms) bool
+
+var slashCommands = struct {
+	sync.RWMutex
+	commands map[string]SlashCommand
+}{commands: make(map[string]SlashCommand)}
+
+func init() {
+	RegisterSlashCommand("me", meCommand)
+	RegisterSlashCommand("msg", msgCommand)
+	RegisterSlashCommand("ignore", ignoreCommand)
+	RegisterSlashCommand("unignore", unignoreCommand)
+	RegisterSlashCommand("nick", nickCommand)
+	RegisterSlashCommand("who", whoCommand)
+	RegisterSlashCommand("topic", topicCommand)
+}
+
+// RegisterSlashCommand makes a command available in all rooms. The name is given without the slash.
+func RegisterSlashCommand(name string, command SlashCommand) {
+	slashCommands.Lock()
+	slashCommands.commands[strings.ToLower(name)] = command
+	slashCommands.Unlock()
+}
+
+// isSlashCommand verifies if a message is a command: a slash followed by a name made of letters. Anything
+// else ("//", "/usr/bin", ":/") is an ordinary message.
+func isSlashCommand(says string) bool {
+	name, _ := splitSlashCommand(says)
+	if len(name) == 0 {
+		return false
+	}
+	for _, r := range name {
+		if !unicode.IsLetter(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// splitSlashCommand returns the command name (lower case) and its arguments.
+func splitSlashCommand(says string) (string, string) {
+	if !strings.HasPrefix(says, "/") {
+		return "", ""
+	}
+	name, args := says[1:], ""
+	if s := strings.IndexFunc(name, unicode.IsSpace); s != -1 {
+		name, args = name[:s], strings.TrimSpace(name[s:])
+	}
+	return strings.ToLower(name), args
+}
+
+// runSlashCommand executes the command typed by the user. Unknown commands are reported only to who typed them.
+func runSlashCommand(roomName string, userData map[string]string, rooms *config.CherryRooms) bool {
+	name, args := splitSlashCommand(userData["says"])
+	slashCommands.RLock()
+	command, exists := slashCommands.commands[name]
+	slashCommands.RUnlock()
+	if !exists {
+		tellUser(roomName, userData["user"], "unknown command \"/"+name+"\", try "+getSlashCommandNames()+".", rooms)
+		return true
+	}
+	return command(roomName, args, userData, rooms)
+}
+
+func getSlashCommandNames() string {
+	slashCommands.RLock()
+	names := make([]string, 0, len(slashCommands.commands))
+	for name := range slashCommands.commands {
+		names = append(names, "/"+name)
+	}
+	slashCommands.RUnlock()
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// tellUser sends a notice that only @user can see. The notice is plain text.
+func tellUser(roomName, user, notice string, rooms *config.CherryRooms) {
+	rooms.EnqueueNotice(roomName, user, "(only you can see this) "+html.Escape(notice), "1")
+}
+
+// findUser returns the connected user that starts @args (nicknames can have spaces, the longest one wins)
+// and the rest of @args.
+func findUser(roomName, args string, rooms *config.CherryRooms) (string, string) {
+	var found string
+	for _, user := range append(rooms.GetRoomUsers(roomName), rooms.GetRoomBots(roomName)...) {
+		if len(user) > len(found) && (args == user || strings.HasPrefix(args, user+" ")) {
+			found = user
+		}
+	}
+	if len(found) == 0 {
+		return "", args
+	}
+	return found, strings.TrimSpace(args[len(found):])
+}
+
+func meCommand(roomName, args string, userData map[string]string, rooms *config.CherryRooms) bool {
+	if len(args) == 0 {
+		tellUser(roomName, userData["user"], "usage: /me <what you are doing>", rooms)
+		return true
+	}
+	userData["action"] = config.MeAction
+	userData["whoto"] = rooms.GetAllUsersAlias(roomName)
+	userData["priv"] = ""
+	userData["says"] = args
+	return false
+}
+
+func msgCommand(roomName, args string, userData map[string]string, rooms *config.CherryRooms) bool {
+	whoto, says := findUser(roomName, args, rooms)
+	if len(whoto) == 0 || len(says) == 0 {
+		tellUser(roomName, userData["user"], "usage: /msg <nickname> <message> (the nickname must be in this room)", rooms)
+		return true
+	}
+	if userData["action"] == rooms.GetIgnoreAction(roomName) || userData["action"] == rooms.GetDeIgnoreAction(roomName) ||
+		rooms.IsModerationAction(roomName, userData["action"]) {
+		userData["action"] = ""
+	}
+	userData["whoto"] = whoto
+	userData["priv"] = "1"
+	userData["says"] = says
+	return false
+}
+
+func ignoreCommand(roomName, args string, userData map[string]string, rooms *config.CherryRooms) bool {
+	if !rooms.HasUser(roomName, args) || args == userData["user"] {
+		tellUser(roomName, userData["user"], "usage: /ignore <nickname> (the nickname must be in this room)", rooms)
+		return true
+	}
+	ignoreUser(roomName, userData["user"], args, rooms)
+	return true
+}
+
+func unignoreCommand(roomName, args string, userData map[string]string, rooms *config.CherryRooms) bool {
+	if !rooms.IsIgnored(userData["user"], args, roomName) {
+		tellUser(roomName, userData["user"], "you are not ignoring \""+args+"\".", rooms)
+		return true
+	}
+	deignoreUser(roomName, userData["user"], args, rooms)
+	return true
+}
+
+func nickCommand(roomName, args string, userData map[string]string, rooms *config.CherryRooms) bool {
+	user := userData["user"]
+	if !policeFlood(roomName, user, rooms) {
+		return true
+	}
+	//  INFO(Santiago): The moderator rights belong to the nickname, a renamed moderator would leave them behind.
+	if rooms.HasModerator(roomName, user) {
+		tellUser(roomName, user, "moderators can not change their nicknames.", rooms)
+		return true
+	}
+	if !isValidNickname(args) || args == rooms.GetAllUsersAlias(roomName) || rooms.IsWaiting(roomName, args) || rooms.HasBot(roomName, args) || rooms.IsIncomingHookNickname(roomName, args) ||
+		rooms.HasModerator(roomName, args) || !rooms.RenameUser(roomName, user, args) {
+		tellUser(roomName, user, "the nickname \""+args+"\" is invalid or already taken.", rooms)
+		return true
+	}
+	userData["user"] = args
+	rooms.EnqueueNotice(roomName, args, html.Escape(user)+" is now known as "+html.Escape(args)+".", "")
+	return true
+}
+
+func whoCommand(roomName, args string, userData map[string]string, rooms *config.CherryRooms) bool {
+	users := append(rooms.GetRoomUsers(roomName), rooms.GetRoomBots(roomName)...)
+	sort.Strings(users)
+	tellUser(roomName, userData["user"], "who is here: "+strings.Join(users, ", ")+".", rooms)
+	return true
+}
+
+func topicCommand(roomName, args string, userData map[string]string, rooms *config.CherryRooms) bool {
+	if len(args) == 0 {
+		if topic := rooms.GetTopic(roomName); len(topic) > 0 {
+			tellUser(roomName, userData["user"], "the topic is: "+topic, rooms)
+		} else {
+			tellUser(roomName, userData["user"], "there is no topic.", rooms)
+		}
+		return true
+	}
+	if !policeFlood(roomName, userData["user"], rooms) {
+		return true
+	}
+	rooms.SetTopic(roomName, args)
+	rooms.EnqueueNotice(roomName, userData["user"], html.Escape(userData["user"])+" changed the topic to: "+html.Escape(args), "")
+	return true
+}
@@ -0,0 +1,42 @@
+/*
+ *                               Copyright (C) 2015 by Rafael Santiago
+ *
+ * This is a free software. You can redistribute it and/or modify under
+ * the terms of the GNU General Public License version 2.
+ *
+ */
+
+package reqtraps
+
+import (
+	"fmt"
+	"pkg/config"
+	"time"
+)
+
+func init() {
+	RegisterSlashCommand("invite", inviteCommand)
+}
+
+// getInviteURL returns the link that opens the entrance form of the room carrying an invite.
+func getInviteURL(roomName, token string, rooms *config.CherryRooms) string {
+	scheme := "http"
+	if rooms.IsUsingTLS(roomName) {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://%s:%s%s/join&invite=%s&", scheme, rooms.GetServername(), rooms.GetListenPort(roomName),
+		rooms.GetRoomPath(roomName), token)
+}
+
+func inviteCommand(roomName, args string, userData map[string]string, rooms *config.CherryRooms) bool {
+	if !rooms.IsInviteOnly(roomName) {
+		tellUser(roomName, userData["user"], "this room does not need invites.", rooms)
+		return true
+	}
+	if !policeFlood(roomName, userData["user"], rooms) {
+		return true
+	}
+	tellUser(roomName, userData["user"], fmt.Sprintf("this invite can be used once in the next %s: %s",
+		time.Duration(rooms.GetInviteTime(roomName))*time.Second, getInviteURL(roomName, rooms.NewInvite(roomName), rooms)), rooms)
+	return true
+}
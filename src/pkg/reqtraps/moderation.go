@@ -0,0 +1,180 @@
+/*
+ *                               Copyright (C) 2015 by Rafael Santiago
+ *
+ * This is a free software. You can redistribute it and/or modify under
+ * the terms of the GNU General Public License version 2.
+ *
+ */
+
+package reqtraps
+
+import (
+	"fmt"
+	"pkg/bans"
+	"pkg/config"
+	"strings"
+	"time"
+)
+
+func init() {
+	RegisterSlashCommand("kick", kickCommand)
+	RegisterSlashCommand("mute", muteCommand)
+	RegisterSlashCommand("ban", banCommand)
+	RegisterSlashCommand("unban", unbanCommand)
+	RegisterSlashCommand("bans", bansCommand)
+}
+
+// isModerating verifies if @user is a moderator, the others are told that they can not do it.
+func isModerating(roomName, user string, rooms *config.CherryRooms) bool {
+	if !rooms.IsModerator(roomName, user) {
+		tellUser(roomName, user, "only the moderators can do it.", rooms)
+		return false
+	}
+	return true
+}
+
+// moderate applies the moderation @action of @moderator on @whoto. It returns "false" when nothing was done.
+func moderate(roomName, moderator, action, whoto string, rooms *config.CherryRooms) bool {
+	if !isModerating(roomName, moderator, rooms) {
+		return false
+	}
+	if !rooms.HasUser(roomName, whoto) || whoto == moderator || rooms.IsModerator(roomName, whoto) {
+		tellUser(roomName, moderator, "\""+whoto+"\" can not be moderated.", rooms)
+		return false
+	}
+	switch action {
+	case rooms.GetKickAction(roomName):
+		kickOut(roomName, whoto, rooms.GetKickMessage(roomName), rooms)
+		break
+
+	case rooms.GetMuteAction(roomName):
+		rooms.MuteUser(roomName, whoto, rooms.GetMuteTime(roomName))
+		rooms.EnqueueNotice(roomName, whoto, rooms.GetMuteMessage(roomName), "")
+		break
+
+	case rooms.GetBanAction(roomName):
+		banUser(roomName, moderator, whoto, time.Duration(rooms.GetBanTime(roomName))*time.Second, rooms)
+		break
+	}
+	return true
+}
+
+// banUser bans the nickname and the address of a user in the room and kicks this user out.
+func banUser(roomName, moderator, user string, duration time.Duration, rooms *config.CherryRooms) {
+	if err := rooms.BanUser(roomName, user, moderator, duration); err != nil {
+		fmt.Println("ERROR: unable to save the ban list [more details: " + err.Error() + "].")
+	}
+	kickOut(roomName, user, rooms.GetBanMessage(roomName), rooms)
+}
+
+// moderationCommand runs a moderation action typed as a command (e.g. "/kick dunha").
+func moderationCommand(roomName, action, args string, userData map[string]string, rooms *config.CherryRooms) bool {
+	if len(action) == 0 {
+		tellUser(roomName, userData["user"], "this room has no such moderation action.", rooms)
+		return true
+	}
+	moderate(roomName, userData["user"], action, args, rooms)
+	return true
+}
+
+func kickCommand(roomName, args string, userData map[string]string, rooms *config.CherryRooms) bool {
+	return moderationCommand(roomName, rooms.GetKickAction(roomName), args, userData, rooms)
+}
+
+func muteCommand(roomName, args string, userData map[string]string, rooms *config.CherryRooms) bool {
+	return moderationCommand(roomName, rooms.GetMuteAction(roomName), args, userData, rooms)
+}
+
+// splitBanDuration splits "<target> [<duration>]" (e.g. "agent* 72h").
+func splitBanDuration(args string) (string, time.Duration) {
+	fields := strings.Fields(args)
+	if len(fields) < 2 {
+		return args, 0
+	}
+	last := fields[len(fields)-1]
+	duration, err := time.ParseDuration(last)
+	if err != nil || duration <= 0 {
+		return args, 0
+	}
+	return strings.TrimSpace(args[:len(args)-len(last)]), duration
+}
+
+// banCommand bans a user in the room, a nickname pattern (e.g. "agent*"), an IP or a CIDR range. An optional
+// duration (e.g. "30m", "72h") can be given after the target.
+func banCommand(roomName, args string, userData map[string]string, rooms *config.CherryRooms) bool {
+	moderator := userData["user"]
+	if len(rooms.GetBanAction(roomName)) == 0 {
+		tellUser(roomName, moderator, "this room has no such moderation action.", rooms)
+		return true
+	}
+	if !isModerating(roomName, moderator, rooms) {
+		return true
+	}
+	target, duration := splitBanDuration(args)
+	if rooms.HasUser(roomName, args) {
+		target, duration = args, time.Duration(rooms.GetBanTime(roomName))*time.Second
+	}
+	if rooms.HasUser(roomName, target) {
+		if target == moderator || rooms.IsModerator(roomName, target) {
+			tellUser(roomName, moderator, "\""+target+"\" can not be moderated.", rooms)
+		} else {
+			banUser(roomName, moderator, target, duration, rooms)
+		}
+		return true
+	}
+	ban := bans.Ban{Room: roomName, By: moderator}
+	if bans.IsAddress(target) {
+		ban.Address = target
+	} else {
+		ban.Nickname = target
+	}
+	if duration > 0 {
+		ban.Until = time.Now().Add(duration)
+	}
+	if len(target) == 0 || ban.Validate() != nil {
+		tellUser(roomName, moderator, "usage: /ban <nickname, nickname pattern, IP or CIDR> [<duration>]", rooms)
+		return true
+	}
+	if err := rooms.GetBans().Add(ban); err != nil {
+		fmt.Println("ERROR: unable to save the ban list [more details: " + err.Error() + "].")
+	}
+	tellUser(roomName, moderator, "banned: "+ban.String()+".", rooms)
+	for _, user := range rooms.GetBannedUsers(roomName) {
+		kickOut(roomName, user, rooms.GetBanMessage(roomName), rooms)
+	}
+	return true
+}
+
+func unbanCommand(roomName, args string, userData map[string]string, rooms *config.CherryRooms) bool {
+	moderator := userData["user"]
+	if !isModerating(roomName, moderator, rooms) {
+		return true
+	}
+	lifted, err := rooms.GetBans().Remove(roomName, args)
+	if err != nil {
+		fmt.Println("ERROR: unable to save the ban list [more details: " + err.Error() + "].")
+	}
+	if lifted == 0 {
+		tellUser(roomName, moderator, "there is no ban for \""+args+"\".", rooms)
+	} else {
+		tellUser(roomName, moderator, fmt.Sprintf("%d ban(s) lifted for \"%s\".", lifted, args), rooms)
+	}
+	return true
+}
+
+func bansCommand(roomName, args string, userData map[string]string, rooms *config.CherryRooms) bool {
+	moderator := userData["user"]
+	if !isModerating(roomName, moderator, rooms) {
+		return true
+	}
+	var descriptions []string
+	for _, ban := range rooms.GetBans().Get(roomName) {
+		descriptions = append(descriptions, ban.String())
+	}
+	if len(descriptions) == 0 {
+		tellUser(roomName, moderator, "nobody is banned.", rooms)
+	} else {
+		tellUser(roomName, moderator, "banned: "+strings.Join(descriptions, "; ")+".", rooms)
+	}
+	return true
+}
@@ -10,25 +10,33 @@ Package reqtraps implements the traps that handle each relevant HTTP method.
 package reqtraps
 
 import (
+	"encoding/json"
+	"fmt"
 	"net"
 	"os"
 	"pkg/config"
 	"pkg/html"
 	"pkg/rawhttp"
+	"pkg/search"
+	"pkg/transcript"
+	"pkg/websocket"
+	"strconv"
 	"strings"
+	"unicode"
+	"unicode/utf8"
 )
 
 // RequestTrapInterface is used for what it suggests [Hi lint! you are so stupid!].
 type RequestTrapInterface interface {
-	Handle(newConn net.Conn, roomName, httpPayload string, rooms *config.CherryRooms, preprocessor *html.Preprocessor)
+	Handle(newConn net.Conn, roomName string, req *rawhttp.Request, rooms *config.CherryRooms, preprocessor *html.Preprocessor)
 }
 
 // RequestTrapHandleFunc idem.
-type RequestTrapHandleFunc func(newConn net.Conn, roomName, httpPayload string, rooms *config.CherryRooms, preprocessor *html.Preprocessor)
+type RequestTrapHandleFunc func(newConn net.Conn, roomName string, req *rawhttp.Request, rooms *config.CherryRooms, preprocessor *html.Preprocessor)
 
 // Handle idem.
-func (h RequestTrapHandleFunc) Handle(newConn net.Conn, roomName, httpPayload string, rooms *config.CherryRooms, preprocessor *html.Preprocessor) {
-	h(newConn, roomName, httpPayload, rooms, preprocessor)
+func (h RequestTrapHandleFunc) Handle(newConn net.Conn, roomName string, req *rawhttp.Request, rooms *config.CherryRooms, preprocessor *html.Preprocessor) {
+	h(newConn, roomName, req, rooms, preprocessor)
 }
 
 // RequestTrap idem.
@@ -41,21 +49,35 @@ func BuildRequestTrap(handle RequestTrapHandleFunc) RequestTrap {
 	}
 }
 
-// GetRequestTrap returns the correct trap that should be used to handle the user request.
-func GetRequestTrap(httpPayload string) RequestTrap {
-	var httpMethodPart string
-	var spaceNr int
-	for _, h := range httpPayload {
-		if h == ' ' {
-			spaceNr++
+// GetRequestTrap returns the correct trap that should be used to handle the user request and the room
+// that it refers to. When the request came through the shared port (@roomName is empty) the room is
+// taken from the "/r/<room-name>" path prefix, which is stripped from the request target.
+func GetRequestTrap(req *rawhttp.Request, roomName string, rooms *config.CherryRooms) (RequestTrap, string) {
+	if len(roomName) > 0 && !rooms.HasRoom(roomName) {
+		//  INFO(Santiago): The room was closed by a reload while this request was arriving.
+		return BuildRequestTrap(BadAssErrorHandle), ""
+	}
+	if len(roomName) == 0 {
+		if !strings.HasPrefix(req.Target, "/r/") {
+			return BuildRequestTrap(BadAssErrorHandle), ""
 		}
-		if h == '\n' || h == '\r' || spaceNr == 2 {
-			break
+		roomPath := req.Target[3:]
+		slash := strings.Index(roomPath, "/")
+		if slash == -1 {
+			return BuildRequestTrap(BadAssErrorHandle), ""
+		}
+		roomName = roomPath[:slash]
+		if !rooms.HasRoom(roomName) || !rooms.IsSharingPort(roomName) {
+			return BuildRequestTrap(BadAssErrorHandle), ""
 		}
-		httpMethodPart += string(h)
+		req.Target = roomPath[slash:]
 	}
-	httpMethodPart += "$"
-	if strings.HasPrefix(httpMethodPart, "GET /join$") {
+	return getRoomRequestTrap(req), roomName
+}
+
+func getRoomRequestTrap(req *rawhttp.Request) RequestTrap {
+	httpMethodPart := req.Method + " " + req.Target + "$"
+	if strings.HasPrefix(httpMethodPart, "GET /join$") || strings.HasPrefix(httpMethodPart, "GET /join&") {
 		return BuildRequestTrap(GetJoinHandle)
 	}
 	if strings.HasPrefix(httpMethodPart, "GET /brief$") {
@@ -70,9 +92,18 @@ func GetRequestTrap(httpPayload string) RequestTrap {
 	if strings.HasPrefix(httpMethodPart, "GET /body&") {
 		return BuildRequestTrap(GetBodyHandle)
 	}
+	if strings.HasPrefix(httpMethodPart, "GET /events&") {
+		return BuildRequestTrap(GetEventsHandle)
+	}
+	if strings.HasPrefix(httpMethodPart, "GET /ws&") {
+		return BuildRequestTrap(GetWebSocketHandle)
+	}
 	if strings.HasPrefix(httpMethodPart, "GET /exit&") {
 		return BuildRequestTrap(GetExitHandle)
 	}
+	if strings.HasPrefix(httpMethodPart, "GET /wait&") {
+		return BuildRequestTrap(GetWaitHandle)
+	}
 	if strings.HasPrefix(httpMethodPart, "POST /join$") {
 		return BuildRequestTrap(PostJoinHandle)
 	}
@@ -85,6 +116,18 @@ func GetRequestTrap(httpPayload string) RequestTrap {
 	if strings.HasPrefix(httpMethodPart, "POST /find$") {
 		return BuildRequestTrap(PostFindHandle)
 	}
+	if strings.HasPrefix(httpMethodPart, "GET /transcript$") || strings.HasPrefix(httpMethodPart, "GET /transcript&") {
+		return BuildRequestTrap(GetTranscriptHandle)
+	}
+	if strings.HasPrefix(httpMethodPart, "GET /search$") {
+		return BuildRequestTrap(GetSearchHandle)
+	}
+	if strings.HasPrefix(httpMethodPart, "POST /search$") {
+		return BuildRequestTrap(PostSearchHandle)
+	}
+	if strings.HasPrefix(httpMethodPart, "POST /hook/") {
+		return BuildRequestTrap(PostHookHandle)
+	}
 	if strings.HasPrefix(httpMethodPart, "GET /pub/") {
 		return BuildRequestTrap(PubHandle)
 	}
@@ -92,7 +135,7 @@ func GetRequestTrap(httpPayload string) RequestTrap {
 }
 
 // GetFindHandle implements the handle for the find document (GET).
-func GetFindHandle(newConn net.Conn, roomName, httpPayload string, rooms *config.CherryRooms, preprocessor *html.Preprocessor) {
+func GetFindHandle(newConn net.Conn, roomName string, req *rawhttp.Request, rooms *config.CherryRooms, preprocessor *html.Preprocessor) {
 	var replyBuffer []byte
 	replyBuffer = rawhttp.MakeReplyBuffer(preprocessor.ExpandData(roomName, rooms.GetFindBotTemplate(roomName)), 200, true)
 	newConn.Write(replyBuffer)
@@ -100,24 +143,13 @@ func GetFindHandle(newConn net.Conn, roomName, httpPayload string, rooms *config
 }
 
 // PubHandle implements the handle for the room's public directory (GET).
-func PubHandle(newConn net.Conn, roomName, httpPayload string, rooms *config.CherryRooms, preprocessor *html.Preprocessor) {
+func PubHandle(newConn net.Conn, roomName string, req *rawhttp.Request, rooms *config.CherryRooms, preprocessor *html.Preprocessor) {
 	pubdir := rooms.GetPublicDirectory(roomName)
-	var httpReq string
-	var spaceNr int
-	for _, h := range httpPayload {
-		if h == ' ' {
-			spaceNr++
-		}
-		if h == '\n' || h == '\r' || spaceNr > 1 {
-			break
-		}
-		httpReq += string(h)
-	}
 	var replyBuffer []byte
-	if len(pubdir) == 0 || !strings.HasPrefix(httpReq, "GET /pub/"+pubdir) {
+	if len(pubdir) == 0 || !strings.HasPrefix(req.Target, "/pub/"+pubdir) {
 		replyBuffer = rawhttp.MakeReplyBuffer(html.GetBadAssErrorData(), 404, true)
 	} else {
-		relativeLocalPath := httpReq[9:]
+		relativeLocalPath := req.Target[5:]
 		_, err := os.Stat(relativeLocalPath)
 		if os.IsNotExist(err) {
 			replyBuffer = rawhttp.MakeReplyBuffer(html.GetBadAssErrorData(), 404, true)
@@ -130,9 +162,9 @@ func PubHandle(newConn net.Conn, roomName, httpPayload string, rooms *config.Che
 }
 
 // PostFindHandle implements the handle for the find document (POST).
-func PostFindHandle(newConn net.Conn, roomName, httpPayload string, rooms *config.CherryRooms, preprocessor *html.Preprocessor) {
+func PostFindHandle(newConn net.Conn, roomName string, req *rawhttp.Request, rooms *config.CherryRooms, preprocessor *html.Preprocessor) {
 	var userData map[string]string
-	userData = rawhttp.GetFieldsFromPost(httpPayload)
+	userData = req.GetFieldsFromPost()
 	var replyBuffer []byte
 	if _, posted := userData["user"]; !posted {
 		replyBuffer = rawhttp.MakeReplyBuffer(html.GetBadAssErrorData(), 404, true)
@@ -144,6 +176,9 @@ func PostFindHandle(newConn net.Conn, roomName, httpPayload string, rooms *confi
 		user := strings.ToUpper(userData["user"])
 		if len(user) > 0 {
 			for _, r := range availRooms {
+				if rooms.IsPrivate(r) {
+					continue
+				}
 				users := rooms.GetRoomUsers(r)
 				preprocessor.SetDataValue("{{.find-result-users-total}}", rooms.GetUsersTotal(r))
 				preprocessor.SetDataValue("{{.find-result-room-name}}", r)
@@ -162,71 +197,123 @@ func PostFindHandle(newConn net.Conn, roomName, httpPayload string, rooms *confi
 	newConn.Close()
 }
 
+// maxSearchResults is the maximum of messages listed by a search.
+const maxSearchResults = 100
+
+// GetSearchHandle implements the handle for the message search document (GET).
+func GetSearchHandle(newConn net.Conn, roomName string, req *rawhttp.Request, rooms *config.CherryRooms, preprocessor *html.Preprocessor) {
+	var replyBuffer []byte
+	replyBuffer = rawhttp.MakeReplyBuffer(preprocessor.ExpandData(roomName, rooms.GetSearchBotTemplate(roomName)), 200, true)
+	newConn.Write(replyBuffer)
+	newConn.Close()
+}
+
+// PostSearchHandle implements the handle for the message search results document (POST).
+func PostSearchHandle(newConn net.Conn, roomName string, req *rawhttp.Request, rooms *config.CherryRooms, preprocessor *html.Preprocessor) {
+	var userData map[string]string
+	userData = req.GetFieldsFromPost()
+	var replyBuffer []byte
+	since, sinceErr := transcript.ParseTime(strings.TrimSpace(userData["since"]))
+	until, untilErr := transcript.ParseTime(strings.TrimSpace(userData["until"]))
+	if sinceErr != nil || untilErr != nil {
+		replyBuffer = rawhttp.MakeReplyBuffer(html.GetBadAssErrorData(), 400, true)
+	} else {
+		var found []search.Result
+		for _, result := range rooms.SearchMessages(search.Query{Room: strings.TrimSpace(userData["room"]),
+			From: strings.TrimSpace(userData["user"]), Phrase: userData["phrase"], Since: since, Until: until,
+			Limit: maxSearchResults}) {
+			//  INFO(Santiago): The rooms closed by a reload are not listed anymore, neither are the private ones.
+			if rooms.HasRoom(result.Room) && !rooms.IsPrivate(result.Room) {
+				found = append(found, result)
+			}
+		}
+		preprocessor.SetDataValue("{{.search-results-total}}", fmt.Sprintf("%d", len(found)))
+		var result string
+		result = preprocessor.ExpandData(roomName, rooms.GetSearchHeadTemplate(roomName))
+		listing := rooms.GetSearchBodyTemplate(roomName)
+		for _, f := range found {
+			preprocessor.SetDataValue("{{.search-result-room-name}}", f.Room)
+			preprocessor.SetDataValue("{{.search-result-user}}", html.Escape(f.Entry.From))
+			preprocessor.SetDataValue("{{.search-result-time}}", f.Entry.Time.Local().Format("2006-01-02 15:04:05"))
+			preprocessor.SetDataValue("{{.search-result-says}}", html.Sanitize(f.Entry.Say, rooms.GetAllowedMarkup(f.Room)))
+			preprocessor.SetDataValue("{{.search-result-message}}", f.Entry.Formatted)
+			result += preprocessor.ExpandData(roomName, listing)
+		}
+		result += preprocessor.ExpandData(roomName, rooms.GetSearchTailTemplate(roomName))
+		replyBuffer = rawhttp.MakeReplyBuffer(result, 200, true)
+	}
+	newConn.Write(replyBuffer)
+	newConn.Close()
+}
+
 // GetJoinHandle implements the handle for the join document (GET).
-func GetJoinHandle(newConn net.Conn, roomName, httpPayload string, rooms *config.CherryRooms, preprocessor *html.Preprocessor) {
-	//  INFO(Santiago): The form for room joining was requested, so we will flush it to client.
+func GetJoinHandle(newConn net.Conn, roomName string, req *rawhttp.Request, rooms *config.CherryRooms, preprocessor *html.Preprocessor) {
+	//  INFO(Santiago): The form for room joining was requested, so we will flush it to client. When it comes
+	//                  from an invite link ("/join&invite=<token>&"), the form carries the invite.
 	var replyBuffer []byte
+	preprocessor.SetDataValue("{{.invite}}", html.Escape(req.GetFieldsFromGet()["invite"]))
 	replyBuffer = rawhttp.MakeReplyBuffer(preprocessor.ExpandData(roomName, rooms.GetEntranceTemplate(roomName)), 200, true)
 	newConn.Write(replyBuffer)
 	newConn.Close()
 }
 
 // GetTopHandle implements the handle for the top document (GET).
-func GetTopHandle(newConn net.Conn, roomName, httpPayload string, rooms *config.CherryRooms, preprocessor *html.Preprocessor) {
+func GetTopHandle(newConn net.Conn, roomName string, req *rawhttp.Request, rooms *config.CherryRooms, preprocessor *html.Preprocessor) {
 	var userData map[string]string
-	userData = rawhttp.GetFieldsFromGet(httpPayload)
+	userData = req.GetFieldsFromGet()
 	var replyBuffer []byte
-	if !rooms.IsValidUserRequest(roomName, userData["user"], userData["id"], newConn) {
+	if !rooms.IsValidUserRequest(roomName, userData["user"], getSessionID(roomName, req, userData, rooms), newConn) {
 		replyBuffer = rawhttp.MakeReplyBuffer(html.GetBadAssErrorData(), 404, true)
 	} else {
-		replyBuffer = rawhttp.MakeReplyBuffer(preprocessor.ExpandData(roomName, rooms.GetTopTemplate(roomName)), 200, true)
+		replyBuffer = rawhttp.MakeReplyBuffer(preprocessor.ExpandUserData(roomName, userData["user"], rooms.GetTopTemplate(roomName)), 200, true)
 	}
 	newConn.Write(replyBuffer)
 	newConn.Close()
 }
 
 // GetBannerHandle implements the handle for the banner document (GET).
-func GetBannerHandle(newConn net.Conn, roomName, httpPayload string, rooms *config.CherryRooms, preprocessor *html.Preprocessor) {
+func GetBannerHandle(newConn net.Conn, roomName string, req *rawhttp.Request, rooms *config.CherryRooms, preprocessor *html.Preprocessor) {
 	var userData map[string]string
 	var replyBuffer []byte
-	userData = rawhttp.GetFieldsFromGet(httpPayload)
-	preprocessor.SetDataValue("{{.nickname}}", userData["user"])
-	preprocessor.SetDataValue("{{.session-id}}", userData["id"])
-	if !rooms.IsValidUserRequest(roomName, userData["user"], userData["id"], newConn) {
+	userData = req.GetFieldsFromGet()
+	preprocessor.SetDataValue("{{.nickname}}", html.Escape(userData["user"]))
+	preprocessor.SetDataValue("{{.session-id}}", html.Escape(userData["id"]))
+	if !rooms.IsValidUserRequest(roomName, userData["user"], getSessionID(roomName, req, userData, rooms), newConn) {
 		replyBuffer = rawhttp.MakeReplyBuffer(html.GetBadAssErrorData(), 404, true)
 	} else {
-		replyBuffer = rawhttp.MakeReplyBuffer(preprocessor.ExpandData(roomName, rooms.GetBannerTemplate(roomName)), 200, true)
+		replyBuffer = rawhttp.MakeReplyBuffer(preprocessor.ExpandUserData(roomName, userData["user"], rooms.GetBannerTemplate(roomName)), 200, true)
 	}
 	newConn.Write(replyBuffer)
 	newConn.Close()
 }
 
 // GetExitHandle implements the handle for the exit document (GET).
-func GetExitHandle(newConn net.Conn, roomName, httpPayload string, rooms *config.CherryRooms, preprocessor *html.Preprocessor) {
+func GetExitHandle(newConn net.Conn, roomName string, req *rawhttp.Request, rooms *config.CherryRooms, preprocessor *html.Preprocessor) {
 	var userData map[string]string
 	var replyBuffer []byte
-	userData = rawhttp.GetFieldsFromGet(httpPayload)
-	if !rooms.IsValidUserRequest(roomName, userData["user"], userData["id"], newConn) {
+	userData = req.GetFieldsFromGet()
+	if !rooms.IsValidUserRequest(roomName, userData["user"], getSessionID(roomName, req, userData, rooms), newConn) {
 		replyBuffer = rawhttp.MakeReplyBuffer(html.GetBadAssErrorData(), 404, true)
 	} else {
-		preprocessor.SetDataValue("{{.nickname}}", userData["user"])
-		preprocessor.SetDataValue("{{.session-id}}", userData["id"])
-		replyBuffer = rawhttp.MakeReplyBuffer(preprocessor.ExpandData(roomName, rooms.GetExitTemplate(roomName)), 200, true)
+		preprocessor.SetDataValue("{{.nickname}}", html.Escape(userData["user"]))
+		preprocessor.SetDataValue("{{.session-id}}", html.Escape(userData["id"]))
+		replyBuffer = rawhttp.MakeReplyBuffer(preprocessor.ExpandUserData(roomName, userData["user"], rooms.GetExitTemplate(roomName)), 200, true)
+		//  INFO(Santiago): Only the user itself can leave, nobody else can take the user out with a forged exit.
+		rooms.EnqueueNotice(roomName, userData["user"], rooms.GetExitMessage(roomName), "")
+		rooms.RemoveUser(roomName, userData["user"])
 	}
-	rooms.EnqueueMessage(roomName, userData["user"], "", "", "", rooms.GetExitMessage(roomName), "")
 	newConn.Write(replyBuffer)
-	rooms.RemoveUser(roomName, userData["user"])
 	newConn.Close()
 }
 
 // PostJoinHandle implements the handle for the join document (POST).
-func PostJoinHandle(newConn net.Conn, roomName, httpPayload string, rooms *config.CherryRooms, preprocessor *html.Preprocessor) {
+func PostJoinHandle(newConn net.Conn, roomName string, req *rawhttp.Request, rooms *config.CherryRooms, preprocessor *html.Preprocessor) {
 	//  INFO(Santiago): Here, we need firstly parse the posted fields, check for "nickclash", if this is the case
 	//                  flush the page informing it. Otherwise we add the user basic info and flush the room skeleton
 	//                  [TOP/BODY/BANNER]. Then we finally close the connection.
 	var userData map[string]string
 	var replyBuffer []byte
-	userData = rawhttp.GetFieldsFromPost(httpPayload)
+	userData = req.GetFieldsFromPost()
 	if _, posted := userData["user"]; !posted {
 		newConn.Close()
 		return
@@ -235,26 +322,113 @@ func PostJoinHandle(newConn net.Conn, roomName, httpPayload string, rooms *confi
 		newConn.Close()
 		return
 	}
-	preprocessor.SetDataValue("{{.nickname}}", userData["user"])
+	preprocessor.SetDataValue("{{.nickname}}", html.Escape(userData["user"]))
 	preprocessor.SetDataValue("{{.session-id}}", "0")
-	if rooms.HasUser(roomName, userData["user"]) || userData["user"] == rooms.GetAllUsersAlias(roomName) ||
-		strings.Contains(userData["user"], "<") || strings.Contains(userData["user"], ">") ||
-		strings.Contains(userData["user"], "&lt") || strings.Contains(userData["user"], "&gt") {
+	addr := config.GetRemoteAddr(newConn)
+	//  INFO(Santiago): The users that left without saying goodbye must not keep their nicknames and places.
+	rooms.ExpireSessions(roomName)
+	isModerator := rooms.HasModerator(roomName, userData["user"])
+	//  INFO(Santiago): The moderators join with their own passwords, the room password and the invites are not for them.
+	mustBeInvited := !isModerator && rooms.IsInviteOnly(roomName)
+	if rooms.IsJoinBlocked(roomName, addr) {
+		replyBuffer = rawhttp.MakeReplyBuffer(html.GetBadAssErrorData(), 429, true)
+	} else if isModerator && !rooms.IsValidModeratorPassword(roomName, userData["user"], userData["password"]) {
+		rooms.AddJoinFailure(roomName, addr)
+		replyBuffer = rawhttp.MakeReplyBuffer(preprocessor.ExpandData(roomName, rooms.GetNickclashTemplate(roomName)), 200, true)
+	} else if !isModerator && rooms.IsBanned(roomName, userData["user"], addr) {
+		replyBuffer = rawhttp.MakeReplyBuffer(html.GetBadAssErrorData(), 403, true)
+	} else if (!isModerator && !rooms.IsValidRoomPassword(roomName, userData["password"])) ||
+		(mustBeInvited && !rooms.IsValidInvite(roomName, userData["invite"])) {
+		rooms.AddJoinFailure(roomName, addr)
+		replyBuffer = rawhttp.MakeReplyBuffer(html.GetBadAssErrorData(), 403, true)
+	} else if rooms.HasUser(roomName, userData["user"]) || rooms.HasBot(roomName, userData["user"]) || rooms.IsIncomingHookNickname(roomName, userData["user"]) || userData["user"] == rooms.GetAllUsersAlias(roomName) ||
+		rooms.IsWaiting(roomName, userData["user"]) || !isValidNickname(userData["user"]) {
 		replyBuffer = rawhttp.MakeReplyBuffer(preprocessor.ExpandData(roomName, rooms.GetNickclashTemplate(roomName)), 200, true)
+	} else if mustBeInvited && !rooms.ClaimInvite(roomName, userData["invite"]) {
+		//  INFO(Santiago): Someone else has just used the same invite.
+		replyBuffer = rawhttp.MakeReplyBuffer(html.GetBadAssErrorData(), 403, true)
+	} else if !rooms.AdmitUser(roomName, userData["user"], userData["color"], true) {
+		if rooms.IsUsingWaitingLine(roomName) {
+			ticket := rooms.EnqueueWaitingUser(roomName, userData["user"], userData["color"])
+			replyBuffer = makeWaitingLineReplyBuffer(roomName, ticket, rooms, preprocessor)
+		} else {
+			replyBuffer = makeRoomFullReplyBuffer(roomName, rooms, preprocessor)
+		}
 	} else {
-		rooms.AddUser(roomName, userData["user"], userData["color"], true)
-		preprocessor.SetDataValue("{{.session-id}}", rooms.GetSessionID(userData["user"], roomName))
-		replyBuffer = rawhttp.MakeReplyBuffer(preprocessor.ExpandData(roomName, rooms.GetSkeletonTemplate(roomName)), 200, true)
-		rooms.EnqueueMessage(roomName, userData["user"], "", "", "", rooms.GetJoinMessage(roomName), "")
+		replyBuffer = makeJoinReplyBuffer(roomName, userData["user"], rooms, preprocessor)
 	}
 	newConn.Write(replyBuffer)
 	newConn.Close()
 }
 
+// maxNicknameLength is the maximum of chars that a nickname can have.
+const maxNicknameLength = 32
+
+// isValidNickname verifies if a nickname is composed only by letters, digits, spaces, "_", "-" and ".".
+func isValidNickname(nickname string) bool {
+	if len(strings.TrimSpace(nickname)) != len(nickname) || utf8.RuneCountInString(nickname) > maxNicknameLength {
+		return false
+	}
+	for _, r := range nickname {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && !strings.ContainsRune(" _-.", r) {
+			return false
+		}
+	}
+	return len(nickname) > 0
+}
+
+// GetWaitHandle implements the handle for the waiting line document (GET).
+func GetWaitHandle(newConn net.Conn, roomName string, req *rawhttp.Request, rooms *config.CherryRooms, preprocessor *html.Preprocessor) {
+	var userData map[string]string
+	var replyBuffer []byte
+	userData = req.GetFieldsFromGet()
+	if nickname := rooms.ClaimAdmission(roomName, userData["ticket"]); len(nickname) > 0 {
+		preprocessor.SetDataValue("{{.nickname}}", nickname)
+		replyBuffer = makeJoinReplyBuffer(roomName, nickname, rooms, preprocessor)
+	} else if rooms.GetWaitingPosition(roomName, userData["ticket"]) > 0 {
+		replyBuffer = makeWaitingLineReplyBuffer(roomName, userData["ticket"], rooms, preprocessor)
+	} else {
+		replyBuffer = rawhttp.MakeReplyBuffer(html.GetBadAssErrorData(), 404, true)
+	}
+	newConn.Write(replyBuffer)
+	newConn.Close()
+}
+
+// makeJoinReplyBuffer assembles the room skeleton for a user that has just been added to the room and announces this user.
+func makeJoinReplyBuffer(roomName, nickname string, rooms *config.CherryRooms, preprocessor *html.Preprocessor) []byte {
+	var replyBuffer []byte
+	if rooms.IsUsingSessionCookie(roomName) {
+		cookie := "Set-Cookie: " + rooms.GetSessionCookieName(roomName) + "=" + rooms.GetSessionID(nickname, roomName) +
+			"; Path=/; HttpOnly; SameSite=Strict"
+		if rooms.IsUsingTLS(roomName) {
+			cookie += "; Secure"
+		}
+		replyBuffer = rawhttp.MakeReplyBufferWithHeaders(preprocessor.ExpandUserData(roomName, nickname, rooms.GetSkeletonTemplate(roomName)), 200, true, []string{cookie})
+	} else {
+		preprocessor.SetDataValue("{{.session-id}}", rooms.GetSessionID(nickname, roomName))
+		replyBuffer = rawhttp.MakeReplyBuffer(preprocessor.ExpandUserData(roomName, nickname, rooms.GetSkeletonTemplate(roomName)), 200, true)
+	}
+	rooms.EnqueueNotice(roomName, nickname, rooms.GetJoinMessage(roomName), "")
+	return replyBuffer
+}
+
+func makeRoomFullReplyBuffer(roomName string, rooms *config.CherryRooms, preprocessor *html.Preprocessor) []byte {
+	if !rooms.HasTemplate(roomName, "room-full") {
+		return rawhttp.MakeReplyBuffer(html.GetRoomFullData(), 200, true)
+	}
+	return rawhttp.MakeReplyBuffer(preprocessor.ExpandData(roomName, rooms.GetRoomFullTemplate(roomName)), 200, true)
+}
+
+func makeWaitingLineReplyBuffer(roomName, ticket string, rooms *config.CherryRooms, preprocessor *html.Preprocessor) []byte {
+	preprocessor.SetDataValue("{{.waiting-ticket}}", ticket)
+	preprocessor.SetDataValue("{{.waiting-position}}", fmt.Sprintf("%d", rooms.GetWaitingPosition(roomName, ticket)))
+	return rawhttp.MakeReplyBuffer(preprocessor.ExpandData(roomName, rooms.GetWaitingLineTemplate(roomName)), 200, true)
+}
+
 // GetBriefHandle implements the handle for the brief document (GET).
-func GetBriefHandle(newConn net.Conn, roomName, httpPayload string, rooms *config.CherryRooms, preprocessor *html.Preprocessor) {
+func GetBriefHandle(newConn net.Conn, roomName string, req *rawhttp.Request, rooms *config.CherryRooms, preprocessor *html.Preprocessor) {
 	var replyBuffer []byte
-	if rooms.IsAllowingBriefs(roomName) {
+	if rooms.IsAllowingBriefs(roomName) && !rooms.IsPrivate(roomName) {
 		replyBuffer = rawhttp.MakeReplyBuffer(preprocessor.ExpandData(roomName, rooms.GetBriefTemplate(roomName)), 200, true)
 	} else {
 		replyBuffer = rawhttp.MakeReplyBuffer(html.GetBadAssErrorData(), 404, true)
@@ -264,16 +438,17 @@ func GetBriefHandle(newConn net.Conn, roomName, httpPayload string, rooms *confi
 }
 
 // GetBodyHandle implements the handle for the body document (GET).
-func GetBodyHandle(newConn net.Conn, roomName, httpPayload string, rooms *config.CherryRooms, preprocessor *html.Preprocessor) {
+func GetBodyHandle(newConn net.Conn, roomName string, req *rawhttp.Request, rooms *config.CherryRooms, preprocessor *html.Preprocessor) {
 	var userData map[string]string
-	userData = rawhttp.GetFieldsFromGet(httpPayload)
+	userData = req.GetFieldsFromGet()
 	var validUser bool
-	validUser = rooms.IsValidUserRequest(roomName, userData["user"], userData["id"], newConn)
+	validUser = rooms.IsValidUserRequest(roomName, userData["user"], getSessionID(roomName, req, userData, rooms), newConn)
 	var replyBuffer []byte
 	if !validUser {
 		replyBuffer = rawhttp.MakeReplyBuffer(html.GetBadAssErrorData(), 404, true)
 	} else {
-		replyBuffer = rawhttp.MakeReplyBuffer(preprocessor.ExpandData(roomName, rooms.GetBodyTemplate(roomName)), 200, false)
+		replyBuffer = rawhttp.MakeReplyBuffer(preprocessor.ExpandUserData(roomName, userData["user"], rooms.GetBodyTemplate(roomName))+
+			getHistoryReplay(roomName, userData["user"], rooms), 200, false)
 	}
 	newConn.Write(replyBuffer)
 	if validUser {
@@ -283,18 +458,134 @@ func GetBodyHandle(newConn net.Conn, roomName, httpPayload string, rooms *config
 	}
 }
 
+// GetTranscriptHandle implements the handle for the transcript download (GET). The room's transcript-token
+// must be presented as "Authorization: Bearer <token>".
+func GetTranscriptHandle(newConn net.Conn, roomName string, req *rawhttp.Request, rooms *config.CherryRooms, preprocessor *html.Preprocessor) {
+	var replyBuffer []byte
+	userData := req.GetFieldsFromGet()
+	authorization := req.GetHeader("Authorization")
+	format := userData["format"]
+	if len(format) == 0 {
+		format = "html"
+	}
+	since, sinceErr := transcript.ParseTime(userData["since"])
+	until, untilErr := transcript.ParseTime(userData["until"])
+	if !rooms.IsServingTranscripts(roomName) {
+		replyBuffer = rawhttp.MakeReplyBuffer(html.GetBadAssErrorData(), 404, true)
+	} else if !strings.HasPrefix(authorization, "Bearer ") || !rooms.IsValidTranscriptToken(roomName, authorization[7:]) {
+		replyBuffer = rawhttp.MakeReplyBuffer(html.GetBadAssErrorData(), 403, true)
+	} else if sinceErr != nil || untilErr != nil || !transcript.IsValidFormat(format) {
+		replyBuffer = rawhttp.MakeReplyBuffer(html.GetBadAssErrorData(), 400, true)
+	} else {
+		entries, err := rooms.GetHistory().Range(roomName, since, until)
+		var data string
+		if err == nil {
+			data, err = transcript.Export(rooms, roomName, entries, format)
+		}
+		if err != nil {
+			fmt.Println("ERROR: unable to export the transcript of room \"" + roomName + "\" [more details: " + err.Error() + "].")
+			replyBuffer = rawhttp.MakeReplyBuffer(html.GetBadAssErrorData(), 500, true)
+		} else {
+			replyBuffer = rawhttp.MakeReplyBufferWithContentType(data, 200, transcript.GetContentType(format))
+		}
+	}
+	newConn.Write(replyBuffer)
+	newConn.Close()
+}
+
+// hookPost is what an incoming hook posts (as JSON or as a form).
+type hookPost struct {
+	Says  string `json:"says"`
+	Image string `json:"image"`
+	Sound string `json:"sound"`
+}
+
+// PostHookHandle implements the handle for the incoming hooks (POST). The token is taken from "/hook/<token>"
+// and the message is sent to everybody under the hook's nickname and action.
+func PostHookHandle(newConn net.Conn, roomName string, req *rawhttp.Request, rooms *config.CherryRooms, preprocessor *html.Preprocessor) {
+	var replyBuffer []byte
+	token := strings.TrimPrefix(req.Target, "/hook/")
+	nickname, action, isHook := rooms.GetIncomingHookSender(roomName, token)
+	post, postErr := getHookPost(req)
+	if !isHook {
+		replyBuffer = rawhttp.MakeReplyBuffer(html.GetBadAssErrorData(), 403, true)
+	} else if !rooms.AllowIncomingHookPost(roomName, token) {
+		replyBuffer = rawhttp.MakeReplyBuffer(html.GetBadAssErrorData(), 429, true)
+	} else if postErr != nil || (len(post.Says) == 0 && len(post.Image) == 0) ||
+		(len(post.Image) > 0 && !rooms.HasImage(roomName, post.Image)) ||
+		(len(post.Sound) > 0 && !rooms.HasSound(roomName, post.Sound)) {
+		replyBuffer = rawhttp.MakeReplyBuffer(html.GetBadAssErrorData(), 400, true)
+	} else {
+		rooms.EnqueueMessage(roomName, nickname, rooms.GetAllUsersAlias(roomName), action, post.Image, post.Sound, post.Says, "")
+		replyBuffer = rawhttp.MakeReplyBuffer("", 200, true)
+	}
+	newConn.Write(replyBuffer)
+	newConn.Close()
+}
+
+// getHookPost reads the body of an incoming hook post, a JSON object when the content type asks for it and
+// an url-encoded form otherwise.
+func getHookPost(req *rawhttp.Request) (hookPost, error) {
+	var post hookPost
+	if strings.HasPrefix(req.GetHeader("Content-Type"), "application/json") {
+		err := json.Unmarshal(req.Body, &post)
+		return post, err
+	}
+	fields := req.GetFieldsFromPost()
+	post.Says = fields["says"]
+	post.Image = fields["image"]
+	post.Sound = fields["sound"]
+	return post, nil
+}
+
+// getHistoryReplay returns the most recent public messages of a room, in order to give some context to who is entering.
+func getHistoryReplay(roomName, user string, rooms *config.CherryRooms) string {
+	store := rooms.GetHistory()
+	if store == nil {
+		return ""
+	}
+	entries, err := store.Last(roomName, rooms.GetHistoryReplay(roomName))
+	if err != nil {
+		fmt.Println("ERROR: unable to replay the history of room \"" + roomName + "\" [more details: " + err.Error() + "].")
+		return ""
+	}
+	var replay string
+	for _, entry := range entries {
+		if rooms.IsIgnored(user, entry.From, roomName) {
+			continue
+		}
+		replay += entry.Formatted
+	}
+	return replay
+}
+
+// GetEventsHandle implements the handle for the Server-Sent Events stream (GET).
+func GetEventsHandle(newConn net.Conn, roomName string, req *rawhttp.Request, rooms *config.CherryRooms, preprocessor *html.Preprocessor) {
+	var userData map[string]string
+	userData = req.GetFieldsFromGet()
+	if !rooms.IsValidUserRequest(roomName, userData["user"], getSessionID(roomName, req, userData, rooms), newConn) {
+		newConn.Write(rawhttp.MakeReplyBuffer(html.GetBadAssErrorData(), 404, true))
+		newConn.Close()
+		return
+	}
+	var lastEventID uint64
+	lastEventID, _ = strconv.ParseUint(req.GetHeader("Last-Event-ID"), 10, 64)
+	newConn.Write(rawhttp.MakeEventStreamReplyBuffer())
+	rooms.SetUserConnection(roomName, userData["user"], rawhttp.NewEventStreamConn(newConn, lastEventID))
+}
+
 // BadAssErrorHandle implements the handle for the any unexpected HTTP request (GET/POST/Whatever).
-func BadAssErrorHandle(newConn net.Conn, roomName, httpPayload string, rooms *config.CherryRooms, preprocessor *html.Preprocessor) {
+func BadAssErrorHandle(newConn net.Conn, roomName string, req *rawhttp.Request, rooms *config.CherryRooms, preprocessor *html.Preprocessor) {
 	newConn.Write(rawhttp.MakeReplyBuffer(html.GetBadAssErrorData(), 404, true))
 	newConn.Close()
 }
 
 // PostBannerHandle implements the handle for the banner document (POST).
-func PostBannerHandle(newConn net.Conn, roomName, httpPayload string, rooms *config.CherryRooms, preprocessor *html.Preprocessor) {
+func PostBannerHandle(newConn net.Conn, roomName string, req *rawhttp.Request, rooms *config.CherryRooms, preprocessor *html.Preprocessor) {
 	var userData map[string]string
 	var replyBuffer []byte
 	var invalidRequest = false
-	userData = rawhttp.GetFieldsFromPost(httpPayload)
+	userData = req.GetFieldsFromPost()
 	if _, has := userData["user"]; !has {
 		invalidRequest = true
 	} else if _, has := userData["id"]; !has {
@@ -309,33 +600,17 @@ func PostBannerHandle(newConn net.Conn, roomName, httpPayload string, rooms *con
 		invalidRequest = true
 	}
 	var restoreBanner = true
-	if invalidRequest || !rooms.IsValidUserRequest(roomName, userData["user"], userData["id"], newConn) {
+	if invalidRequest || !rooms.IsValidUserRequest(roomName, userData["user"], getSessionID(roomName, req, userData, rooms), newConn) {
 		replyBuffer = rawhttp.MakeReplyBuffer(html.GetBadAssErrorData(), 404, true)
-	} else if userData["action"] == rooms.GetIgnoreAction(roomName) {
-		if userData["user"] != userData["whoto"] && !rooms.IsIgnored(userData["user"], userData["whoto"], roomName) {
-			rooms.AddToIgnoreList(userData["user"], userData["whoto"], roomName)
-			rooms.EnqueueMessage(roomName, userData["user"], "", "", "", rooms.GetOnIgnoreMessage(roomName)+userData["whoto"], "1")
-			restoreBanner = false
-		}
-	} else if userData["action"] == rooms.GetDeIgnoreAction(roomName) {
-		if rooms.IsIgnored(userData["user"], userData["whoto"], roomName) {
-			rooms.DelFromIgnoreList(userData["user"], userData["whoto"], roomName)
-			rooms.EnqueueMessage(roomName, userData["user"], "", "", "", rooms.GetOnDeIgnoreMessage(roomName)+userData["whoto"], "1")
-			restoreBanner = false
-		}
 	} else {
-		var somethingToSay = (len(userData["says"]) > 0 || len(userData["image"]) > 0 || len(userData["sound"]) > 0)
-		if somethingToSay {
-			//  INFO(Santiago): Any further antiflood control would go from here.
-			rooms.EnqueueMessage(roomName, userData["user"], userData["whoto"], userData["action"], userData["image"], userData["says"], userData["priv"])
-		}
+		restoreBanner = processUserPost(roomName, userData, rooms)
 	}
-	preprocessor.SetDataValue("{{.nickname}}", userData["user"])
-	preprocessor.SetDataValue("{{.session-id}}", userData["id"])
+	preprocessor.SetDataValue("{{.nickname}}", html.Escape(userData["user"]))
+	preprocessor.SetDataValue("{{.session-id}}", html.Escape(userData["id"]))
 	if userData["priv"] == "1" {
 		preprocessor.SetDataValue("{{.priv}}", "checked")
 	}
-	tempBanner := preprocessor.ExpandData(roomName, rooms.GetBannerTemplate(roomName))
+	tempBanner := preprocessor.ExpandUserData(roomName, userData["user"], rooms.GetBannerTemplate(roomName))
 	if restoreBanner {
 		tempBanner = strings.Replace(tempBanner,
 			"<option value = \""+userData["whoto"]+"\">",
@@ -348,3 +623,146 @@ func PostBannerHandle(newConn net.Conn, roomName, httpPayload string, rooms *con
 	newConn.Write(replyBuffer)
 	newConn.Close()
 }
+
+// processUserPost handles the data posted by a user (through the banner or the WebSocket) and
+// returns "true" when the banner should restore the previous user choices.
+func processUserPost(roomName string, userData map[string]string, rooms *config.CherryRooms) bool {
+	var restoreBanner = true
+	if isSlashCommand(userData["says"]) {
+		post := make(map[string]string)
+		for field, value := range userData {
+			post[field] = value
+		}
+		handled := runSlashCommand(roomName, post, rooms)
+		//  INFO(Santiago): "/nick" changes who is posting, the banner must follow it.
+		userData["user"] = post["user"]
+		if handled {
+			return restoreBanner
+		}
+		userData = post
+	} else if strings.HasPrefix(userData["says"], "//") {
+		userData["says"] = userData["says"][1:]
+	}
+	if userData["action"] == rooms.GetIgnoreAction(roomName) {
+		if ignoreUser(roomName, userData["user"], userData["whoto"], rooms) {
+			restoreBanner = false
+		}
+	} else if userData["action"] == rooms.GetDeIgnoreAction(roomName) {
+		if deignoreUser(roomName, userData["user"], userData["whoto"], rooms) {
+			restoreBanner = false
+		}
+	} else if rooms.IsModerationAction(roomName, userData["action"]) {
+		if moderate(roomName, userData["user"], userData["action"], userData["whoto"], rooms) {
+			restoreBanner = false
+		}
+	} else {
+		var somethingToSay = (len(userData["says"]) > 0 || len(userData["image"]) > 0 || len(userData["sound"]) > 0)
+		if somethingToSay && policeFlood(roomName, userData["user"], rooms) {
+			var sound string
+			if rooms.HasSound(roomName, userData["sound"]) {
+				sound = userData["sound"]
+			}
+			rooms.EnqueueMessage(roomName, userData["user"], userData["whoto"], userData["action"], userData["image"], sound, userData["says"], userData["priv"])
+		}
+	}
+	return restoreBanner
+}
+
+// policeFlood applies the flooding police verdict about a new post of @user. It returns "true" when the post is allowed.
+func policeFlood(roomName, user string, rooms *config.CherryRooms) bool {
+	if rooms.IsMuted(roomName, user) {
+		tellUser(roomName, user, "you were muted by a moderator, wait a little.", rooms)
+		return false
+	}
+	switch rooms.PoliceFlood(roomName, user) {
+	case config.FloodAllowed:
+		return true
+
+	case config.FloodWarned:
+		rooms.EnqueueNotice(roomName, user, rooms.GetFloodWarningMessage(roomName), "1")
+		break
+
+	case config.FloodMuted:
+		rooms.EnqueueNotice(roomName, user, rooms.GetFloodMuteMessage(roomName), "1")
+		break
+
+	case config.FloodKicked:
+		kickOut(roomName, user, rooms.GetFloodKickMessage(roomName), rooms)
+		break
+	}
+	return false
+}
+
+// ignoreUser adds @whoto to the ignore list of @user and confirms it. It returns "false" when nothing was done.
+func ignoreUser(roomName, user, whoto string, rooms *config.CherryRooms) bool {
+	if user == whoto || rooms.IsIgnored(user, whoto, roomName) {
+		return false
+	}
+	rooms.AddToIgnoreList(user, whoto, roomName)
+	rooms.EnqueueNotice(roomName, user, rooms.GetOnIgnoreMessage(roomName)+html.Escape(whoto), "1")
+	return true
+}
+
+// deignoreUser removes @whoto from the ignore list of @user and confirms it. It returns "false" when nothing was done.
+func deignoreUser(roomName, user, whoto string, rooms *config.CherryRooms) bool {
+	if !rooms.IsIgnored(user, whoto, roomName) {
+		return false
+	}
+	rooms.DelFromIgnoreList(user, whoto, roomName)
+	rooms.EnqueueNotice(roomName, user, rooms.GetOnDeIgnoreMessage(roomName)+html.Escape(whoto), "1")
+	return true
+}
+
+// kickOut announces that a user was kicked out, drops the connection and removes the user from the room.
+func kickOut(roomName, user, message string, rooms *config.CherryRooms) {
+	rooms.EnqueueNotice(roomName, user, message, "")
+	conn := rooms.GetUserConnection(roomName, user)
+	rooms.RemoveUser(roomName, user)
+	if conn != nil {
+		conn.Close()
+	}
+}
+
+// GetWebSocketHandle implements the handle for the WebSocket transport (GET). Once upgraded, the connection
+// carries the same formatted messages delivered through the body document and also accepts the user posts.
+func GetWebSocketHandle(newConn net.Conn, roomName string, req *rawhttp.Request, rooms *config.CherryRooms, preprocessor *html.Preprocessor) {
+	var userData map[string]string
+	userData = req.GetFieldsFromGet()
+	if !websocket.IsUpgradeRequest(req) || !rooms.IsValidUserRequest(roomName, userData["user"], getSessionID(roomName, req, userData, rooms), newConn) {
+		newConn.Write(rawhttp.MakeReplyBuffer(html.GetBadAssErrorData(), 404, true))
+		newConn.Close()
+		return
+	}
+	newConn.Write(websocket.MakeHandshakeReplyBuffer(req))
+	wsConn := websocket.NewConn(newConn)
+	rooms.SetUserConnection(roomName, userData["user"], wsConn)
+	for {
+		data, err := wsConn.ReadMessage()
+		if err != nil {
+			break
+		}
+		//  INFO(Santiago): The posts are url-encoded exactly as the banner form does.
+		postData := rawhttp.GetFieldsFromForm(string(data))
+		postData["user"] = userData["user"]
+		if _, has := postData["whoto"]; !has {
+			postData["whoto"] = rooms.GetAllUsersAlias(roomName)
+		}
+		if !rooms.IsValidUserRequest(roomName, userData["user"], getSessionID(roomName, req, userData, rooms), newConn) {
+			break
+		}
+		processUserPost(roomName, postData, rooms)
+		userData["user"] = postData["user"]
+	}
+	wsConn.Close()
+}
+
+// getSessionID returns the session ID sent by the user (through the request fields or through the room's session cookie).
+func getSessionID(roomName string, req *rawhttp.Request, userData map[string]string, rooms *config.CherryRooms) string {
+	if id := userData["id"]; len(id) > 0 {
+		return id
+	}
+	if rooms.IsUsingSessionCookie(roomName) {
+		return req.GetCookie(rooms.GetSessionCookieName(roomName))
+	}
+	return ""
+}
@@ -0,0 +1,143 @@
+/*
+Package search implements the full-text search over the public messages of the rooms.
+--
+ *                               Copyright (C) 2015 by Rafael Santiago
+ *
+ * This is a free software. You can redistribute it and/or modify under
+ * the terms of the GNU General Public License version 2.
+ *
+*/
+package search
+
+import (
+	"pkg/history"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// Result is a message found by a search.
+type Result struct {
+	Room  string
+	Entry history.Entry
+}
+
+// Query gathers the filters of a search. Empty fields do not filter anything.
+type Query struct {
+	Room   string
+	From   string
+	Phrase string
+	Since  time.Time
+	Until  time.Time
+	Limit  int
+}
+
+// Index is an inverted index of the public messages, each word points to the messages that contain it.
+type Index struct {
+	mutex    *sync.RWMutex
+	results  []Result
+	postings map[string][]int
+	rooms    map[string]bool
+}
+
+// NewIndex creates an empty index.
+func NewIndex() *Index {
+	return &Index{new(sync.RWMutex), make([]Result, 0), make(map[string][]int), make(map[string]bool)}
+}
+
+// HasRoom verifies if some message of a room was already indexed.
+func (i *Index) HasRoom(roomName string) bool {
+	i.mutex.RLock()
+	defer i.mutex.RUnlock()
+	return i.rooms[roomName]
+}
+
+var markupTag = regexp.MustCompile(`<[^>]*>`)
+
+// Tokenize splits a text into its (lower case) words, the markup tags are not words.
+func Tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(markupTag.ReplaceAllString(text, " ")), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// Add indexes a message of a room. The notices (written by the server) are not indexed.
+func (i *Index) Add(roomName string, entry history.Entry) {
+	if entry.Notice {
+		return
+	}
+	i.mutex.Lock()
+	id := len(i.results)
+	i.results = append(i.results, Result{roomName, entry})
+	i.rooms[roomName] = true
+	for _, token := range Tokenize(entry.Say) {
+		postings := i.postings[token]
+		if len(postings) > 0 && postings[len(postings)-1] == id {
+			continue
+		}
+		i.postings[token] = append(postings, id)
+	}
+	i.mutex.Unlock()
+}
+
+// Search returns the messages that match the query, the most recent comes first. When the query has a phrase,
+// all its words must be found in this order.
+func (i *Index) Search(query Query) []Result {
+	i.mutex.RLock()
+	defer i.mutex.RUnlock()
+	tokens := Tokenize(query.Phrase)
+	var candidates []int
+	if len(tokens) > 0 {
+		candidates = i.postings[tokens[0]]
+		for _, token := range tokens[1:] {
+			candidates = intersect(candidates, i.postings[token])
+		}
+	}
+	phrase := " " + strings.Join(tokens, " ") + " "
+	var found []Result
+	consider := func(id int) bool {
+		result := i.results[id]
+		if (len(query.Room) > 0 && result.Room != query.Room) ||
+			(len(query.From) > 0 && !strings.EqualFold(result.Entry.From, query.From)) ||
+			(!query.Since.IsZero() && result.Entry.Time.Before(query.Since)) ||
+			(!query.Until.IsZero() && result.Entry.Time.After(query.Until)) ||
+			(len(tokens) > 1 && !strings.Contains(" "+strings.Join(Tokenize(result.Entry.Say), " ")+" ", phrase)) {
+			return true
+		}
+		found = append(found, result)
+		return query.Limit <= 0 || len(found) < query.Limit
+	}
+	if len(tokens) > 0 {
+		for c := len(candidates) - 1; c >= 0 && consider(candidates[c]); c-- {
+		}
+	} else {
+		for id := len(i.results) - 1; id >= 0 && consider(id); id-- {
+		}
+	}
+	return found
+}
+
+// intersect returns the ids present in both (sorted) posting lists.
+func intersect(a, b []int) []int {
+	var ids []int
+	for x, y := 0, 0; x < len(a) && y < len(b); {
+		switch {
+		case a[x] == b[y]:
+			ids = append(ids, a[x])
+			x++
+			y++
+			break
+
+		case a[x] < b[y]:
+			x++
+			break
+
+		default:
+			y++
+			break
+		}
+	}
+	return ids
+}
@@ -0,0 +1,172 @@
+/*
+ *                                Copyright (C) 2016 by Rafael Santiago
+ *
+ * This is a free software. You can redistribute it and/or modify under
+ * the terms of the GNU General Public License version 2.
+ *
+ */
+package cherry_test
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"pkg/bans"
+	"pkg/config"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBanList(t *testing.T) {
+	directory, err := ioutil.TempDir("", "cherry-bans")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(directory)
+	banFile := filepath.Join(directory, "sample.cherry.bans")
+	list, err := bans.Load(banFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ban := range []bans.Ban{{Room: "aliens-on-earth", Nickname: "agent*"},
+		{Room: "aliens-on-earth", Address: "10.1.2.3"},
+		{Room: "aliens-on-earth", Address: "192.168.0.0/16", Until: time.Now().Add(time.Hour)},
+		{Room: "aliens-on-earth", Address: "2001:db8::/32"},
+		{Room: "aliens-on-earth", Nickname: "dunha", Until: time.Now().Add(-time.Hour)}} {
+		if err = list.Add(ban); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = list.Add(bans.Ban{Room: "aliens-on-earth", Address: "10.1.2.300"}); err == nil {
+		t.Error("invalid address accepted.")
+	}
+
+	list, err = bans.Load(banFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testVector := []struct {
+		nickname, addr string
+		banned         bool
+	}{
+		{"agent smith", "", true},
+		{"Agent Smith", "", true},
+		{"AGENT SMITH", "", true},
+		{"mulder", "10.1.2.3", true},
+		{"mulder", "192.168.4.2", true},
+		{"mulder", "192.169.4.2", false},
+		{"mulder", "2001:db8::1", true},
+		{"mulder", "2001:db9::1", false},
+		{"dunha", "", false},
+	}
+	for _, test := range testVector {
+		if list.IsBanned("aliens-on-earth", test.nickname, test.addr) != test.banned {
+			t.Errorf("%s@%s: banned should be %v.", test.nickname, test.addr, test.banned)
+		}
+	}
+	if list.IsBanned("cows-on-earth", "agent smith", "10.1.2.3") || len(list.Get("aliens-on-earth")) != 4 {
+		t.Error("the bans are leaking.")
+	}
+	if lifted, _ := list.Remove("aliens-on-earth", "agent*"); lifted != 1 || list.IsBanned("aliens-on-earth", "agent smith", "") {
+		t.Error("the ban was not lifted.")
+	}
+
+	ioutil.WriteFile(banFile, []byte("{\"room\":\"aliens-on-earth\",\"address\":\"earth\"}\n"), 0600)
+	if err = list.Reload(); err == nil || !list.IsBanned("aliens-on-earth", "mulder", "10.1.2.3") {
+		t.Error("a broken ban file was loaded.")
+	}
+}
+
+func TestBans(t *testing.T) {
+	rooms := config.NewCherryRooms()
+	rooms.AddRoom("aliens-on-earth", 1024)
+	rooms.SetAllUsersAlias("aliens-on-earth", "all")
+	rooms.AddAction("aliens-on-earth", "a01", "talks to", "")
+	rooms.AddAction("aliens-on-earth", "a07", "BAN", "")
+	rooms.SetBanAction("aliens-on-earth", "a07")
+	rooms.AddModerator("aliens-on-earth", "mulder", hashPassword(t, "the truth is out there"))
+	rooms.AddUser("aliens-on-earth", "mulder", "0", false)
+	rooms.AddUser("aliens-on-earth", "agent smith", "0", false)
+	rooms.AddUser("aliens-on-earth", "dunha", "0", false)
+
+	postBanner(t, rooms, "dunha", "/ban agent*")
+	if message := nextMessage(rooms); !message.Notice || message.Priv != "1" || !rooms.HasUser("aliens-on-earth", "agent smith") {
+		t.Errorf("/ban by a user: %+v", message)
+	}
+
+	postBanner(t, rooms, "mulder", "/ban agent* 1h")
+	nextMessage(rooms)
+	if message := nextMessage(rooms); message.From != "agent smith" || rooms.HasUser("aliens-on-earth", "agent smith") {
+		t.Errorf("/ban agent*: %+v", message)
+	}
+	if banned := rooms.GetBans().Get("aliens-on-earth"); len(banned) != 1 || banned[0].Until.IsZero() || banned[0].By != "mulder" {
+		t.Errorf("/ban agent*: %+v", banned)
+	}
+
+	postBanner(t, rooms, "mulder", "/bans")
+	if message := nextMessage(rooms); !strings.Contains(message.Say, "agent*") {
+		t.Errorf("/bans: %+v", message)
+	}
+
+	postBanner(t, rooms, "mulder", "/unban agent*")
+	nextMessage(rooms)
+	if rooms.IsBanned("aliens-on-earth", "agent smith", "") {
+		t.Error("/unban has not lifted the ban.")
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	client, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	postBanner(t, rooms, "mulder", "/ban 127.0.0.0/8")
+	nextMessage(rooms)
+	if rooms.IsValidUserRequest("aliens-on-earth", "dunha", rooms.GetSessionID("dunha", "aliens-on-earth"), conn) {
+		t.Error("a request from a banned address was accepted.")
+	}
+	if !rooms.IsValidUserRequest("aliens-on-earth", "mulder", rooms.GetSessionID("mulder", "aliens-on-earth"), conn) {
+		t.Error("a moderator was kept out.")
+	}
+
+	postBanner(t, rooms, "mulder", "/unban 127.0.0.0/8")
+	nextMessage(rooms)
+
+	listener6, err := net.Listen("tcp", "[::1]:0")
+	if err != nil {
+		t.Skip("no IPv6 loopback: ", err)
+	}
+	defer listener6.Close()
+	client6, err := net.Dial("tcp", listener6.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client6.Close()
+	conn6, err := listener6.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn6.Close()
+	if config.GetRemoteAddr(conn6) != "::1" {
+		t.Errorf("unexpected IPv6 address: %s", config.GetRemoteAddr(conn6))
+	}
+	if !rooms.IsValidUserRequest("aliens-on-earth", "dunha", rooms.GetSessionID("dunha", "aliens-on-earth"), conn6) {
+		t.Error("a request from an address not banned was refused.")
+	}
+	postBanner(t, rooms, "mulder", "/ban ::/64")
+	nextMessage(rooms)
+	if rooms.IsValidUserRequest("aliens-on-earth", "dunha", rooms.GetSessionID("dunha", "aliens-on-earth"), conn6) {
+		t.Error("a request from a banned IPv6 address was accepted.")
+	}
+}
@@ -0,0 +1,107 @@
+/*
+ *                                Copyright (C) 2016 by Rafael Santiago
+ *
+ * This is a free software. You can redistribute it and/or modify under
+ * the terms of the GNU General Public License version 2.
+ *
+ */
+package cherry_test
+
+import (
+	"pkg/bots"
+	"pkg/config"
+	"strings"
+	"testing"
+	"time"
+)
+
+type watcherBot struct {
+	seen chan string
+}
+
+func (w *watcherBot) OnMessage(room *bots.Room, message config.Message) {
+	w.seen <- "message " + message.From + " " + message.Say
+}
+
+func (w *watcherBot) OnJoin(room *bots.Room, user string) {
+	w.seen <- "join " + user
+}
+
+func (w *watcherBot) OnLeave(room *bots.Room, user string) {
+	w.seen <- "leave " + user
+}
+
+func waitForBotMessage(rooms *config.CherryRooms, from string) (config.Message, bool) {
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if message := rooms.GetNextMessage("aliens-on-earth"); message.From == from {
+			rooms.DequeueMessage("aliens-on-earth")
+			return message, true
+		}
+	}
+	return config.Message{}, false
+}
+
+func TestFAQBot(t *testing.T) {
+	faq, err := bots.ParseFAQ("# commentary\nleader = Take me to your leader!\n\nleader name = His name is Dunha.\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	testVector := map[string]string{
+		"Who is your leader?":          "Take me to your leader!",
+		"What is the LEADER's name?":   "His name is Dunha.",
+		"Is there life on earth?":      "",
+		"<b>leader</b> of the planet?": "Take me to your leader!",
+	}
+	for question, answer := range testVector {
+		if faq.Answer(question) != answer {
+			t.Errorf("\"%s\" was answered with \"%s\".", question, faq.Answer(question))
+		}
+	}
+	if _, err := bots.ParseFAQ("leader Take me to your leader!"); err == nil {
+		t.Error("invalid entry accepted.")
+	}
+	if _, err := bots.New("martian", ""); err == nil {
+		t.Error("unknown kind accepted.")
+	}
+}
+
+func TestBots(t *testing.T) {
+	rooms := config.NewCherryRooms()
+	rooms.AddRoom("aliens-on-earth", 1024)
+	rooms.SetAllUsersAlias("aliens-on-earth", "all")
+	echo, _ := bots.New("echo", "")
+	bots.Attach(rooms, "aliens-on-earth", "echo", echo)
+	watcher := &watcherBot{make(chan string, 16)}
+	bots.Attach(rooms, "aliens-on-earth", "watcher", watcher)
+	if !rooms.HasBot("aliens-on-earth", "echo") || !strings.Contains(rooms.GetUsersList("aliens-on-earth"), "\"echo\"") {
+		t.Fail()
+	}
+
+	rooms.AddUser("aliens-on-earth", "dunha", "0", false)
+	rooms.NotifyObservers("aliens-on-earth", config.RoomEvent{Kind: config.MessageDelivered,
+		Message: config.Message{From: "dunha", To: "echo", Say: "ping", Priv: "1"}})
+	rooms.NotifyObservers("aliens-on-earth", config.RoomEvent{Kind: config.MessageDelivered,
+		Message: config.Message{From: "dunha", To: "mulder", Say: "psst", Priv: "1"}})
+	rooms.NotifyObservers("aliens-on-earth", config.RoomEvent{Kind: config.MessageDelivered,
+		Message: config.Message{From: "echo", To: "all", Say: "ping"}})
+	rooms.RemoveUser("aliens-on-earth", "dunha")
+
+	if message, ok := waitForBotMessage(rooms, "echo"); !ok || message.To != "dunha" || message.Say != "ping" || message.Priv != "1" {
+		t.Errorf("echo: %+v", message)
+	}
+	for _, expected := range []string{"join dunha", "leave dunha"} {
+		select {
+		case seen := <-watcher.seen:
+			if seen != expected {
+				t.Errorf("\"%s\" was seen instead of \"%s\".", seen, expected)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("\"%s\" was not seen.", expected)
+		}
+	}
+	select {
+	case seen := <-watcher.seen:
+		t.Errorf("\"%s\" should not be seen.", seen)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
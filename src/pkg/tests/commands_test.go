@@ -0,0 +1,153 @@
+/*
+ *                                Copyright (C) 2016 by Rafael Santiago
+ *
+ * This is a free software. You can redistribute it and/or modify under
+ * the terms of the GNU General Public License version 2.
+ *
+ */
+package cherry_test
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"pkg/config"
+	"pkg/html"
+	"pkg/rawhttp"
+	"pkg/reqtraps"
+	"strings"
+	"testing"
+	"time"
+)
+
+func postBanner(t *testing.T, rooms *config.CherryRooms, user, says string) string {
+	return postBannerAction(t, rooms, user, "a01", "all", says)
+}
+
+func postBannerAction(t *testing.T, rooms *config.CherryRooms, user, action, whoto, says string) string {
+	body := url.Values{"user": {user}, "id": {rooms.GetSessionID(user, "aliens-on-earth")}, "action": {action},
+		"whoto": {whoto}, "image": {""}, "sound": {""}, "says": {says}}.Encode()
+	return postForm(t, rooms, "/banner", reqtraps.PostBannerHandle, body)
+}
+
+func postForm(t *testing.T, rooms *config.CherryRooms, target string, handle reqtraps.RequestTrapHandleFunc, body string) string {
+	payload := fmt.Sprintf("POST %s HTTP/1.1\r\nContent-Length: %d\r\n\r\n%s", target, len(body), body)
+	req, err := rawhttp.ReadRequest(bufio.NewReader(strings.NewReader(payload)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, peer := net.Pipe()
+	go handle(conn, "aliens-on-earth", req, rooms, html.NewHTMLPreprocessor(rooms))
+	reply, _ := ioutil.ReadAll(peer)
+	peer.Close()
+	return string(reply)
+}
+
+func nextMessage(rooms *config.CherryRooms) config.Message {
+	message := rooms.GetNextMessage("aliens-on-earth")
+	rooms.DequeueMessage("aliens-on-earth")
+	return message
+}
+
+func TestSlashCommands(t *testing.T) {
+	rooms := config.NewCherryRooms()
+	rooms.AddRoom("aliens-on-earth", 1024)
+	rooms.SetAllUsersAlias("aliens-on-earth", "all")
+	rooms.AddAction("aliens-on-earth", "a01", "talks to", "")
+	rooms.AddUser("aliens-on-earth", "dunha", "0", false)
+	rooms.AddUser("aliens-on-earth", "agent smith", "0", false)
+
+	postBanner(t, rooms, "dunha", "/me is landing")
+	if message := nextMessage(rooms); message.Action != config.MeAction || message.Say != "is landing" || message.Priv == "1" {
+		t.Errorf("/me: %+v", message)
+	}
+
+	postBanner(t, rooms, "dunha", "/msg agent smith we come in peace")
+	if message := nextMessage(rooms); message.To != "agent smith" || message.Say != "we come in peace" || message.Priv != "1" {
+		t.Errorf("/msg: %+v", message)
+	}
+
+	postBanner(t, rooms, "dunha", "/msg nobody hello?")
+	if message := nextMessage(rooms); !message.Notice || message.From != "dunha" || message.Priv != "1" {
+		t.Errorf("/msg to nobody: %+v", message)
+	}
+
+	postBanner(t, rooms, "dunha", "/ignore agent smith")
+	nextMessage(rooms)
+	if !rooms.IsIgnored("dunha", "agent smith", "aliens-on-earth") {
+		t.Error("/ignore has not ignored.")
+	}
+
+	reply := postBanner(t, rooms, "dunha", "/nick mulder")
+	nextMessage(rooms)
+	if rooms.HasUser("aliens-on-earth", "dunha") || !rooms.HasUser("aliens-on-earth", "mulder") ||
+		!rooms.IsIgnored("mulder", "agent smith", "aliens-on-earth") || !strings.Contains(reply, "200 OK") {
+		t.Error("/nick has not renamed.")
+	}
+
+	postBanner(t, rooms, "agent smith", "/nick mulder")
+	if message := nextMessage(rooms); message.From != "agent smith" || message.Priv != "1" ||
+		!rooms.HasUser("aliens-on-earth", "agent smith") {
+		t.Errorf("/nick to a taken nickname: %+v", message)
+	}
+
+	postBanner(t, rooms, "mulder", "/unignore agent smith")
+	nextMessage(rooms)
+	if rooms.IsIgnored("mulder", "agent smith", "aliens-on-earth") {
+		t.Error("/unignore has not deignored.")
+	}
+
+	postBanner(t, rooms, "mulder", "/topic <b>the truth</b>")
+	if message := nextMessage(rooms); rooms.GetTopic("aliens-on-earth") != "<b>the truth</b>" ||
+		!strings.Contains(message.Say, "&lt;b&gt;the truth&lt;/b&gt;") || message.Priv == "1" {
+		t.Errorf("/topic: %+v", message)
+	}
+
+	postBanner(t, rooms, "mulder", "/who")
+	if message := nextMessage(rooms); !strings.Contains(message.Say, "agent smith, mulder.") || message.Priv != "1" {
+		t.Errorf("/who: %+v", message)
+	}
+
+	postBanner(t, rooms, "mulder", "/abduct agent smith")
+	if message := nextMessage(rooms); !message.Notice || !strings.Contains(message.Say, "/abduct") {
+		t.Errorf("unknown command: %+v", message)
+	}
+
+	for says, expected := range map[string]string{"/usr/bin is out there": "/usr/bin is out there", "//me is not a command": "/me is not a command"} {
+		postBanner(t, rooms, "mulder", says)
+		if message := nextMessage(rooms); message.Notice || message.Say != expected {
+			t.Errorf("%s: %+v", says, message)
+		}
+	}
+}
+
+func TestRenamedUserDisconnection(t *testing.T) {
+	rooms := config.NewCherryRooms()
+	rooms.AddRoom("aliens-on-earth", 1024)
+	rooms.SetAllUsersAlias("aliens-on-earth", "all")
+	rooms.SetExitMessage("aliens-on-earth", "has left...")
+	rooms.AddAction("aliens-on-earth", "a01", "talks to", "")
+	rooms.AddUser("aliens-on-earth", "dunha", "0", false)
+	conn, peer := net.Pipe()
+	rooms.SetUserConnection("aliens-on-earth", "dunha", conn)
+
+	postBanner(t, rooms, "dunha", "/nick mulder")
+	nextMessage(rooms)
+	if !rooms.HasUser("aliens-on-earth", "mulder") {
+		t.Fatal("/nick has not renamed.")
+	}
+	//  INFO(Santiago): The writer of the connection must find the user under the new nickname.
+	peer.Close()
+	rooms.SendToUser("aliens-on-earth", "mulder", []byte("boo!"))
+	for i := 0; i < 100 && rooms.HasUser("aliens-on-earth", "mulder"); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if rooms.HasUser("aliens-on-earth", "mulder") {
+		t.Fatal("a renamed user was not removed after disconnecting.")
+	}
+	if message := nextMessage(rooms); message.From != "mulder" || message.Say != "has left..." {
+		t.Errorf("unexpected exit: %+v", message)
+	}
+}
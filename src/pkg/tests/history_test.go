@@ -0,0 +1,126 @@
+/*
+ *                                Copyright (C) 2016 by Rafael Santiago
+ *
+ * This is a free software. You can redistribute it and/or modify under
+ * the terms of the GNU General Public License version 2.
+ *
+ */
+package cherry_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"pkg/config"
+	"pkg/history"
+	"testing"
+	"time"
+)
+
+func TestFileStore(t *testing.T) {
+	directory := t.TempDir()
+	store, err := history.NewFileStore(directory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entries, err := store.Last("aliens-on-earth", 10); err != nil || len(entries) != 0 {
+		t.Error("an empty history should not have entries")
+	}
+	when := time.Date(1947, time.July, 8, 9, 5, 3, 0, time.UTC)
+	for e := 0; e < 15; e++ {
+		if err := store.Append("aliens-on-earth", history.Entry{Time: when, From: "dunha", Say: fmt.Sprintf("%d", e)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	store.Append("backyard-science", history.Entry{From: "mulder", Say: "The truth is out there."})
+	store.Close()
+	//  INFO(Santiago): A broken line must not spoil the history.
+	file, _ := os.OpenFile(filepath.Join(directory, "aliens-on-earth.history"), os.O_WRONLY|os.O_APPEND, 0600)
+	file.Write([]byte("{\"from\":\"du"))
+	file.Close()
+	store, _ = history.NewFileStore(directory)
+	defer store.Close()
+	entries, err := store.Last("aliens-on-earth", 10)
+	if err != nil || len(entries) != 10 {
+		t.Fatalf("unexpected history: %v, %v", entries, err)
+	}
+	for e, entry := range entries {
+		if entry.Say != fmt.Sprintf("%d", e+5) || entry.From != "dunha" || !entry.Time.Equal(when) {
+			t.Errorf("unexpected entry: %v", entry)
+		}
+	}
+	if entries, _ = store.Last("backyard-science", 10); len(entries) != 1 || entries[0].From != "mulder" {
+		t.Error("the rooms must not share their histories")
+	}
+	if entries, _ = store.Last("aliens-on-earth", 0); len(entries) != 0 {
+		t.Error("nothing should be returned")
+	}
+}
+
+func TestRecordMessage(t *testing.T) {
+	rooms := config.NewCherryRooms()
+	rooms.AddRoom("aliens-on-earth", 1024)
+	rooms.RecordMessage("aliens-on-earth", config.Message{From: "dunha", Say: "hi!"}, "<p>dunha: hi!")
+	store, err := history.NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	rooms.SetHistory(store)
+	rooms.RecordMessage("aliens-on-earth", config.Message{From: "dunha", Action: "a01", Say: "hello!", Time: time.Now()}, "<p>dunha: hello!")
+	entries, err := store.Last("aliens-on-earth", 10)
+	if err != nil || len(entries) != 1 || entries[0].Formatted != "<p>dunha: hello!" || entries[0].Action != "a01" {
+		t.Errorf("unexpected history: %v, %v", entries, err)
+	}
+}
+
+func TestFileStoreRange(t *testing.T) {
+	store, err := history.NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	when := time.Date(1947, time.July, 8, 9, 0, 0, 0, time.UTC)
+	for e := 0; e < 5; e++ {
+		store.Append("aliens-on-earth", history.Entry{Time: when.Add(time.Duration(e) * time.Hour), From: "dunha", Say: fmt.Sprintf("%d", e)})
+	}
+	if entries, err := store.Range("aliens-on-earth", time.Time{}, time.Time{}); err != nil || len(entries) != 5 {
+		t.Error("the whole history was expected")
+	}
+	entries, err := store.Range("aliens-on-earth", when.Add(time.Hour), when.Add(3*time.Hour))
+	if err != nil || len(entries) != 3 || entries[0].Say != "1" || entries[2].Say != "3" {
+		t.Errorf("unexpected range: %v, %v", entries, err)
+	}
+}
+
+func TestFileStoreLast(t *testing.T) {
+	directory := t.TempDir()
+	store, err := history.NewFileStore(directory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	//  INFO(Santiago): Enough entries to make the end of the file be read in more than one chunk.
+	for e := 0; e < 2000; e++ {
+		store.Append("aliens-on-earth", history.Entry{From: "dunha", Say: fmt.Sprintf("%d", e), Formatted: fmt.Sprintf("<p>%0100d", e)})
+	}
+	checkLast := func(n, first int) {
+		entries, err := store.Last("aliens-on-earth", n)
+		if err != nil || len(entries) != n {
+			t.Fatalf("unexpected history: %v, %v", len(entries), err)
+		}
+		for e, entry := range entries {
+			if entry.Say != fmt.Sprintf("%d", first+e) {
+				t.Errorf("unexpected entry: %v", entry.Say)
+			}
+		}
+	}
+	checkLast(10, 1990)
+	store.Append("aliens-on-earth", history.Entry{From: "dunha", Say: "2000"})
+	checkLast(10, 1991)
+	checkLast(1500, 501)
+	//  INFO(Santiago): From now on the recent entries come from the memory, not from the file.
+	os.Remove(filepath.Join(directory, "aliens-on-earth.history"))
+	store.Append("aliens-on-earth", history.Entry{From: "dunha", Say: "2001"})
+	checkLast(5, 1997)
+}
@@ -0,0 +1,78 @@
+/*
+ *                                Copyright (C) 2016 by Rafael Santiago
+ *
+ * This is a free software. You can redistribute it and/or modify under
+ * the terms of the GNU General Public License version 2.
+ *
+ */
+package cherry_test
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"pkg/config"
+	"pkg/html"
+	"pkg/rawhttp"
+	"pkg/reqtraps"
+	"strings"
+	"testing"
+)
+
+func postHook(t *testing.T, rooms *config.CherryRooms, token, contentType, body string) string {
+	payload := fmt.Sprintf("POST /hook/%s HTTP/1.1\r\nContent-Type: %s\r\nContent-Length: %d\r\n\r\n%s", token, contentType, len(body), body)
+	req, err := rawhttp.ReadRequest(bufio.NewReader(strings.NewReader(payload)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	trap, roomName := reqtraps.GetRequestTrap(req, "aliens-on-earth", rooms)
+	conn, peer := net.Pipe()
+	go trap().Handle(conn, roomName, req, rooms, html.NewHTMLPreprocessor(rooms))
+	reply, _ := ioutil.ReadAll(peer)
+	peer.Close()
+	return string(reply)
+}
+
+func TestIncomingHooks(t *testing.T) {
+	rooms := config.NewCherryRooms()
+	rooms.AddRoom("aliens-on-earth", 1024)
+	rooms.SetAllUsersAlias("aliens-on-earth", "all")
+	rooms.AddAction("aliens-on-earth", "a01", "talks to", "")
+	rooms.SetHookBurst("aliens-on-earth", 3)
+	rooms.SetHookRate("aliens-on-earth", 1)
+	rooms.AddIncomingHook("aliens-on-earth", "s3cr3t", "radar", "a01")
+	if !rooms.IsIncomingHookNickname("aliens-on-earth", "radar") {
+		t.Error("the hook nickname is not reserved.")
+	}
+
+	if reply := postHook(t, rooms, "guess", "application/json", `{"says": "UFO!"}`); !strings.HasPrefix(reply, "HTTP/1.1 403") {
+		t.Errorf("unknown token: %s", reply)
+	}
+
+	if reply := postHook(t, rooms, "s3cr3t", "application/json", `{"says": "UFO over Roswell!"}`); !strings.HasPrefix(reply, "HTTP/1.1 200") {
+		t.Errorf("json: %s", reply)
+	}
+	if message := nextMessage(rooms); message.From != "radar" || message.To != "all" || message.Action != "a01" ||
+		message.Say != "UFO over Roswell!" || message.Priv == "1" {
+		t.Errorf("json: %+v", message)
+	}
+
+	if reply := postHook(t, rooms, "s3cr3t", "application/x-www-form-urlencoded", "says=UFO+over+Varginha%21"); !strings.HasPrefix(reply, "HTTP/1.1 200") {
+		t.Errorf("form: %s", reply)
+	}
+	if message := nextMessage(rooms); message.From != "radar" || message.Say != "UFO over Varginha!" {
+		t.Errorf("form: %+v", message)
+	}
+
+	if reply := postHook(t, rooms, "s3cr3t", "application/json", `{"says": `); !strings.HasPrefix(reply, "HTTP/1.1 400") {
+		t.Errorf("bad body: %s", reply)
+	}
+
+	if reply := postHook(t, rooms, "s3cr3t", "application/json", `{"says": "UFO!"}`); !strings.HasPrefix(reply, "HTTP/1.1 429") {
+		t.Errorf("flood: %s", reply)
+	}
+	if message := nextMessage(rooms); len(message.From) > 0 {
+		t.Errorf("%+v should not be delivered.", message)
+	}
+}
@@ -13,6 +13,7 @@ import (
 	"pkg/config/parser"
 	"pkg/html"
 	"testing"
+	"time"
 )
 
 func PreprocessorBasicTest(t *testing.T) {
@@ -39,3 +40,49 @@ func PreprocessorBasicTest(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestMessageSound(t *testing.T) {
+	rooms := config.NewCherryRooms()
+	rooms.AddRoom("aliens-on-earth", 1024)
+	rooms.AddSound("aliens-on-earth", "s01", "beep", "", "http://localhost/beep.wav")
+	preprocessor := html.NewHTMLPreprocessor(rooms)
+	if preprocessor.ExpandData("aliens-on-earth", "{{.sound-list}}") != "<option value = \"s01\">beep\n" {
+		t.Fail()
+	}
+	message := config.Message{From: "dunha", Sound: "s01"}
+	if preprocessor.ExpandMessage("aliens-on-earth", message, "{{.message-sound}}") != "<audio src = \"http://localhost/beep.wav\" autoplay></audio>" {
+		t.Fail()
+	}
+	message = config.Message{From: "dunha", Sound: "s02", Say: "boo!"}
+	if preprocessor.ExpandMessage("aliens-on-earth", message, "{{.message-sound}}") != "" {
+		t.Fail()
+	}
+}
+
+func TestExpandMessage(t *testing.T) {
+	rooms := config.NewCherryRooms()
+	rooms.AddRoom("aliens-on-earth", 1024)
+	rooms.AddUser("aliens-on-earth", "dunha", "1", true)
+	rooms.AddUser("aliens-on-earth", "mulder", "2", true)
+	preprocessor := html.NewHTMLPreprocessor(rooms)
+	//  INFO(Santiago): Whatever is waiting in the queue must not be taken into consideration.
+	rooms.EnqueueMessage("aliens-on-earth", "mulder", "", "", "", "", "The truth is out there.", "")
+	message := config.Message{From: "dunha", To: "mulder", Say: "hi!", Priv: "1",
+		Time: time.Date(1947, time.July, 8, 9, 5, 3, 0, time.UTC)}
+	rooms.SetPrivateMessageMarker("aliens-on-earth", "(priv)")
+	expanded := preprocessor.ExpandMessage("aliens-on-earth", message,
+		"({{.hour}}:{{.minute}}:{{.second}}) {{.message-colored-user}} {{.message-private-marker}} {{.message-whoto}}: {{.message-says}}")
+	if expanded != "(09:05:03) <font color = \"#d10019\">dunha</font> (priv) mulder: hi!" {
+		t.Errorf("unexpected expansion: %q", expanded)
+	}
+	if preprocessor.ExpandMessage("aliens-on-earth", message, "{{.nickname}}:{{.color}}:{{.session-id}}") != "dunha:1:" {
+		t.Error("the sender's data was not expanded as expected")
+	}
+	if preprocessor.ExpandUserData("aliens-on-earth", "mulder", "{{.nickname}}:{{.color}}:{{.session-id}}") !=
+		"mulder:2:"+rooms.GetSessionID("mulder", "aliens-on-earth") {
+		t.Error("the user's data was not expanded as expected")
+	}
+	if preprocessor.ExpandData("aliens-on-earth", "{{.message-user}}{{.message-says}}{{.nickname}}") != "" {
+		t.Error("message data was expanded without a message")
+	}
+}
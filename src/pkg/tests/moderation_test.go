@@ -0,0 +1,118 @@
+/*
+ *                                Copyright (C) 2016 by Rafael Santiago
+ *
+ * This is a free software. You can redistribute it and/or modify under
+ * the terms of the GNU General Public License version 2.
+ *
+ */
+package cherry_test
+
+import (
+	"net/url"
+	"pkg/config"
+	"pkg/reqtraps"
+	"strings"
+	"testing"
+)
+
+func joinAs(t *testing.T, rooms *config.CherryRooms, user, password string) string {
+	return postForm(t, rooms, "/join", reqtraps.PostJoinHandle, url.Values{"user": {user}, "color": {"0"}, "password": {password}}.Encode())
+}
+
+// hashPassword makes a cheap password hash, the tests do not need the iterations asked by the cherry file.
+func hashPassword(t *testing.T, password string) string {
+	secret, err := config.HashPassword(password, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return secret
+}
+
+func TestPasswordHash(t *testing.T) {
+	secret, err := config.HashPassword("the truth is out there", config.MinPasswordIterations)
+	if err != nil || config.CheckPasswordHash(secret) != nil {
+		t.Fatalf("unexpected password hash: %s, %v", secret, err)
+	}
+	if other, _ := config.HashPassword("the truth is out there", config.MinPasswordIterations); other == secret {
+		t.Error("the password hashes are not salted.")
+	}
+	for _, secret := range []string{
+		"the truth is out there",
+		"sha256:6b7a3c8d7b8e9ce4e2a3bc6b2c4d1e0f8a9b7c6d5e4f3a2b1c0d9e8f7a6b5c4d",
+		hashPassword(t, "the truth is out there"),
+		"pbkdf2-sha256:600000:00:6b7a3c8d7b8e9ce4e2a3bc6b2c4d1e0f8a9b7c6d5e4f3a2b1c0d9e8f7a6b5c4d",
+		"pbkdf2-sha256:600000:0123456789abcdef:6b7a3c",
+		"pbkdf2-sha256:many:0123456789abcdef:6b7a3c8d7b8e9ce4e2a3bc6b2c4d1e0f8a9b7c6d5e4f3a2b1c0d9e8f7a6b5c4d",
+	} {
+		if config.CheckPasswordHash(secret) == nil {
+			t.Errorf("%q was accepted as a password hash.", secret)
+		}
+	}
+}
+
+func TestModeration(t *testing.T) {
+	rooms := config.NewCherryRooms()
+	rooms.AddRoom("aliens-on-earth", 1024)
+	rooms.SetAllUsersAlias("aliens-on-earth", "all")
+	rooms.AddAction("aliens-on-earth", "a01", "talks to", "")
+	rooms.AddAction("aliens-on-earth", "a05", "KICK", "")
+	rooms.AddAction("aliens-on-earth", "a06", "MUTE", "")
+	rooms.AddAction("aliens-on-earth", "a07", "BAN", "")
+	rooms.SetKickAction("aliens-on-earth", "a05")
+	rooms.SetMuteAction("aliens-on-earth", "a06")
+	rooms.SetBanAction("aliens-on-earth", "a07")
+	rooms.AddModerator("aliens-on-earth", "mulder", hashPassword(t, "the truth is out there"))
+	rooms.AddUser("aliens-on-earth", "dunha", "0", false)
+	rooms.AddUser("aliens-on-earth", "agent smith", "0", false)
+
+	joinAs(t, rooms, "mulder", "the truth is in here")
+	if rooms.HasUser("aliens-on-earth", "mulder") {
+		t.Fatal("a moderator has joined with a wrong password.")
+	}
+	joinAs(t, rooms, "mulder", "the truth is out there")
+	if !rooms.IsModerator("aliens-on-earth", "mulder") {
+		t.Fatal("the moderator has not joined.")
+	}
+	nextMessage(rooms)
+	if !strings.Contains(rooms.GetUsersList("aliens-on-earth"), "\"mulder\">@mulder") {
+		t.Error("the moderator is not marked.")
+	}
+	postBanner(t, rooms, "mulder", "/nick fox")
+	if message := nextMessage(rooms); !message.Notice || message.Priv != "1" || !rooms.IsModerator("aliens-on-earth", "mulder") ||
+		rooms.HasUser("aliens-on-earth", "fox") {
+		t.Errorf("/nick by a moderator: %+v", message)
+	}
+	if strings.Contains(rooms.GetUserActionList("aliens-on-earth", "dunha"), "KICK") ||
+		!strings.Contains(rooms.GetUserActionList("aliens-on-earth", "mulder"), "KICK") {
+		t.Error("the moderation actions are offered to the wrong users.")
+	}
+
+	postBannerAction(t, rooms, "dunha", "a05", "agent smith", "")
+	if message := nextMessage(rooms); !message.Notice || message.Priv != "1" || !rooms.HasUser("aliens-on-earth", "agent smith") {
+		t.Errorf("kick by a user: %+v", message)
+	}
+
+	postBannerAction(t, rooms, "mulder", "a06", "dunha", "")
+	if message := nextMessage(rooms); message.From != "dunha" || message.Say != "was muted by a moderator." || !rooms.IsMuted("aliens-on-earth", "dunha") {
+		t.Errorf("mute: %+v", message)
+	}
+	postBanner(t, rooms, "dunha", "I want to believe")
+	if message := nextMessage(rooms); !message.Notice || message.Priv != "1" {
+		t.Errorf("post of a muted user: %+v", message)
+	}
+
+	postBanner(t, rooms, "mulder", "/kick agent smith")
+	if message := nextMessage(rooms); message.From != "agent smith" || message.Say != "was kicked out by a moderator." ||
+		rooms.HasUser("aliens-on-earth", "agent smith") {
+		t.Errorf("/kick: %+v", message)
+	}
+
+	postBannerAction(t, rooms, "mulder", "a07", "dunha", "")
+	if message := nextMessage(rooms); message.From != "dunha" || message.Say != "was banned by a moderator." ||
+		rooms.HasUser("aliens-on-earth", "dunha") {
+		t.Errorf("ban: %+v", message)
+	}
+	if reply := joinAs(t, rooms, "dunha", ""); !strings.HasPrefix(reply, "HTTP/1.1 403") || rooms.HasUser("aliens-on-earth", "dunha") {
+		t.Error("a banned user has joined again.")
+	}
+}
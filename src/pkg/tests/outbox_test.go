@@ -0,0 +1,64 @@
+/*
+ *                                Copyright (C) 2016 by Rafael Santiago
+ *
+ * This is a free software. You can redistribute it and/or modify under
+ * the terms of the GNU General Public License version 2.
+ *
+ */
+package cherry_test
+
+import (
+	"net"
+	"pkg/config"
+	"testing"
+	"time"
+)
+
+func TestSlowClient(t *testing.T) {
+	rooms := config.NewCherryRooms()
+	rooms.AddRoom("aliens-on-earth", 1024)
+	rooms.SetOutboundQueueSize("aliens-on-earth", 2)
+	rooms.SetWriteTimeout("aliens-on-earth", 1)
+	rooms.SetExitMessage("aliens-on-earth", "has left...")
+	rooms.AddUser("aliens-on-earth", "dunha", "0", false)
+	rooms.AddUser("aliens-on-earth", "donha", "0", false)
+	slowConn, slowPeer := net.Pipe()
+	defer slowPeer.Close()
+	fastConn, fastPeer := net.Pipe()
+	defer fastPeer.Close()
+	rooms.SetUserConnection("aliens-on-earth", "dunha", slowConn)
+	rooms.SetUserConnection("aliens-on-earth", "donha", fastConn)
+
+	//  INFO(Santiago): Nobody reads from the slow peer, so only the writer and the queue can hold messages.
+	var accepted int
+	for i := 0; i < 5; i++ {
+		if rooms.SendToUser("aliens-on-earth", "dunha", []byte("boo!")) {
+			accepted++
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if accepted != 3 {
+		t.Errorf("%d messages accepted", accepted)
+	}
+
+	buf := make([]byte, 16)
+	if !rooms.SendToUser("aliens-on-earth", "donha", []byte("boo!")) {
+		t.Fail()
+	}
+	if n, err := fastPeer.Read(buf); err != nil || string(buf[:n]) != "boo!" {
+		t.Fail()
+	}
+
+	time.Sleep(1500 * time.Millisecond)
+	if rooms.HasUser("aliens-on-earth", "dunha") || !rooms.HasUser("aliens-on-earth", "donha") {
+		t.Fail()
+	}
+	if message := rooms.GetNextMessage("aliens-on-earth"); message.From != "dunha" || message.Say != "has left..." {
+		t.Fail()
+	}
+
+	rooms.DisconnectUser("aliens-on-earth", "donha")
+	if rooms.HasUser("aliens-on-earth", "donha") {
+		t.Fail()
+	}
+}
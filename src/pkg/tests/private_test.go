@@ -0,0 +1,90 @@
+/*
+ *                                Copyright (C) 2016 by Rafael Santiago
+ *
+ * This is a free software. You can redistribute it and/or modify under
+ * the terms of the GNU General Public License version 2.
+ *
+ */
+package cherry_test
+
+import (
+	"net/url"
+	"pkg/config"
+	"pkg/reqtraps"
+	"strings"
+	"testing"
+)
+
+func joinWith(t *testing.T, rooms *config.CherryRooms, fields url.Values) string {
+	fields.Set("color", "0")
+	return postForm(t, rooms, "/join", reqtraps.PostJoinHandle, fields.Encode())
+}
+
+func TestRoomPassword(t *testing.T) {
+	rooms := config.NewCherryRooms()
+	rooms.AddRoom("aliens-on-earth", 1024)
+	rooms.SetAllowBrief("aliens-on-earth", true)
+	if rooms.IsPrivate("aliens-on-earth") || !rooms.IsValidRoomPassword("aliens-on-earth", "") {
+		t.Fatal("a room without password is private.")
+	}
+	rooms.SetRoomPassword("aliens-on-earth", hashPassword(t, "area 51"))
+	rooms.SetJoinFailureRate("aliens-on-earth", 1)
+	rooms.SetJoinFailureBurst("aliens-on-earth", 2)
+	if !rooms.IsPrivate("aliens-on-earth") {
+		t.Fatal("a room with password is not private.")
+	}
+
+	if reply := joinWith(t, rooms, url.Values{"user": {"dunha"}, "password": {"area 52"}}); !strings.HasPrefix(reply, "HTTP/1.1 403") ||
+		rooms.HasUser("aliens-on-earth", "dunha") {
+		t.Fatal("somebody has joined with a wrong password.")
+	}
+	joinWith(t, rooms, url.Values{"user": {"dunha"}, "password": {"area 51"}})
+	if !rooms.HasUser("aliens-on-earth", "dunha") {
+		t.Fatal("somebody has not joined with the right password.")
+	}
+
+	joinWith(t, rooms, url.Values{"user": {"agent smith"}})
+	if reply := joinWith(t, rooms, url.Values{"user": {"agent smith"}, "password": {"area 51"}}); !strings.HasPrefix(reply, "HTTP/1.1 429") ||
+		rooms.HasUser("aliens-on-earth", "agent smith") {
+		t.Error("the failed joins were not limited.")
+	}
+}
+
+func TestInviteOnlyRoom(t *testing.T) {
+	rooms := config.NewCherryRooms()
+	rooms.AddRoom("aliens-on-earth", 1024)
+	rooms.SetAllUsersAlias("aliens-on-earth", "all")
+	rooms.AddAction("aliens-on-earth", "a01", "talks to", "")
+	rooms.SetInviteOnly("aliens-on-earth", true)
+	rooms.AddModerator("aliens-on-earth", "mulder", hashPassword(t, "the truth is out there"))
+	rooms.AddUser("aliens-on-earth", "dunha", "0", false)
+
+	if reply := joinWith(t, rooms, url.Values{"user": {"agent smith"}}); !strings.HasPrefix(reply, "HTTP/1.1 403") {
+		t.Fatal("somebody has joined without an invite.")
+	}
+	joinWith(t, rooms, url.Values{"user": {"mulder"}, "password": {"the truth is out there"}})
+	if !rooms.IsModerator("aliens-on-earth", "mulder") {
+		t.Fatal("a moderator needs an invite.")
+	}
+	nextMessage(rooms)
+
+	postBanner(t, rooms, "dunha", "/invite")
+	message := nextMessage(rooms)
+	index := strings.Index(message.Say, "/join&amp;invite=")
+	if !message.Notice || message.Priv != "1" || index == -1 {
+		t.Fatalf("/invite: %+v", message)
+	}
+	invite := strings.TrimSuffix(message.Say[index+len("/join&amp;invite="):], "&amp;")
+	if !rooms.IsValidInvite("aliens-on-earth", invite) {
+		t.Fatalf("/invite gave an invalid invite: %s", invite)
+	}
+
+	joinWith(t, rooms, url.Values{"user": {"scully"}, "invite": {invite}})
+	if !rooms.HasUser("aliens-on-earth", "scully") {
+		t.Fatal("somebody has not joined with an invite.")
+	}
+	if reply := joinWith(t, rooms, url.Values{"user": {"agent smith"}, "invite": {invite}}); !strings.HasPrefix(reply, "HTTP/1.1 403") ||
+		rooms.HasUser("aliens-on-earth", "agent smith") {
+		t.Error("an invite was used twice.")
+	}
+}
@@ -0,0 +1,71 @@
+/*
+ *                                Copyright (C) 2016 by Rafael Santiago
+ *
+ * This is a free software. You can redistribute it and/or modify under
+ * the terms of the GNU General Public License version 2.
+ *
+ */
+package cherry_test
+
+import (
+	"pkg/config"
+	"pkg/ratelimit"
+	"testing"
+	"time"
+)
+
+func TestBucket(t *testing.T) {
+	bucket := ratelimit.NewBucket(20, 2)
+	if !bucket.Allow() || !bucket.Allow() {
+		t.Fail()
+	}
+	if bucket.Allow() || !bucket.IsEmpty() || bucket.IsFull() {
+		t.Fail()
+	}
+	time.Sleep(100 * time.Millisecond)
+	if !bucket.Allow() {
+		t.Fail()
+	}
+}
+
+func TestFloodingPolice(t *testing.T) {
+	rooms := config.NewCherryRooms()
+	rooms.AddRoom("aliens-on-earth", 1024)
+	rooms.AddUser("aliens-on-earth", "dunha", "0", false)
+	if rooms.PoliceFlood("aliens-on-earth", "dunha") != config.FloodAllowed {
+		t.Fail()
+	}
+	rooms.SetFloodingPolice("aliens-on-earth", true)
+	rooms.SetFloodRate("aliens-on-earth", 1)
+	rooms.SetFloodBurst("aliens-on-earth", 2)
+	rooms.SetFloodWarningsBeforeMute("aliens-on-earth", 1)
+	rooms.SetFloodMuteTime("aliens-on-earth", 1)
+	rooms.SetMaxFloodAllowedBeforeKick("aliens-on-earth", 3)
+	expected := []config.FloodVerdict{config.FloodAllowed,
+		config.FloodAllowed,
+		config.FloodWarned,
+		config.FloodMuted,
+		config.FloodDropped,
+		config.FloodDropped}
+	for i, verdict := range expected {
+		if rooms.PoliceFlood("aliens-on-earth", "dunha") != verdict {
+			t.Errorf("unexpected verdict at message #%d", i)
+		}
+	}
+	time.Sleep(1100 * time.Millisecond)
+	if rooms.PoliceFlood("aliens-on-earth", "dunha") != config.FloodKicked {
+		t.Error("the flooder was not kicked out.")
+	}
+
+	rooms.AddUser("aliens-on-earth", "mulder", "0", false)
+	rooms.SetFloodForgiveTime("aliens-on-earth", 1)
+	for _, verdict := range []config.FloodVerdict{config.FloodAllowed, config.FloodAllowed, config.FloodWarned} {
+		if rooms.PoliceFlood("aliens-on-earth", "mulder") != verdict {
+			t.Fatal("unexpected verdict before the quiet period.")
+		}
+	}
+	time.Sleep(1100 * time.Millisecond)
+	if rooms.PoliceFlood("aliens-on-earth", "mulder") != config.FloodWarned {
+		t.Error("the previous floods were not forgiven.")
+	}
+}
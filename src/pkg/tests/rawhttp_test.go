@@ -8,8 +8,13 @@
 package cherry_test
 
 import (
+	"bufio"
+	"io/ioutil"
+	"net"
 	"pkg/rawhttp"
+	"strings"
 	"testing"
+	"testing/iotest"
 )
 
 func TestGetFieldsFromPost(t *testing.T) {
@@ -43,3 +48,64 @@ func TestGetFieldsFromGet(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestReadRequest(t *testing.T) {
+	payload := "POST /banner&user=dunha&id=123& HTTP/1.1\r\nHost: localhost\r\nContent-Length: 17\r\n\r\nsays=hello&priv=1"
+	req, err := rawhttp.ReadRequest(bufio.NewReader(iotest.OneByteReader(strings.NewReader(payload))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.Method != "POST" || req.Target != "/banner&user=dunha&id=123&" || req.Version != "HTTP/1.1" {
+		t.Fail()
+	}
+	if req.GetHeader("host") != "localhost" {
+		t.Fail()
+	}
+	fields := req.GetFieldsFromPost()
+	if fields["says"] != "hello" || fields["priv"] != "1" {
+		t.Fail()
+	}
+	fields = req.GetFieldsFromGet()
+	if fields["user"] != "dunha" || fields["id"] != "123" {
+		t.Fail()
+	}
+}
+
+func TestReadRequestChunked(t *testing.T) {
+	payload := "POST /join HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nuser=\r\n5;ext=1\r\ndunha\r\n0\r\n\r\n"
+	req, err := rawhttp.ReadRequest(bufio.NewReader(strings.NewReader(payload)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(req.Body) != "user=dunha" {
+		t.Fail()
+	}
+}
+
+func TestReadRequestLimits(t *testing.T) {
+	payload := "POST /banner& HTTP/1.1\r\nContent-Length: 999999999\r\n\r\n"
+	if _, err := rawhttp.ReadRequest(bufio.NewReader(strings.NewReader(payload))); err != rawhttp.ErrRequestTooLarge {
+		t.Fail()
+	}
+	payload = "GET /" + strings.Repeat("a", rawhttp.MaxRequestLineSize) + " HTTP/1.1\r\n\r\n"
+	if _, err := rawhttp.ReadRequest(bufio.NewReader(strings.NewReader(payload))); err != rawhttp.ErrRequestTooLarge {
+		t.Fail()
+	}
+	payload = "GARBAGE\r\n\r\n"
+	if _, err := rawhttp.ReadRequest(bufio.NewReader(strings.NewReader(payload))); err != rawhttp.ErrBadRequest {
+		t.Fail()
+	}
+}
+
+func TestEventStreamConn(t *testing.T) {
+	server, client := net.Pipe()
+	events := rawhttp.NewEventStreamConn(server, 41)
+	go func() {
+		events.Write([]byte("<p>hello\r\nworld</p>\n"))
+		server.Close()
+	}()
+	data, _ := ioutil.ReadAll(client)
+	if string(data) != "id: 42\ndata: <p>hello\ndata: world</p>\n\n" {
+		t.Fail()
+	}
+}
@@ -0,0 +1,117 @@
+/*
+ *                                Copyright (C) 2016 by Rafael Santiago
+ *
+ * This is a free software. You can redistribute it and/or modify under
+ * the terms of the GNU General Public License version 2.
+ *
+ */
+package cherry_test
+
+import (
+	"net"
+	"net/url"
+	"pkg/config"
+	"pkg/html"
+	"pkg/rawhttp"
+	"pkg/reqtraps"
+	"strings"
+	"testing"
+)
+
+func TestRoomReload(t *testing.T) {
+	rooms := config.NewCherryRooms()
+	rooms.AddRoom("aliens-on-earth", 1024)
+	rooms.AddRoom("martians", 1025)
+	rooms.SetGreetingMessage("aliens-on-earth", "Take meeeeee to your leader!!!")
+	rooms.AddUser("aliens-on-earth", "dunha", "0", false)
+	rooms.AddUser("martians", "donha", "0", false)
+	conn, peer := net.Pipe()
+	defer peer.Close()
+	rooms.SetUserConnection("martians", "donha", conn)
+
+	newRooms := config.NewCherryRooms()
+	newRooms.AddRoom("aliens-on-earth", 1024)
+	newRooms.AddRoom("venusians", 1026)
+	newRooms.SetGreetingMessage("aliens-on-earth", "Hello!")
+
+	if !rooms.ReloadRoom("aliens-on-earth", newRooms) {
+		t.Fail()
+	}
+	if rooms.GetGreetingMessage("aliens-on-earth") != "Hello!" || !rooms.HasUser("aliens-on-earth", "dunha") {
+		t.Fail()
+	}
+
+	rooms.RemoveRoom("martians")
+	if rooms.HasRoom("martians") {
+		t.Fail()
+	}
+	if _, err := conn.Write([]byte("boo!")); err == nil {
+		t.Fail()
+	}
+	//  INFO(Santiago): Who is still using the closed room must not crash.
+	rooms.EnqueueNotice("martians", "donha", "has left...", "")
+	if rooms.HasUser("martians", "donha") || len(rooms.GetRoomUsers("martians")) != 0 || len(rooms.GetRooms()) != 1 {
+		t.Fail()
+	}
+	if reply := getPage(t, rooms, "/join", func(newConn net.Conn, roomName string, req *rawhttp.Request, rooms *config.CherryRooms, preprocessor *html.Preprocessor) {
+		trap, _ := reqtraps.GetRequestTrap(req, "martians", rooms)
+		trap().Handle(newConn, roomName, req, rooms, preprocessor)
+	}); !strings.HasPrefix(reply, "HTTP/1.1 404") {
+		t.Errorf("a closed room has answered: %s", reply)
+	}
+	if !rooms.AddRoom("martians", 1025) || rooms.HasUser("martians", "donha") {
+		t.Fail()
+	}
+
+	if !rooms.AdoptRoom("venusians", newRooms) || !rooms.HasRoom("venusians") {
+		t.Fail()
+	}
+	if rooms.AdoptRoom("venusians", newRooms) {
+		t.Fail()
+	}
+
+	changedPort := config.NewCherryRooms()
+	changedPort.AddRoom("aliens-on-earth", 1027)
+	if rooms.ReloadRoom("aliens-on-earth", changedPort) || rooms.GetListenPort("aliens-on-earth") != "1024" {
+		t.Fail()
+	}
+}
+
+func TestReloadWhilePosting(t *testing.T) {
+	newRoom := func() *config.CherryRooms {
+		rooms := config.NewCherryRooms()
+		rooms.AddRoom("aliens-on-earth", 1024)
+		rooms.SetAllUsersAlias("aliens-on-earth", "all")
+		rooms.AddAction("aliens-on-earth", "a01", "talks to", "")
+		rooms.AddImage("aliens-on-earth", "i01", "ufo", "", "http://localhost/ufo.png")
+		rooms.AddSound("aliens-on-earth", "s01", "beep", "", "http://localhost/beep.wav")
+		rooms.AddTemplate("aliens-on-earth", "top", "{{.room-name}}")
+		return rooms
+	}
+	rooms := newRoom()
+	rooms.AddUser("aliens-on-earth", "dunha", "0", false)
+	done := make(chan bool)
+	go func() {
+		for i := 0; i < 50; i++ {
+			rooms.ReloadRoom("aliens-on-earth", newRoom())
+		}
+		close(done)
+	}()
+	//  INFO(Santiago): Run it with -race, the posts read what the reload replaces.
+	for posting := true; posting; {
+		select {
+		case <-done:
+			posting = false
+		default:
+			body := url.Values{"user": {"dunha"}, "id": {rooms.GetSessionID("dunha", "aliens-on-earth")}, "action": {"a01"},
+				"whoto": {"all"}, "image": {"i01"}, "sound": {"s01"}, "says": {"hi"}}.Encode()
+			postForm(t, rooms, "/banner", reqtraps.PostBannerHandle, body)
+			rooms.HasTemplate("aliens-on-earth", "top")
+			rooms.GetImageList("aliens-on-earth")
+			rooms.GetSoundList("aliens-on-earth")
+			for rooms.HasPendingMessages("aliens-on-earth") {
+				rooms.DequeueMessage("aliens-on-earth")
+			}
+		}
+	}
+}
@@ -0,0 +1,59 @@
+/*
+ *                                Copyright (C) 2016 by Rafael Santiago
+ *
+ * This is a free software. You can redistribute it and/or modify under
+ * the terms of the GNU General Public License version 2.
+ *
+ */
+package cherry_test
+
+import (
+	"pkg/config"
+	"pkg/html"
+	"testing"
+)
+
+func TestSanitize(t *testing.T) {
+	type testCtx struct {
+		data      string
+		allowed   []string
+		sanitized string
+	}
+	testVector := []testCtx{
+		{"<script>alert(1)</script>", nil, "&lt;script&gt;alert(1)&lt;/script&gt;"},
+		{"<b>boo!</b>", nil, "&lt;b&gt;boo!&lt;/b&gt;"},
+		{"<b>boo!</b>", []string{"b"}, "<b>boo!</b>"},
+		{"<B>boo!</B>", []string{"b"}, "<b>boo!</b>"},
+		{"<b onclick=\"x()\">boo!</b>", []string{"b"}, "&lt;b onclick=&#34;x()&#34;&gt;boo!"},
+		{"<b><i>boo!</b>", []string{"b", "i"}, "<b><i>boo!</i></b>"},
+		{"<b>boo!", []string{"b"}, "<b>boo!</b>"},
+		{"boo!</i>", []string{"i"}, "boo!"},
+		{"one<br>two<br/>", []string{"br"}, "one<br>two<br>"},
+		{"<script>x</script>", []string{"script"}, "&lt;script&gt;x&lt;/script&gt;"},
+		{"Tom & 'Jerry'", nil, "Tom &amp; &#39;Jerry&#39;"},
+	}
+	for _, test := range testVector {
+		if sanitized := html.Sanitize(test.data, test.allowed); sanitized != test.sanitized {
+			t.Errorf("Sanitize(%q) = %q", test.data, sanitized)
+		}
+	}
+	if !html.IsSafeMarkup("b") || html.IsSafeMarkup("script") || html.IsSafeMarkup("iframe") {
+		t.Fail()
+	}
+}
+
+func TestMessageSaysSanitization(t *testing.T) {
+	rooms := config.NewCherryRooms()
+	rooms.AddRoom("aliens-on-earth", 1024)
+	rooms.SetAllowedMarkup("aliens-on-earth", []string{"i"})
+	preprocessor := html.NewHTMLPreprocessor(rooms)
+	message := config.Message{From: "<b>dunha</b>", Say: "<i>look</i> <script>x()</script>[http://localhost/abducted.gif]"}
+	if preprocessor.ExpandMessage("aliens-on-earth", message, "{{.message-user}}: {{.message-says}}") !=
+		"&lt;b&gt;dunha&lt;/b&gt;: <i>look</i> &lt;script&gt;x()&lt;/script&gt;<img src = \"http://localhost/abducted.gif\">" {
+		t.Fail()
+	}
+	message = config.Message{From: "dunha", Say: "joined...<script>scrollIt();</script>", Notice: true}
+	if preprocessor.ExpandMessage("aliens-on-earth", message, "{{.message-says}}") != "joined...<script>scrollIt();</script>" {
+		t.Fail()
+	}
+}
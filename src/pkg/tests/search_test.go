@@ -0,0 +1,58 @@
+/*
+ *                                Copyright (C) 2016 by Rafael Santiago
+ *
+ * This is a free software. You can redistribute it and/or modify under
+ * the terms of the GNU General Public License version 2.
+ *
+ */
+package cherry_test
+
+import (
+	"pkg/history"
+	"pkg/search"
+	"testing"
+	"time"
+)
+
+func TestSearchIndex(t *testing.T) {
+	index := search.NewIndex()
+	when := time.Date(1947, time.July, 8, 9, 0, 0, 0, time.UTC)
+	index.Add("aliens-on-earth", history.Entry{Time: when, From: "dunha", Say: "joined...", Notice: true})
+	index.Add("aliens-on-earth", history.Entry{Time: when, From: "dunha", Say: "Take me to your leader!"})
+	index.Add("aliens-on-earth", history.Entry{Time: when.Add(time.Hour), From: "mulder", Say: "The truth is out there."})
+	index.Add("backyard-science", history.Entry{Time: when.Add(2 * time.Hour), From: "dunha", Say: "Where is the leader?"})
+	index.Add("aliens-on-earth", history.Entry{Time: when.Add(3 * time.Hour), From: "Dunha", Say: "your leader, your LEADER"})
+	index.Add("backyard-science", history.Entry{Time: when.Add(4 * time.Hour), From: "mulder", Say: "a <i>flying</i> saucer"})
+	type testCtx struct {
+		query search.Query
+		found []string
+	}
+	testVector := []testCtx{
+		{search.Query{Phrase: "leader"}, []string{"your leader, your LEADER", "Where is the leader?", "Take me to your leader!"}},
+		{search.Query{Phrase: "your leader"}, []string{"your leader, your LEADER", "Take me to your leader!"}},
+		{search.Query{Phrase: "leader your"}, []string{"your leader, your LEADER"}},
+		{search.Query{Phrase: "\"TRUTH\""}, []string{"The truth is out there."}},
+		{search.Query{Phrase: "joined"}, nil},
+		{search.Query{Phrase: "leader", Room: "aliens-on-earth"}, []string{"your leader, your LEADER", "Take me to your leader!"}},
+		{search.Query{Phrase: "leader", From: "dunha", Limit: 2}, []string{"your leader, your LEADER", "Where is the leader?"}},
+		{search.Query{From: "mulder", Room: "aliens-on-earth"}, []string{"The truth is out there."}},
+		{search.Query{Since: when.Add(time.Hour), Until: when.Add(2 * time.Hour)}, []string{"Where is the leader?", "The truth is out there."}},
+		{search.Query{Phrase: "flying saucer"}, []string{"a <i>flying</i> saucer"}},
+		{search.Query{Phrase: "i"}, nil},
+	}
+	for _, test := range testVector {
+		found := index.Search(test.query)
+		if len(found) != len(test.found) {
+			t.Errorf("%v: %d results instead of %d", test.query, len(found), len(test.found))
+			continue
+		}
+		for f, result := range found {
+			if result.Entry.Say != test.found[f] {
+				t.Errorf("%v: unexpected result %q", test.query, result.Entry.Say)
+			}
+		}
+	}
+	if !index.HasRoom("backyard-science") || index.HasRoom("land-of-competition") {
+		t.Error("unexpected indexed rooms")
+	}
+}
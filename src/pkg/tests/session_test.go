@@ -0,0 +1,94 @@
+/*
+ *                                Copyright (C) 2016 by Rafael Santiago
+ *
+ * This is a free software. You can redistribute it and/or modify under
+ * the terms of the GNU General Public License version 2.
+ *
+ */
+package cherry_test
+
+import (
+	"net"
+	"pkg/config"
+	"pkg/reqtraps"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSessionTokens(t *testing.T) {
+	rooms := config.NewCherryRooms()
+	rooms.AddRoom("aliens-on-earth", 1024)
+	conn, peer := net.Pipe()
+	defer conn.Close()
+	defer peer.Close()
+	rooms.AddUser("aliens-on-earth", "dunha", "0", false)
+	firstID := rooms.GetSessionID("dunha", "aliens-on-earth")
+	if len(firstID) != 32 {
+		t.Fail()
+	}
+	if !rooms.IsValidUserRequest("aliens-on-earth", "dunha", firstID, conn) {
+		t.Fail()
+	}
+	if rooms.IsValidUserRequest("aliens-on-earth", "dunha", "", conn) {
+		t.Fail()
+	}
+	rooms.RemoveUser("aliens-on-earth", "dunha")
+	if rooms.IsValidUserRequest("aliens-on-earth", "dunha", firstID, conn) {
+		t.Fail()
+	}
+	rooms.AddUser("aliens-on-earth", "dunha", "0", false)
+	secondID := rooms.GetSessionID("dunha", "aliens-on-earth")
+	if secondID == firstID || rooms.IsValidUserRequest("aliens-on-earth", "dunha", firstID, conn) {
+		t.Fail()
+	}
+	rooms.SetSessionLifetime("aliens-on-earth", 1)
+	time.Sleep(1100 * time.Millisecond)
+	if rooms.IsValidUserRequest("aliens-on-earth", "dunha", secondID, conn) {
+		t.Fail()
+	}
+}
+
+func TestSessionExpiry(t *testing.T) {
+	rooms := config.NewCherryRooms()
+	rooms.AddRoom("aliens-on-earth", 1024)
+	rooms.SetSessionLifetime("aliens-on-earth", 1)
+	conn, peer := net.Pipe()
+	defer peer.Close()
+	rooms.AddUser("aliens-on-earth", "dunha", "0", false)
+	rooms.AddUser("aliens-on-earth", "mulder", "0", false)
+	rooms.SetUserConnection("aliens-on-earth", "mulder", conn)
+	time.Sleep(1100 * time.Millisecond)
+	rooms.ExpireSessions("aliens-on-earth")
+	if rooms.HasUser("aliens-on-earth", "dunha") {
+		t.Error("an expired user was kept.")
+	}
+	if message := nextMessage(rooms); message.From != "dunha" || !message.Notice {
+		t.Errorf("the exit was not announced: %+v", message)
+	}
+	if !rooms.HasUser("aliens-on-earth", "mulder") {
+		t.Error("a user reading the body was expired.")
+	}
+	joinAs(t, rooms, "dunha", "")
+	if !rooms.HasUser("aliens-on-earth", "dunha") {
+		t.Error("an expired user has not joined again.")
+	}
+}
+
+func TestExitSession(t *testing.T) {
+	rooms := config.NewCherryRooms()
+	rooms.AddRoom("aliens-on-earth", 1024)
+	rooms.SetExitMessage("aliens-on-earth", "has left...")
+	rooms.AddUser("aliens-on-earth", "dunha", "0", false)
+	if reply := getPage(t, rooms, "/exit&user=dunha&id=0123456789abcdef0123456789abcdef", reqtraps.GetExitHandle); !strings.HasPrefix(reply, "HTTP/1.1 404") ||
+		!rooms.HasUser("aliens-on-earth", "dunha") || rooms.HasPendingMessages("aliens-on-earth") {
+		t.Fatal("a forged exit has taken the user out.")
+	}
+	getPage(t, rooms, "/exit&user=dunha&id="+rooms.GetSessionID("dunha", "aliens-on-earth"), reqtraps.GetExitHandle)
+	if rooms.HasUser("aliens-on-earth", "dunha") {
+		t.Fatal("the user has not left.")
+	}
+	if message := nextMessage(rooms); message.From != "dunha" || message.Say != "has left..." {
+		t.Errorf("the exit was not announced: %+v", message)
+	}
+}
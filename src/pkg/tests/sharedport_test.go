@@ -0,0 +1,72 @@
+/*
+ *                                Copyright (C) 2016 by Rafael Santiago
+ *
+ * This is a free software. You can redistribute it and/or modify under
+ * the terms of the GNU General Public License version 2.
+ *
+ */
+package cherry_test
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net"
+	"pkg/config"
+	"pkg/html"
+	"pkg/rawhttp"
+	"pkg/reqtraps"
+	"strings"
+	"testing"
+)
+
+// routeRequest passes a request to the trap chosen by GetRequestTrap, as a listener bound to @roomName would do
+// (an empty @roomName is the shared port). It returns the room, the target left to the trap and the reply.
+func routeRequest(t *testing.T, rooms *config.CherryRooms, roomName, request string) (string, string, string) {
+	req, err := rawhttp.ReadRequest(bufio.NewReader(strings.NewReader(request + " HTTP/1.1\r\n\r\n")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	trap, routedRoom := reqtraps.GetRequestTrap(req, roomName, rooms)
+	conn, peer := net.Pipe()
+	go trap().Handle(conn, routedRoom, req, rooms, html.NewHTMLPreprocessor(rooms))
+	reply, _ := ioutil.ReadAll(peer)
+	peer.Close()
+	return routedRoom, req.Target, string(reply)
+}
+
+func TestSharedPortRouting(t *testing.T) {
+	rooms := config.NewCherryRooms()
+	rooms.SetSharedPort(8080)
+	rooms.AddRoom("aliens-on-earth", 8080)
+	rooms.AddRoom("martians", 8080)
+	rooms.AddRoom("venusians", 1024)
+	for _, roomName := range rooms.GetRooms() {
+		rooms.AddTemplate(roomName, "entrance", "entrance of {{.room-name}}")
+	}
+
+	roomName, target, reply := routeRequest(t, rooms, "", "GET /r/aliens-on-earth/join")
+	if roomName != "aliens-on-earth" || target != "/join" || !strings.HasPrefix(reply, "HTTP/1.1 200") ||
+		!strings.Contains(reply, "entrance of aliens-on-earth") {
+		t.Errorf("/r/aliens-on-earth/join was routed to %q, %q: %s", roomName, target, reply)
+	}
+	if roomName, target, _ = routeRequest(t, rooms, "", "GET /r/martians/join&invite=42"); roomName != "martians" || target != "/join&invite=42" {
+		t.Errorf("/r/martians/join&invite=42 was routed to %q, %q", roomName, target)
+	}
+
+	for _, request := range []string{
+		"GET /r/nowhere/join",
+		"GET /r/venusians/join",
+		"GET /r/aliens-on-earth",
+		"GET /join",
+	} {
+		if roomName, _, reply = routeRequest(t, rooms, "", request); len(roomName) > 0 || !strings.HasPrefix(reply, "HTTP/1.1 404") {
+			t.Errorf("%s was routed to %q: %s", request, roomName, reply)
+		}
+	}
+
+	//  INFO(Santiago): The rooms with their own ports do not use the prefix.
+	roomName, target, reply = routeRequest(t, rooms, "venusians", "GET /join")
+	if roomName != "venusians" || target != "/join" || !strings.Contains(reply, "entrance of venusians") {
+		t.Errorf("/join on the venusians' port was routed to %q, %q: %s", roomName, target, reply)
+	}
+}
@@ -0,0 +1,125 @@
+/*
+ *                                Copyright (C) 2016 by Rafael Santiago
+ *
+ * This is a free software. You can redistribute it and/or modify under
+ * the terms of the GNU General Public License version 2.
+ *
+ */
+package cherry_test
+
+import (
+	"pkg/config"
+	"pkg/html"
+	"testing"
+)
+
+func TestCompileTemplate(t *testing.T) {
+	valid := []string{
+		"",
+		"<html>{{.nickname}}</html>",
+		"{{if .allow-brief}}brief{{else}}no brief{{end}}",
+		"{{if not .allow-brief}}{{range .users}}{{.item | html}}{{end}}{{end}}",
+		"{{.room-name | url | html}}",
+		"function f() {{ return 1; }}",
+		"{{ unfinished",
+	}
+	for _, data := range valid {
+		if _, err := html.CompileTemplate(data); err != nil {
+			t.Errorf("CompileTemplate(%q) has failed: %v", data, err)
+		}
+	}
+	invalid := []string{
+		"{{if .allow-brief}}brief",
+		"{{range .users}}{{.item}}",
+		"{{end}}",
+		"{{else}}",
+		"{{range .users}}{{else}}{{end}}",
+		"{{if .allow-brief}}{{else}}{{else}}{{end}}",
+		"{{range .aliens}}{{end}}",
+		"{{if}}{{end}}",
+		"{{.nickname | upper}}",
+	}
+	for _, data := range invalid {
+		if _, err := html.CompileTemplate(data); err == nil {
+			t.Errorf("CompileTemplate(%q) should fail", data)
+		}
+	}
+}
+
+func TestTemplateExpansion(t *testing.T) {
+	rooms := config.NewCherryRooms()
+	rooms.AddRoom("aliens-on-earth", 1024)
+	rooms.AddUser("aliens-on-earth", "mulder", "2", true)
+	rooms.AddUser("aliens-on-earth", "dunha", "1", true)
+	rooms.AddAction("aliens-on-earth", "a01", "says to", "")
+	rooms.AddAction("aliens-on-earth", "a02", "screams to", "")
+	rooms.AddTemplate("aliens-on-earth", "top", "<b>{{.room-name}}</b>{{.top-template}}")
+	preprocessor := html.NewHTMLPreprocessor(rooms)
+	type testCtx struct {
+		data     string
+		expanded string
+	}
+	testVector := []testCtx{
+		{"{{.FoD}} Zzz...", "{{.FoD}} Zzz..."},
+		{"[{{.priv}}]", "[]"},
+		{"{{ .room-name }}", "aliens-on-earth"},
+		{"{{if .allow-brief}}brief{{else}}no brief{{end}}", "no brief"},
+		{"{{if not .allow-brief}}no brief{{end}}", "no brief"},
+		{"{{range .users}}<{{.item}}>{{end}}", "<dunha><mulder>"},
+		{"{{range .actions}}{{.item}}={{.item-label}};{{end}}", "a01=says to;a02=screams to;"},
+		{"{{range .users}}{{range .actions}}{{.item}}{{end}}:{{.item}} {{end}}", "a01a02:dunha a01a02:mulder "},
+		{"{{range .sounds}}never{{end}}", ""},
+		{"{{.item}}", "{{.item}}"},
+		{"{{.foo | html}}|{{.foo | url}}", "&lt;b&gt;a&amp;b&lt;/b&gt;|%3Cb%3Ea%26b%3C%2Fb%3E"},
+		{"{{.bar}}", "{{.nickname}}"},
+	}
+	preprocessor.SetDataValue("{{.foo}}", "<b>a&b</b>")
+	preprocessor.SetDataValue("{{.bar}}", "{{.nickname}}")
+	for _, test := range testVector {
+		if expanded := preprocessor.ExpandData("aliens-on-earth", test.data); expanded != test.expanded {
+			t.Errorf("ExpandData(%q) = %q", test.data, expanded)
+		}
+	}
+	rooms.SetAllowBrief("aliens-on-earth", true)
+	if preprocessor.ExpandData("aliens-on-earth", "{{if .allow-brief}}brief{{else}}no brief{{end}}") != "brief" {
+		t.Fail()
+	}
+	//  INFO(Santiago): A template including itself must not take the server down.
+	if preprocessor.ExpandData("aliens-on-earth", "{{.top-template}}") !=
+		"<b>aliens-on-earth</b><b>aliens-on-earth</b><b>aliens-on-earth</b><b>aliens-on-earth</b>"+
+			"<b>aliens-on-earth</b><b>aliens-on-earth</b><b>aliens-on-earth</b><b>aliens-on-earth</b>" {
+		t.Fail()
+	}
+}
+
+func TestCompiledTemplates(t *testing.T) {
+	rooms := config.NewCherryRooms()
+	rooms.AddRoom("aliens-on-earth", 1024)
+	if rooms.GetCompiledTemplate("aliens-on-earth", "{{.room-name}}") != nil {
+		t.Fail()
+	}
+	template, err := html.CompileTemplate("<i>{{.room-name}}</i>")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rooms.AddTemplate("aliens-on-earth", "top", "{{.room-name}}")
+	rooms.AddCompiledTemplate("aliens-on-earth", "{{.room-name}}", template)
+	if rooms.GetCompiledTemplate("aliens-on-earth", "{{.room-name}}") != template {
+		t.Fail()
+	}
+	//  INFO(Santiago): The compiled template kept by the room is executed instead of compiling the data again.
+	preprocessor := html.NewHTMLPreprocessor(rooms)
+	if preprocessor.ExpandData("aliens-on-earth", "{{.room-name}}") != "<i>aliens-on-earth</i>" {
+		t.Fail()
+	}
+	if preprocessor.ExpandData("aliens-on-earth", "{{.top-template}}") != "<i>aliens-on-earth</i>" {
+		t.Fail()
+	}
+	if preprocessor.ExpandData("aliens-on-earth", "<b>{{.room-name}}</b>") != "<b>aliens-on-earth</b>" {
+		t.Fail()
+	}
+	message := config.Message{From: "dunha", Say: "hi"}
+	if preprocessor.ExpandCompiledMessage("aliens-on-earth", message, html.MustCompileTemplate("{{.message-user}}")) != "dunha" {
+		t.Fail()
+	}
+}
@@ -0,0 +1,64 @@
+/*
+ *                                Copyright (C) 2016 by Rafael Santiago
+ *
+ * This is a free software. You can redistribute it and/or modify under
+ * the terms of the GNU General Public License version 2.
+ *
+ */
+package cherry_test
+
+import (
+	"encoding/json"
+	"pkg/config"
+	"pkg/history"
+	"pkg/transcript"
+	"testing"
+	"time"
+)
+
+func TestParseTranscriptTime(t *testing.T) {
+	if when, err := transcript.ParseTime(""); err != nil || !when.IsZero() {
+		t.Error("an empty time should mean no limit")
+	}
+	if when, err := transcript.ParseTime("1947-07-08 09:05"); err != nil || !when.Equal(time.Date(1947, time.July, 8, 9, 5, 0, 0, time.Local)) {
+		t.Error("unexpected time")
+	}
+	if when, err := transcript.ParseTime("1947-07-08T09:05:03Z"); err != nil || !when.Equal(time.Date(1947, time.July, 8, 9, 5, 3, 0, time.UTC)) {
+		t.Error("unexpected time")
+	}
+	if _, err := transcript.ParseTime("yesterday"); err == nil {
+		t.Error("an invalid time was accepted")
+	}
+}
+
+func TestExportTranscript(t *testing.T) {
+	rooms := config.NewCherryRooms()
+	rooms.AddRoom("aliens-on-earth", 1024)
+	rooms.AddAction("aliens-on-earth", "a01", "says to", "")
+	when := time.Date(1947, time.July, 8, 9, 5, 3, 0, time.Local)
+	entries := []history.Entry{
+		{Time: when, From: "dunha", Say: "joined...<script>scrollIt();</script>", Notice: true,
+			Formatted: "<p>dunha: joined...<script>scrollIt();</script>"},
+		{Time: when, From: "dunha", To: "EVERYBODY", Action: "a01", Say: "<b>hi</b>", Image: "http://localhost/abducted.gif",
+			Formatted: "<p>dunha says to EVERYBODY: &lt;b&gt;hi&lt;/b&gt;"},
+	}
+	text, err := transcript.Export(rooms, "aliens-on-earth", entries, "text")
+	if err != nil || text != "[1947-07-08 09:05:03] dunha: joined...\n"+
+		"[1947-07-08 09:05:03] dunha says to EVERYBODY: <b>hi</b> [image: http://localhost/abducted.gif]\n" {
+		t.Errorf("unexpected text transcript: %q", text)
+	}
+	page, err := transcript.Export(rooms, "aliens-on-earth", entries, "html")
+	if err != nil || page != "<html>\n<head><title>Transcript of \"aliens-on-earth\"</title></head>\n<body>\n"+
+		"<p>dunha: joined...\n<p>dunha says to EVERYBODY: &lt;b&gt;hi&lt;/b&gt;\n</body>\n</html>\n" {
+		t.Errorf("unexpected html transcript: %q", page)
+	}
+	data, err := transcript.Export(rooms, "aliens-on-earth", entries, "json")
+	var exported []map[string]interface{}
+	if err != nil || json.Unmarshal([]byte(data), &exported) != nil || len(exported) != 2 ||
+		exported[1]["action-label"] != "says to" || exported[0]["say"] != "joined..." {
+		t.Errorf("unexpected json transcript: %q", data)
+	}
+	if _, err = transcript.Export(rooms, "aliens-on-earth", entries, "pdf"); err == nil {
+		t.Error("an unknown format was accepted")
+	}
+}
@@ -0,0 +1,119 @@
+/*
+ *                                Copyright (C) 2016 by Rafael Santiago
+ *
+ * This is a free software. You can redistribute it and/or modify under
+ * the terms of the GNU General Public License version 2.
+ *
+ */
+package cherry_test
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net"
+	"pkg/config"
+	"pkg/html"
+	"pkg/rawhttp"
+	"pkg/reqtraps"
+	"strings"
+	"testing"
+	"time"
+)
+
+func getPage(t *testing.T, rooms *config.CherryRooms, target string, handle reqtraps.RequestTrapHandleFunc) string {
+	req, err := rawhttp.ReadRequest(bufio.NewReader(strings.NewReader("GET " + target + " HTTP/1.1\r\n\r\n")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, peer := net.Pipe()
+	go handle(conn, "aliens-on-earth", req, rooms, html.NewHTMLPreprocessor(rooms))
+	reply, _ := ioutil.ReadAll(peer)
+	peer.Close()
+	return string(reply)
+}
+
+// getWaitingTicket returns the ticket given by the waiting-line template used by these tests.
+func getWaitingTicket(reply string) string {
+	index := strings.Index(reply, "ticket=")
+	if index == -1 {
+		return ""
+	}
+	return strings.SplitN(reply[index+7:], ";", 2)[0]
+}
+
+func newFullRoom(waitingLine bool) *config.CherryRooms {
+	rooms := config.NewCherryRooms()
+	rooms.AddRoom("aliens-on-earth", 1024)
+	rooms.SetMaxUsers("aliens-on-earth", 1)
+	rooms.SetWaitingLine("aliens-on-earth", waitingLine)
+	rooms.AddTemplate("aliens-on-earth", "waiting-line", "ticket={{.waiting-ticket}};position={{.waiting-position}};")
+	rooms.AddUser("aliens-on-earth", "dunha", "0", false)
+	return rooms
+}
+
+func TestFullRoom(t *testing.T) {
+	rooms := newFullRoom(false)
+	if reply := joinAs(t, rooms, "mulder", ""); !strings.Contains(reply, html.GetRoomFullData()) || rooms.HasUser("aliens-on-earth", "mulder") ||
+		rooms.IsWaiting("aliens-on-earth", "mulder") {
+		t.Errorf("a full room has not refused a join: %s", reply)
+	}
+}
+
+func TestWaitingLine(t *testing.T) {
+	rooms := newFullRoom(true)
+	mulder := getWaitingTicket(joinAs(t, rooms, "mulder", ""))
+	scully := getWaitingTicket(joinAs(t, rooms, "scully", ""))
+	if len(mulder) == 0 || len(scully) == 0 || rooms.HasUser("aliens-on-earth", "mulder") {
+		t.Fatal("the waiting line has not given tickets.")
+	}
+	if rooms.GetWaitingPosition("aliens-on-earth", mulder) != 1 || rooms.GetWaitingPosition("aliens-on-earth", scully) != 2 {
+		t.Error("the waiting line is out of order.")
+	}
+	if !rooms.IsWaiting("aliens-on-earth", "scully") || !strings.HasPrefix(joinAs(t, rooms, "scully", ""), "HTTP/1.1 200") ||
+		rooms.GetWaitingPosition("aliens-on-earth", scully) != 2 {
+		t.Error("a nickname in the waiting line was taken.")
+	}
+	if reply := getPage(t, rooms, "/wait&ticket="+scully+"&", reqtraps.GetWaitHandle); !strings.Contains(reply, "position=2;") {
+		t.Errorf("the waiting-line template was not sent: %s", reply)
+	}
+
+	if len(rooms.ClaimAdmission("aliens-on-earth", mulder)) > 0 {
+		t.Error("somebody has entered a full room.")
+	}
+	rooms.RemoveUser("aliens-on-earth", "dunha")
+	if rooms.GetWaitingPosition("aliens-on-earth", mulder) != 0 || rooms.GetWaitingPosition("aliens-on-earth", scully) != 1 {
+		t.Error("the first one in the line was not admitted.")
+	}
+	if len(rooms.ClaimAdmission("aliens-on-earth", scully)) > 0 {
+		t.Error("somebody has jumped the line.")
+	}
+	getPage(t, rooms, "/wait&ticket="+mulder+"&", reqtraps.GetWaitHandle)
+	if !rooms.HasUser("aliens-on-earth", "mulder") {
+		t.Fatal("the admitted one has not entered the room.")
+	}
+	nextMessage(rooms)
+	if reply := getPage(t, rooms, "/wait&ticket="+mulder+"&", reqtraps.GetWaitHandle); !strings.HasPrefix(reply, "HTTP/1.1 404") {
+		t.Error("a ticket was claimed twice.")
+	}
+}
+
+func TestWaitingLineTimeout(t *testing.T) {
+	rooms := newFullRoom(true)
+	rooms.SetWaitingLineTimeout("aliens-on-earth", 1)
+	mulder := rooms.EnqueueWaitingUser("aliens-on-earth", "mulder", "0")
+	scully := rooms.EnqueueWaitingUser("aliens-on-earth", "scully", "0")
+	time.Sleep(600 * time.Millisecond)
+	rooms.GetWaitingPosition("aliens-on-earth", scully)
+	time.Sleep(600 * time.Millisecond)
+	if rooms.GetWaitingPosition("aliens-on-earth", mulder) != 0 || rooms.IsWaiting("aliens-on-earth", "mulder") ||
+		rooms.GetWaitingPosition("aliens-on-earth", scully) != 1 {
+		t.Fatal("who stopped waiting was kept in the line.")
+	}
+
+	rooms.RemoveUser("aliens-on-earth", "dunha")
+	time.Sleep(1100 * time.Millisecond)
+	if rooms.IsWaiting("aliens-on-earth", "scully") || !rooms.AdmitUser("aliens-on-earth", "agent smith", "0", false) ||
+		len(rooms.ClaimAdmission("aliens-on-earth", scully)) > 0 {
+		t.Error("who did not show up has kept the place.")
+	}
+}
@@ -0,0 +1,94 @@
+/*
+ *                                Copyright (C) 2016 by Rafael Santiago
+ *
+ * This is a free software. You can redistribute it and/or modify under
+ * the terms of the GNU General Public License version 2.
+ *
+ */
+package cherry_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"pkg/config"
+	"pkg/webhooks"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWebhooks(t *testing.T) {
+	posted := make(chan webhooks.Payload, 16)
+	var failOnce sync.Once
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		failed := false
+		failOnce.Do(func() {
+			failed = true
+		})
+		if failed {
+			//  INFO(Santiago): The first post fails, the dispatcher must try it again.
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var payload webhooks.Payload
+		if r.Method != "POST" || r.Header.Get("Content-Type") != "application/json" || json.NewDecoder(r.Body).Decode(&payload) != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		posted <- payload
+	}))
+	defer server.Close()
+
+	dispatcher := webhooks.NewDispatcher([]webhooks.Hook{{URL: server.URL + "/talk", Events: []string{"message", "ignore"}},
+		{URL: server.URL + "/door", Events: []string{"join"}}})
+	dispatcher.SetRetryPolicy(3, 10*time.Millisecond)
+	defer dispatcher.Stop()
+	rooms := config.NewCherryRooms()
+	rooms.AddRoom("aliens-on-earth", 1024)
+	rooms.AddAction("aliens-on-earth", "a01", "talks to", "")
+	rooms.AddUser("aliens-on-earth", "mulder", "0", false)
+	rooms.SetWebhooks("aliens-on-earth", dispatcher)
+
+	rooms.AddUser("aliens-on-earth", "dunha", "0", false)
+	rooms.AddToIgnoreList("dunha", "mulder", "aliens-on-earth")
+	rooms.NotifyObservers("aliens-on-earth", config.RoomEvent{Kind: config.MessageDelivered,
+		Message: config.Message{From: "dunha", To: "mulder", Say: "psst", Priv: "1"}})
+	rooms.NotifyObservers("aliens-on-earth", config.RoomEvent{Kind: config.MessageDelivered,
+		Message: config.Message{From: "dunha", Say: "joined...", Notice: true}})
+	rooms.NotifyObservers("aliens-on-earth", config.RoomEvent{Kind: config.MessageDelivered,
+		Message: config.Message{From: "dunha", To: "EVERYBODY", Action: "a01", Say: "Take me to your leader!"}})
+	rooms.RemoveUser("aliens-on-earth", "dunha")
+
+	received := make(map[string]webhooks.Payload)
+	for len(received) < 3 {
+		select {
+		case payload := <-posted:
+			if _, duplicated := received[payload.Event]; duplicated {
+				t.Errorf("%s posted twice.", payload.Event)
+			}
+			received[payload.Event] = payload
+		case <-time.After(2 * time.Second):
+			t.Fatalf("only %d events were posted.", len(received))
+		}
+	}
+	if payload := received["message"]; payload.Room != "aliens-on-earth" || payload.User != "dunha" ||
+		payload.ActionLabel != "talks to" || payload.Says != "Take me to your leader!" {
+		t.Errorf("message: %+v", payload)
+	}
+	if payload := received["ignore"]; payload.User != "dunha" || payload.Ignored != "mulder" {
+		t.Errorf("ignore: %+v", payload)
+	}
+	if payload := received["join"]; payload.User != "dunha" || payload.Time.IsZero() {
+		t.Errorf("join: %+v", payload)
+	}
+	select {
+	case payload := <-posted:
+		t.Errorf("%+v should not be posted.", payload)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	if _, err := webhooks.ParseEvents("message, abduction"); err == nil {
+		t.Error("unknown event accepted.")
+	}
+}
@@ -0,0 +1,76 @@
+/*
+ *                                Copyright (C) 2016 by Rafael Santiago
+ *
+ * This is a free software. You can redistribute it and/or modify under
+ * the terms of the GNU General Public License version 2.
+ *
+ */
+package cherry_test
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"pkg/websocket"
+	"testing"
+)
+
+func maskedFrame(opcode byte, fin bool, payload string) []byte {
+	var frame []byte
+	var first = opcode
+	if fin {
+		first |= 0x80
+	}
+	mask := []byte{0x37, 0xfa, 0x21, 0x3d}
+	frame = append(frame, first, 0x80|byte(len(payload)))
+	frame = append(frame, mask...)
+	for p := 0; p < len(payload); p++ {
+		frame = append(frame, payload[p]^mask[p%4])
+	}
+	return frame
+}
+
+func TestWebSocketAcceptKey(t *testing.T) {
+	//  INFO(Santiago): Sample from RFC 6455, section 1.3.
+	if websocket.AcceptKey("dGhlIHNhbXBsZSBub25jZQ==") != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fail()
+	}
+}
+
+func TestWebSocketFraming(t *testing.T) {
+	server, client := net.Pipe()
+	wsConn := websocket.NewConn(server)
+	go func() {
+		client.Write(maskedFrame(0x1, false, "says=hel"))
+		client.Write(maskedFrame(0x9, true, "ping"))
+		client.Write(maskedFrame(0x0, true, "lo"))
+	}()
+	clientReader := bufio.NewReader(client)
+	pong := make(chan []byte)
+	go func() {
+		frame := make([]byte, 6)
+		io.ReadFull(clientReader, frame)
+		pong <- frame
+	}()
+	message, err := wsConn.ReadMessage()
+	if err != nil || string(message) != "says=hello" {
+		t.Fatal(err)
+	}
+	if frame := <-pong; frame[0] != 0x8a || frame[1] != 4 || string(frame[2:]) != "ping" {
+		t.Fail()
+	}
+	go wsConn.Write([]byte("<p>hello</p>"))
+	frame := make([]byte, 14)
+	if _, err = io.ReadFull(clientReader, frame); err != nil {
+		t.Fatal(err)
+	}
+	if frame[0] != 0x81 || frame[1] != 12 || string(frame[2:]) != "<p>hello</p>" {
+		t.Fail()
+	}
+	go client.Write([]byte{0x81, 0x02, 'h', 'i'})
+	if _, err = wsConn.ReadMessage(); err != websocket.ErrProtocol {
+		t.Fail()
+	}
+	client.Close()
+	server.Close()
+}
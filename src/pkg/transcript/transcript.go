@@ -0,0 +1,160 @@
+/*
+Package transcript renders the history of a room for the ones that need to read it later.
+--
+ *                               Copyright (C) 2015 by Rafael Santiago
+ *
+ * This is a free software. You can redistribute it and/or modify under
+ * the terms of the GNU General Public License version 2.
+ *
+*/
+package transcript
+
+import (
+	"encoding/json"
+	"fmt"
+	stdhtml "html"
+	"pkg/config"
+	"pkg/history"
+	"pkg/html"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// timeLayouts are the accepted ways of writing the limits of a transcript.
+var timeLayouts = []string{
+	time.RFC3339,
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+var scriptElement = regexp.MustCompile(`(?is)<script[^>]*>.*?</script>`)
+
+var markupTag = regexp.MustCompile(`<[^>]*>`)
+
+// jsonEntry is how an entry is exported as JSON.
+type jsonEntry struct {
+	Time        time.Time `json:"time"`
+	From        string    `json:"from"`
+	To          string    `json:"to,omitempty"`
+	Action      string    `json:"action,omitempty"`
+	ActionLabel string    `json:"action-label,omitempty"`
+	Say         string    `json:"say,omitempty"`
+	Image       string    `json:"image,omitempty"`
+	Sound       string    `json:"sound,omitempty"`
+}
+
+// IsValidFormat verifies if a transcript can be exported in a format.
+func IsValidFormat(format string) bool {
+	return format == "html" || format == "json" || format == "text"
+}
+
+// GetContentType returns the content type of a format.
+func GetContentType(format string) string {
+	switch format {
+	case "json":
+		return "application/json"
+
+	case "text":
+		return "text/plain; charset=utf-8"
+	}
+	return "text/html"
+}
+
+// ParseTime parses a limit of a transcript. It can be written as "2006-01-02", "2006-01-02 15:04",
+// "2006-01-02 15:04:05" (all in the server local time) or in RFC 3339. An empty string means no limit.
+func ParseTime(value string) (time.Time, error) {
+	if len(value) == 0 {
+		return time.Time{}, nil
+	}
+	for _, layout := range timeLayouts {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time \"%s\"", value)
+}
+
+// Export renders the history entries of a room. The HTML format is made of the messages as they were
+// formatted by the room's action templates, the other formats are neutral.
+func Export(rooms *config.CherryRooms, roomName string, entries []history.Entry, format string) (string, error) {
+	switch format {
+	case "html":
+		return exportHTML(roomName, entries), nil
+
+	case "json":
+		return exportJSON(rooms, roomName, entries)
+
+	case "text":
+		return exportText(rooms, roomName, entries), nil
+	}
+	return "", fmt.Errorf("unknown transcript format \"%s\"", format)
+}
+
+func exportHTML(roomName string, entries []history.Entry) string {
+	var transcript strings.Builder
+	transcript.WriteString("<html>\n<head><title>Transcript of \"" + html.Escape(roomName) + "\"</title></head>\n<body>\n")
+	for _, entry := range entries {
+		//  INFO(Santiago): The scripts were written for the room's body, out of there they are useless.
+		transcript.WriteString(scriptElement.ReplaceAllString(entry.Formatted, "") + "\n")
+	}
+	transcript.WriteString("</body>\n</html>\n")
+	return transcript.String()
+}
+
+func exportJSON(rooms *config.CherryRooms, roomName string, entries []history.Entry) (string, error) {
+	jsonEntries := make([]jsonEntry, 0, len(entries))
+	for _, entry := range entries {
+		jsonEntries = append(jsonEntries, jsonEntry{entry.Time, entry.From, entry.To, entry.Action,
+			getActionLabel(rooms, roomName, entry.Action), getPlainSay(entry), entry.Image, entry.Sound})
+	}
+	var data strings.Builder
+	encoder := json.NewEncoder(&data)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(jsonEntries); err != nil {
+		return "", err
+	}
+	return data.String(), nil
+}
+
+func exportText(rooms *config.CherryRooms, roomName string, entries []history.Entry) string {
+	var transcript strings.Builder
+	for _, entry := range entries {
+		line := "[" + entry.Time.Local().Format("2006-01-02 15:04:05") + "] "
+		if entry.Action == config.MeAction {
+			line += "* " + entry.From + " " + getPlainSay(entry)
+		} else {
+			line += entry.From
+			if label := getActionLabel(rooms, roomName, entry.Action); len(label) > 0 {
+				line += " " + label + " " + entry.To
+			}
+			line += ": " + getPlainSay(entry)
+		}
+		if len(entry.Image) > 0 {
+			line += " [image: " + entry.Image + "]"
+		}
+		if len(entry.Sound) > 0 {
+			line += " [sound: " + entry.Sound + "]"
+		}
+		transcript.WriteString(line + "\n")
+	}
+	return transcript.String()
+}
+
+func getActionLabel(rooms *config.CherryRooms, roomName, action string) string {
+	if len(action) == 0 || !rooms.HasRoom(roomName) || !rooms.HasAction(roomName, action) {
+		return ""
+	}
+	return rooms.GetRoomActionLabel(roomName, action)
+}
+
+// getPlainSay returns what was said without markup. The notices are written in HTML by the room owner
+// while the users' messages are taken as they were typed.
+func getPlainSay(entry history.Entry) string {
+	if !entry.Notice {
+		return entry.Say
+	}
+	return stdhtml.UnescapeString(markupTag.ReplaceAllString(scriptElement.ReplaceAllString(entry.Say, ""), ""))
+}
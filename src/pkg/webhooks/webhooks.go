@@ -0,0 +1,242 @@
+/*
+Package webhooks posts the events of the rooms to other places.
+--
+ *                               Copyright (C) 2015 by Rafael Santiago
+ *
+ * This is a free software. You can redistribute it and/or modify under
+ * the terms of the GNU General Public License version 2.
+ *
+*/
+package webhooks
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"pkg/config"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Hook is an URL that receives some kinds of events ("message", "join", "exit" and "ignore").
+type Hook struct {
+	URL    string
+	Events []string
+}
+
+// Payload is what is posted (as JSON) for each event. Its event is "message", "join", "exit", "ignore" or "deignore"
+// (the hooks that want "ignore" receive both).
+type Payload struct {
+	Room        string    `json:"room"`
+	Event       string    `json:"event"`
+	Time        time.Time `json:"time"`
+	User        string    `json:"user"`
+	To          string    `json:"to,omitempty"`
+	Action      string    `json:"action,omitempty"`
+	ActionLabel string    `json:"action-label,omitempty"`
+	Says        string    `json:"says,omitempty"`
+	Image       string    `json:"image,omitempty"`
+	Sound       string    `json:"sound,omitempty"`
+	Ignored     string    `json:"ignored,omitempty"`
+}
+
+// Dispatcher posts the events of a room to its hooks. The events are queued and posted by a background
+// worker, so nobody in the room waits for the network. When the queue is full the new events are dropped.
+type Dispatcher struct {
+	hooks       []Hook
+	queue       chan delivery
+	quit        chan bool
+	client      *http.Client
+	maxAttempts int
+	backoff     time.Duration
+	start       *sync.Once
+	stop        *sync.Once
+}
+
+// delivery is an event being posted to a hook. Before the first attempt it only has the event.
+type delivery struct {
+	event   config.RoomEvent
+	hook    *Hook
+	payload []byte
+	attempt int
+}
+
+// maxQueuedDeliveries is how many deliveries can wait for the worker.
+const maxQueuedDeliveries = 256
+
+// postTimeout is how long a hook has to answer a post.
+const postTimeout = 10 * time.Second
+
+// IsValidEvent verifies if a hook can ask for a kind of event.
+func IsValidEvent(event string) bool {
+	return event == "message" || event == "join" || event == "exit" || event == "ignore"
+}
+
+// NewDispatcher creates a dispatcher for @hooks. A failed post is tried up to five times, waiting one second
+// before the second attempt and doubling this time for each next one.
+func NewDispatcher(hooks []Hook) *Dispatcher {
+	return &Dispatcher{hooks: hooks,
+		queue:       make(chan delivery, maxQueuedDeliveries),
+		quit:        make(chan bool),
+		client:      &http.Client{Timeout: postTimeout},
+		maxAttempts: 5,
+		backoff:     time.Second,
+		start:       new(sync.Once),
+		stop:        new(sync.Once)}
+}
+
+// SetRetryPolicy changes how many times a post is tried and the wait before the second attempt.
+func (d *Dispatcher) SetRetryPolicy(maxAttempts int, backoff time.Duration) {
+	d.maxAttempts = maxAttempts
+	d.backoff = backoff
+}
+
+// Notify queues an event. The worker starts with the first event.
+func (d *Dispatcher) Notify(event config.RoomEvent) {
+	d.start.Do(func() {
+		go d.run()
+	})
+	d.enqueue(delivery{event: event})
+}
+
+// Stop makes the worker give up, the queued events are lost.
+func (d *Dispatcher) Stop() {
+	d.stop.Do(func() {
+		close(d.quit)
+	})
+}
+
+func (d *Dispatcher) enqueue(work delivery) {
+	select {
+	case <-d.quit:
+	case d.queue <- work:
+	default:
+		fmt.Printf("WARN: the webhooks queue of \"%s\" is full, an event was dropped.\n", work.event.RoomName)
+	}
+}
+
+func (d *Dispatcher) run() {
+	for {
+		select {
+		case <-d.quit:
+			return
+
+		case work := <-d.queue:
+			if work.hook == nil {
+				d.dispatch(work.event)
+			} else {
+				d.post(work)
+			}
+		}
+	}
+}
+
+// dispatch posts an event to the hooks that asked for it.
+func (d *Dispatcher) dispatch(event config.RoomEvent) {
+	payload, kind := makePayload(event)
+	if payload == nil {
+		return
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return
+	}
+	for h := range d.hooks {
+		for _, wanted := range d.hooks[h].Events {
+			if wanted == kind {
+				d.post(delivery{event: event, hook: &d.hooks[h], payload: data, attempt: 1})
+				break
+			}
+		}
+	}
+}
+
+// post tries to deliver a payload. A failure is tried again later, without holding the worker.
+func (d *Dispatcher) post(work delivery) {
+	request, err := http.NewRequest("POST", work.hook.URL, bytes.NewReader(work.payload))
+	if err == nil {
+		request.Header.Set("Content-Type", "application/json")
+		request.Header.Set("User-Agent", "cherry")
+		var response *http.Response
+		response, err = d.client.Do(request)
+		if err == nil {
+			response.Body.Close()
+			if response.StatusCode < 200 || response.StatusCode > 299 {
+				err = fmt.Errorf("status %d", response.StatusCode)
+			}
+		}
+	}
+	if err == nil {
+		return
+	}
+	if work.attempt >= d.maxAttempts {
+		fmt.Printf("ERROR: unable to post an event of \"%s\" to \"%s\" [more details: %s].\n", work.event.RoomName, work.hook.URL, err)
+		return
+	}
+	wait := d.backoff << uint(work.attempt-1)
+	work.attempt++
+	time.AfterFunc(wait, func() {
+		d.enqueue(work)
+	})
+}
+
+// makePayload describes an event. It returns nil for the events that are not posted (private messages and notices).
+func makePayload(event config.RoomEvent) (*Payload, string) {
+	payload := &Payload{Room: event.RoomName, Time: event.Time, User: event.User}
+	var kind string
+	switch event.Kind {
+	case config.MessageDelivered:
+		message := event.Message
+		if message.Priv == "1" || message.Notice {
+			return nil, ""
+		}
+		kind = "message"
+		payload.Time = message.Time
+		payload.User = message.From
+		payload.To = message.To
+		payload.Action = message.Action
+		if event.Rooms.HasRoom(event.RoomName) && event.Rooms.HasAction(event.RoomName, message.Action) {
+			payload.ActionLabel = event.Rooms.GetRoomActionLabel(event.RoomName, message.Action)
+		}
+		payload.Says = message.Say
+		payload.Image = message.Image
+		payload.Sound = message.Sound
+		break
+
+	case config.UserJoined:
+		kind = "join"
+		break
+
+	case config.UserLeft:
+		kind = "exit"
+		break
+
+	case config.UserIgnored, config.UserDeignored:
+		kind = "ignore"
+		payload.Ignored = event.Target
+		break
+
+	default:
+		return nil, ""
+	}
+	payload.Event = kind
+	if event.Kind == config.UserDeignored {
+		payload.Event = "deignore"
+	}
+	return payload, kind
+}
+
+// ParseEvents parses a comma separated list of events (e.g. "message, join").
+func ParseEvents(list string) ([]string, error) {
+	var events []string
+	for _, event := range strings.Split(list, ",") {
+		event = strings.TrimSpace(event)
+		if !IsValidEvent(event) {
+			return nil, fmt.Errorf("unknown event \"%s\"", event)
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
@@ -0,0 +1,218 @@
+/*
+Package websocket implements the server side of the WebSocket protocol (RFC 6455) used by Cherry.
+--
+ *                               Copyright (C) 2015 by Rafael Santiago
+ *
+ * This is a free software. You can redistribute it and/or modify under
+ * the terms of the GNU General Public License version 2.
+ *
+*/
+package websocket
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"pkg/rawhttp"
+	"strings"
+	"sync"
+)
+
+// MaxMessageSize is the maximum size (in bytes) accepted for a client message.
+const MaxMessageSize = 65536
+
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xa
+)
+
+// ErrProtocol is returned by ReadMessage when the peer breaks the WebSocket framing rules.
+var ErrProtocol = errors.New("websocket protocol error")
+
+// ErrMessageTooLarge is returned by ReadMessage when a client message exceeds MaxMessageSize.
+var ErrMessageTooLarge = errors.New("websocket message too large")
+
+// Conn is a net.Conn where each Write is sent as a WebSocket text frame.
+type Conn struct {
+	net.Conn
+	reader     *bufio.Reader
+	writeMutex *sync.Mutex
+	closed     bool
+}
+
+// IsUpgradeRequest verifies if the request is a valid WebSocket opening handshake.
+func IsUpgradeRequest(req *rawhttp.Request) bool {
+	return req.Method == "GET" &&
+		strings.ToLower(req.GetHeader("Upgrade")) == "websocket" &&
+		strings.Contains(strings.ToLower(req.GetHeader("Connection")), "upgrade") &&
+		req.GetHeader("Sec-WebSocket-Version") == "13" &&
+		len(req.GetHeader("Sec-WebSocket-Key")) > 0
+}
+
+// AcceptKey computes the Sec-WebSocket-Accept value for a Sec-WebSocket-Key.
+func AcceptKey(key string) string {
+	digest := sha1.Sum([]byte(key + websocketGUID))
+	return base64.StdEncoding.EncodeToString(digest[:])
+}
+
+// MakeHandshakeReplyBuffer assembles the reply that accepts the WebSocket opening handshake.
+func MakeHandshakeReplyBuffer(req *rawhttp.Request) []byte {
+	return []byte("HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + AcceptKey(req.GetHeader("Sec-WebSocket-Key")) + "\r\n" +
+		"Server: Cherry/0.1\r\n\r\n")
+}
+
+// NewConn wraps a connection which already has finished the opening handshake.
+func NewConn(conn net.Conn) *Conn {
+	return &Conn{conn, bufio.NewReader(conn), new(sync.Mutex), false}
+}
+
+// Write sends @data as one text frame.
+func (c *Conn) Write(data []byte) (int, error) {
+	if err := c.writeFrame(opText, data); err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
+
+// Close sends a close frame (if it was not sent yet) and closes the underlying connection.
+func (c *Conn) Close() error {
+	c.writeFrame(opClose, nil)
+	return c.Conn.Close()
+}
+
+// ReadMessage returns the next data message sent by the client. Control frames are handled here.
+func (c *Conn) ReadMessage() ([]byte, error) {
+	var message []byte
+	var fragmented bool
+	for {
+		fin, opcode, payload, err := c.readFrame()
+		if err != nil {
+			return nil, err
+		}
+		switch opcode {
+		case opPing:
+			c.writeFrame(opPong, payload)
+			continue
+
+		case opPong:
+			continue
+
+		case opClose:
+			c.writeFrame(opClose, nil)
+			return nil, io.EOF
+
+		case opText, opBinary:
+			if fragmented {
+				return nil, ErrProtocol
+			}
+			message = payload
+			break
+
+		case opContinuation:
+			if !fragmented {
+				return nil, ErrProtocol
+			}
+			message = append(message, payload...)
+			break
+
+		default:
+			return nil, ErrProtocol
+		}
+		if len(message) > MaxMessageSize {
+			return nil, ErrMessageTooLarge
+		}
+		if fin {
+			return message, nil
+		}
+		fragmented = true
+	}
+}
+
+func (c *Conn) readFrame() (bool, byte, []byte, error) {
+	var header [2]byte
+	if _, err := io.ReadFull(c.reader, header[:]); err != nil {
+		return false, 0, nil, err
+	}
+	fin := (header[0] & 0x80) != 0
+	opcode := header[0] & 0x0f
+	if (header[1] & 0x80) == 0 {
+		//  INFO(Santiago): Frames sent by clients must be always masked.
+		return false, 0, nil, ErrProtocol
+	}
+	length := uint64(header[1] & 0x7f)
+	switch length {
+	case 126:
+		var extLength [2]byte
+		if _, err := io.ReadFull(c.reader, extLength[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(extLength[:]))
+		break
+
+	case 127:
+		var extLength [8]byte
+		if _, err := io.ReadFull(c.reader, extLength[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(extLength[:])
+		break
+	}
+	if opcode >= opClose && (length > 125 || !fin) {
+		return false, 0, nil, ErrProtocol
+	}
+	if length > MaxMessageSize {
+		return false, 0, nil, ErrMessageTooLarge
+	}
+	var mask [4]byte
+	if _, err := io.ReadFull(c.reader, mask[:]); err != nil {
+		return false, 0, nil, err
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(c.reader, payload); err != nil {
+		return false, 0, nil, err
+	}
+	for p := range payload {
+		payload[p] ^= mask[p%4]
+	}
+	return fin, opcode, payload, nil
+}
+
+func (c *Conn) writeFrame(opcode byte, payload []byte) error {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	if c.closed {
+		return net.ErrClosed
+	}
+	if opcode == opClose {
+		c.closed = true
+	}
+	var frame []byte
+	frame = append(frame, 0x80|opcode)
+	length := len(payload)
+	if length <= 125 {
+		frame = append(frame, byte(length))
+	} else if length <= 0xffff {
+		frame = append(frame, 126, byte(length>>8), byte(length))
+	} else {
+		var extLength [8]byte
+		binary.BigEndian.PutUint64(extLength[:], uint64(length))
+		frame = append(frame, 127)
+		frame = append(frame, extLength[:]...)
+	}
+	frame = append(frame, payload...)
+	_, err := c.Conn.Write(frame)
+	return err
+}